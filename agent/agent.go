@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -16,17 +19,23 @@ import (
 	"github.com/netboxlabs/orb-agent/agent/backend"
 	"github.com/netboxlabs/orb-agent/agent/config"
 	manager "github.com/netboxlabs/orb-agent/agent/policyMgr"
+	"github.com/netboxlabs/orb-agent/agent/redact"
+	"github.com/netboxlabs/orb-agent/agent/secrets"
 	"github.com/netboxlabs/orb-agent/agent/version"
 )
 
 const routineKey config.ContextKey = "routine"
 
+// ErrMqttConnection is returned when the agent fails to connect to the control plane broker
+var ErrMqttConnection = errors.New("failed to connect to a broker")
+
 // Agent is the interface that all agents must implement
 type Agent interface {
 	Start(ctx context.Context, cancelFunc context.CancelFunc) error
 	Stop(ctx context.Context)
 	RestartAll(ctx context.Context, reason string) error
 	RestartBackend(ctx context.Context, backend string, reason string) error
+	Reload(ctx context.Context, c config.Config) error
 }
 
 type orbAgent struct {
@@ -36,30 +45,55 @@ type orbAgent struct {
 	agentID           string
 	backends          map[string]backend.Backend
 	backendState      map[string]*backend.State
+	backendStateMutex sync.RWMutex
 	backendsCommon    config.BackendCommons
 	cancelFunction    context.CancelFunc
 	rpcFromCancelFunc context.CancelFunc
 
 	asyncContext context.Context
 
+	// guards the heartbeat routine fields, replaced on logon and cleared by the routine itself
+	heartbeatMutex  sync.Mutex
 	hbTicker        *time.Ticker
 	heartbeatCtx    context.Context
 	heartbeatCancel context.CancelFunc
+	// closed once the heartbeat routine returned
+	heartbeatDone chan struct{}
+	// unix nanoseconds of the last heartbeat routine tick, used for liveness
+	lastAlive atomic.Int64
+	// set once Stop was called
+	stopping atomic.Bool
 
 	// Agent RPC channel, configured from command line
-	baseTopic        string
-	rpcFromCoreTopic string
-	heartbeatsTopic  string
+	baseTopic         string
+	rpcToCoreTopic    string
+	rpcFromCoreTopic  string
+	capabilitiesTopic string
+	heartbeatsTopic   string
 
 	// Retry Mechanism to ensure the Request is received
 	groupRequestSucceeded  context.CancelFunc
 	policyRequestSucceeded context.CancelFunc
+	retryMutex             sync.Mutex
+	requestRetries         requestRetries
 
 	// AgentGroup channels sent from core
 	groupsInfos map[string]groupInfo
+	groupsMutex sync.RWMutex
+
+	// serializes handling of RPCs received from core
+	rpcMutex sync.Mutex
+	// RPCs received from core, waiting to be handled
+	rpcs *rpcQueue
 
 	policyManager manager.PolicyManager
 	configManager config.Manager
+	watchdog      *watchdog
+	mqttConfig    config.MQTTConfig
+
+	// local status and control API, only started when an address is configured
+	apiServer *http.Server
+	startTime time.Time
 }
 
 type groupInfo struct {
@@ -80,9 +114,16 @@ func New(logger *zap.Logger, c config.Config) (Agent, error) {
 		logger.Error("policy manager failed to get repository", zap.Error(err))
 		return nil, err
 	}
+	if err := c.OrbAgent.RestartPolicy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid restart policy: %w", err)
+	}
+	if err := c.OrbAgent.Timing.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid timing: %w", err)
+	}
 	cm := config.New(logger, c.OrbAgent.ConfigManager)
 
-	return &orbAgent{logger: logger, config: c, policyManager: pm, configManager: cm, groupsInfos: make(map[string]groupInfo)}, nil
+	return &orbAgent{logger: logger, config: c, policyManager: pm, configManager: cm, watchdog: newWatchdog(c.OrbAgent.RestartPolicy),
+		rpcs: newRPCQueue(), groupsInfos: make(map[string]groupInfo)}, nil
 }
 
 func (a *orbAgent) managePolicies() error {
@@ -96,31 +137,38 @@ func (a *orbAgent) managePolicies() error {
 			return errors.New("backend not found: " + beName)
 		}
 		for pName, data := range policy {
-			id := uuid.NewString()
-			payload := fleet.AgentPolicyRPCPayload{Action: "manage", Name: pName, DatasetID: id, Backend: beName, Version: 1, Data: data}
-			a.policyManager.ManagePolicy(payload)
+			a.applyLocalPolicy(beName, pName, data)
 		}
 
 	}
 	return nil
 }
 
+// decodeCommons decodes the settings shared by every backend and resolves their secret references
+func (a *orbAgent) decodeCommons(backends map[string]map[string]interface{}) (config.BackendCommons, error) {
+	var commonConfig config.BackendCommons
+	if v, prs := backends["common"]; prs {
+		if err := mapstructure.Decode(v, &commonConfig); err != nil {
+			return commonConfig, fmt.Errorf("failed to decode common backend config: %w", err)
+		}
+	}
+	commonConfig.Otel.AgentTags = a.config.OrbAgent.Tags
+	return secrets.NewResolver(a.logger, a.config.OrbAgent.Secrets.Strict).ResolveCommons(commonConfig)
+}
+
 func (a *orbAgent) startBackends(agentCtx context.Context) error {
 	a.logger.Info("registered backends", zap.Strings("values", backend.GetList()))
-	a.logger.Info("requested backends", zap.Any("values", a.config.OrbAgent.Backends))
+	a.logger.Info("requested backends", zap.Any("values", redact.Data(a.config.OrbAgent.Backends)))
 	if len(a.config.OrbAgent.Backends) == 0 {
 		return errors.New("no backends specified")
 	}
 	a.backends = make(map[string]backend.Backend, len(a.config.OrbAgent.Backends))
 	a.backendState = make(map[string]*backend.State)
 
-	var commonConfig config.BackendCommons
-	if v, prs := a.config.OrbAgent.Backends["common"]; prs {
-		if err := mapstructure.Decode(v, &commonConfig); err != nil {
-			return fmt.Errorf("failed to decode common backend config: %w", err)
-		}
+	commonConfig, err := a.decodeCommons(a.config.OrbAgent.Backends)
+	if err != nil {
+		return err
 	}
-	commonConfig.Otel.AgentTags = a.config.OrbAgent.Tags
 	a.backendsCommon = commonConfig
 	delete(a.config.OrbAgent.Backends, "common")
 
@@ -138,22 +186,26 @@ func (a *orbAgent) startBackends(agentCtx context.Context) error {
 		backendCtx := context.WithValue(agentCtx, routineKey, name)
 		backendCtx = a.configManager.GetContext(backendCtx)
 		a.backends[name] = be
+		a.watchBackend(name, be)
+		if tuner, ok := be.(backend.ReadinessTuner); ok {
+			tuner.SetReadiness(a.config.OrbAgent.Timing.ForBackend(name).Readiness)
+		}
 		initialState := be.GetInitialState()
-		a.backendState[name] = &backend.State{
+		a.setBackendState(name, backend.State{
 			Status:        initialState,
 			LastRestartTS: time.Now(),
-		}
+		})
 		if err := be.Start(context.WithCancel(backendCtx)); err != nil {
 			a.logger.Info("failed to start backend", zap.String("backend", name), zap.Error(err))
 			var errMessage string
 			if initialState == backend.BackendError {
 				errMessage = err.Error()
 			}
-			a.backendState[name] = &backend.State{
+			a.setBackendState(name, backend.State{
 				Status:        initialState,
 				LastError:     errMessage,
 				LastRestartTS: time.Now(),
-			}
+			})
 			return err
 		}
 	}
@@ -162,6 +214,7 @@ func (a *orbAgent) startBackends(agentCtx context.Context) error {
 
 func (a *orbAgent) Start(ctx context.Context, cancelFunc context.CancelFunc) error {
 	startTime := time.Now()
+	a.startTime = startTime
 	defer func(t time.Time) {
 		a.logger.Debug("Startup of agent execution duration", zap.String("Start() execution duration", time.Since(t).String()))
 	}(startTime)
@@ -182,8 +235,36 @@ func (a *orbAgent) Start(ctx context.Context, cancelFunc context.CancelFunc) err
 	if err := a.startBackends(ctx); err != nil {
 		return err
 	}
+	go a.runWatchdog(context.WithValue(a.asyncContext, routineKey, "watchdog"))
+	go a.handleRPCs(context.WithValue(a.asyncContext, routineKey, "rpcFromCore"))
+
+	if err := a.policyManager.RestorePolicies(); err != nil {
+		a.logger.Error("failed to restore persisted policies", zap.Error(err))
+	}
+
+	mqttConfig, err := a.configManager.GetConfig()
+	if err != nil {
+		a.logger.Error("failed to retrieve control plane configuration", zap.Error(err))
+		return err
+	}
+
+	if mqttConfig.Connect {
+		commsCtx := context.WithValue(agentCtx, routineKey, "comms")
+		if err := a.startComms(commsCtx, mqttConfig); err != nil {
+			a.logger.Error("could not start mqtt client")
+			return err
+		}
+	}
+
+	// policies are optional when the control plane is managing the agent
+	if !mqttConfig.Connect || a.config.OrbAgent.Policies != nil {
+		if err := a.managePolicies(); err != nil {
+			return err
+		}
+	}
 
-	if err := a.managePolicies(); err != nil {
+	if err := a.startAPIServer(); err != nil {
+		a.logger.Error("failed to start local API server", zap.Error(err))
 		return err
 	}
 
@@ -193,63 +274,118 @@ func (a *orbAgent) Start(ctx context.Context, cancelFunc context.CancelFunc) err
 }
 
 func (a *orbAgent) logonWithHeartbeat() {
-	a.hbTicker = time.NewTicker(HeartbeatFreq)
+	a.heartbeatMutex.Lock()
+	defer a.heartbeatMutex.Unlock()
+	a.startHeartbeats()
+}
+
+// ensureHeartbeat starts the heartbeat routine unless it is already running
+func (a *orbAgent) ensureHeartbeat() {
+	a.heartbeatMutex.Lock()
+	defer a.heartbeatMutex.Unlock()
+	if a.heartbeatCtx == nil {
+		a.startHeartbeats()
+	}
+}
+
+// startHeartbeats starts a heartbeat routine, heartbeatMutex must be held
+func (a *orbAgent) startHeartbeats() {
+	a.hbTicker = time.NewTicker(a.config.OrbAgent.Timing.Heartbeat())
 	a.heartbeatCtx, a.heartbeatCancel = a.extendContext("heartbeat")
-	go a.sendHeartbeats(a.heartbeatCtx, a.heartbeatCancel)
+	a.heartbeatDone = make(chan struct{})
+	go a.sendHeartbeats(a.heartbeatCtx, a.heartbeatCancel, a.hbTicker, a.heartbeatDone)
 	a.logger.Info("heartbeat routine started")
 }
 
 func (a *orbAgent) logoffWithHeartbeat(ctx context.Context) {
 	a.logger.Debug("stopping heartbeat, going offline status", zap.Any("routine", ctx.Value(routineKey)))
+	a.heartbeatMutex.Lock()
 	if a.heartbeatCtx != nil {
 		a.heartbeatCancel()
 	}
+	a.heartbeatMutex.Unlock()
 	if a.client != nil && a.client.IsConnected() {
+		a.unsubscribeGroupChannels()
 		if token := a.client.Unsubscribe(a.rpcFromCoreTopic); token.Wait() && token.Error() != nil {
 			a.logger.Warn("failed to unsubscribe to RPC channel", zap.Error(token.Error()))
 		}
 	}
 }
 
+// Stop drains the agent within the shutdown timeout: it stops taking requests, removes the policies from their
+// backends, stops the backends and waits for their processes to exit, killing those still running at the deadline,
+// then sends the offline heartbeat and disconnects from the control plane
 func (a *orbAgent) Stop(ctx context.Context) {
-	a.logger.Info("routine call for stop agent", zap.Any("routine", ctx.Value(routineKey)))
+	if !a.stopping.CompareAndSwap(false, true) {
+		a.logger.Debug("agent already stopping", zap.Any("routine", ctx.Value(routineKey)))
+		return
+	}
+	timeout := a.config.OrbAgent.Timing.Shutdown()
+	a.logger.Info("routine call for stop agent", zap.Any("routine", ctx.Value(routineKey)), zap.Duration("shutdown_timeout", timeout))
+	start := time.Now()
+	drainCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	a.stopAPIServer()
+	a.stopRequestRetries()
+	a.logoffWithHeartbeat(ctx)
+	// no more control plane requests, heartbeats nor backend restarts while draining
 	if a.rpcFromCancelFunc != nil {
 		a.rpcFromCancelFunc()
 	}
-	for name, b := range a.backends {
-		if state, _, _ := b.GetRunningStatus(); state == backend.Running {
-			a.logger.Debug("stopping backend", zap.String("backend", name))
-			if err := b.Stop(ctx); err != nil {
-				a.logger.Error("error while stopping the backend", zap.String("backend", name))
-			}
+	a.heartbeatMutex.Lock()
+	heartbeatDone := a.heartbeatDone
+	a.heartbeatMutex.Unlock()
+	if heartbeatDone != nil {
+		select {
+		case <-heartbeatDone:
+		case <-drainCtx.Done():
 		}
 	}
-	a.logoffWithHeartbeat(ctx)
+
+	summary := a.drainBackends(drainCtx)
+
 	if a.client != nil && a.client.IsConnected() {
-		a.client.Disconnect(0)
+		a.sendSingleHeartbeat(ctx, time.Now(), fleet.Offline)
+		a.client.Disconnect(disconnectQuiesce)
 	}
-	a.logger.Debug("stopping agent with number of go routines and go calls", zap.Int("goroutines", runtime.NumGoroutine()), zap.Int64("gocalls", runtime.NumCgoCall()))
-	if a.policyRequestSucceeded != nil {
-		a.policyRequestSucceeded()
+	// the backends are stopped, nothing writes to the policies anymore
+	if a.policyManager != nil {
+		if err := a.policyManager.GetRepo().Close(); err != nil {
+			a.logger.Warn("failed to close policy repository", zap.Error(err))
+		}
 	}
-	if a.groupRequestSucceeded != nil {
-		a.groupRequestSucceeded()
+	a.logger.Info("agent stopped",
+		zap.Duration("duration", time.Since(start)),
+		zap.Strings("stopped", summary.stopped),
+		zap.Strings("killed", summary.killed),
+		zap.Strings("failed", summary.failed),
+		zap.Strings("still_running", summary.stillRunning),
+		zap.Int("goroutines", runtime.NumGoroutine()))
+	_ = a.logger.Sync()
+	if a.cancelFunction != nil {
+		a.cancelFunction()
 	}
-	defer a.cancelFunction()
 }
 
 func (a *orbAgent) RestartBackend(ctx context.Context, name string, reason string) error {
-	if !backend.HaveBackend(name) {
-		return errors.New("specified backend does not exist: " + name)
+	be, ok := a.backends[name]
+	if !ok {
+		return errors.New("specified backend is not configured: " + name)
 	}
-
-	be := a.backends[name]
 	a.logger.Info("restarting backend", zap.String("backend", name), zap.String("reason", reason))
-	a.backendState[name].RestartCount++
-	a.backendState[name].LastRestartTS = time.Now()
-	a.backendState[name].LastRestartReason = reason
+	if a.watchdog != nil {
+		a.watchdog.reset(name)
+	}
+	a.updateBackendState(name, func(state *backend.State) {
+		state.RestartCount++
+		state.LastRestartTS = time.Now()
+		state.LastRestartReason = reason
+	})
+	// the policies are kept in the repository, with their datasets and groups, to be applied again once the
+	// backend is back
 	a.logger.Info("removing policies", zap.String("backend", name))
-	if err := a.policyManager.RemoveBackendPolicies(be, true); err != nil {
+	if err := a.policyManager.RemoveBackendPolicies(name, false); err != nil {
 		a.logger.Error("failed to remove policies", zap.String("backend", name), zap.Error(err))
 	}
 	if err := be.Configure(a.logger, a.policyManager.GetRepo(), a.config.OrbAgent.Backends[name], a.backendsCommon); err != nil {
@@ -257,11 +393,28 @@ func (a *orbAgent) RestartBackend(ctx context.Context, name string, reason strin
 	}
 	a.logger.Info("resetting backend", zap.String("backend", name))
 
-	if err := be.FullReset(ctx); err != nil {
-		a.backendState[name].LastError = fmt.Sprintf("failed to reset backend: %v", err)
+	// FullReset returns once the backend is ready. The backend processes outlive the API request or RPC asking
+	// for the restart, they are stopped with the agent
+	if err := be.FullReset(context.WithoutCancel(ctx)); err != nil {
+		a.updateBackendState(name, func(state *backend.State) {
+			state.LastError = fmt.Sprintf("failed to reset backend: %v", err)
+		})
 		a.logger.Error("failed to reset backend", zap.String("backend", name), zap.Error(err))
+	} else {
+		a.logger.Info("applying policies", zap.String("backend", name))
+		if err := a.policyManager.ApplyBackendPolicies(name); err != nil {
+			a.logger.Error("failed to apply policies", zap.String("backend", name), zap.Error(err))
+		}
+	}
+	if a.client != nil {
+		be.SetCommsClient(a.agentID, &a.client, fmt.Sprintf("%s/?/%s", a.baseTopic, name))
+		// backend version and capabilities may have changed across the restart
+		if a.client.IsConnected() && a.capabilitiesTopic != "" {
+			if err := a.sendCapabilities(); err != nil {
+				a.logger.Error("failed to send agent capabilities", zap.Error(err))
+			}
+		}
 	}
-	be.SetCommsClient(a.agentID, &a.client, fmt.Sprintf("%s/?/%s", a.baseTopic, name))
 
 	return nil
 }
@@ -277,6 +430,10 @@ func (a *orbAgent) RestartAll(ctx context.Context, reason string) error {
 			a.logger.Error("failed to restart backend", zap.Error(err))
 		}
 	}
+	if a.client != nil && a.client.IsConnected() {
+		a.requestReconnection(ctx, a.client, a.mqttConfig)
+	}
+	a.logonWithHeartbeat()
 	a.logger.Info("all backends and comms were restarted")
 
 	return nil
@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/config"
+	"github.com/netboxlabs/orb-agent/agent/policies"
+	manager "github.com/netboxlabs/orb-agent/agent/policyMgr"
+)
+
+// recordingBackend records the policy and lifecycle calls it receives
+type recordingBackend struct {
+	fakeBackend
+	calls []string
+}
+
+func (r *recordingBackend) FullReset(_ context.Context) error {
+	r.calls = append(r.calls, "reset")
+	return nil
+}
+
+func (r *recordingBackend) ApplyPolicy(data policies.PolicyData, _ bool) error {
+	r.calls = append(r.calls, "apply "+data.ID)
+	return nil
+}
+
+func (r *recordingBackend) RemovePolicy(data policies.PolicyData) error {
+	r.calls = append(r.calls, "remove "+data.ID)
+	return nil
+}
+
+func Test_orbAgent_RestartBackend_reappliesPolicies(t *testing.T) {
+	be := &recordingBackend{}
+	backend.Register("restart_test", be)
+	pm, err := manager.New(zap.NewNop(), config.Config{})
+	require.NoError(t, err)
+	require.NoError(t, pm.GetRepo().Update(policies.PolicyData{
+		ID:       "p1",
+		Name:     "policy_1",
+		Backend:  "restart_test",
+		Version:  3,
+		State:    policies.Running,
+		Datasets: map[string]bool{"dataset-1": true},
+		GroupIDs: map[string]bool{"group-1": true},
+	}))
+	a := &orbAgent{
+		logger:        zap.NewNop(),
+		policyManager: pm,
+		backends:      map[string]backend.Backend{"restart_test": be},
+		backendState:  map[string]*backend.State{"restart_test": {Status: backend.Running}},
+	}
+
+	require.NoError(t, a.RestartBackend(context.Background(), "restart_test", "test"))
+
+	assert.Equal(t, []string{"remove p1", "reset", "apply p1"}, be.calls)
+	p1, err := pm.GetRepo().Get("p1")
+	require.NoError(t, err)
+	assert.Equal(t, policies.Running, p1.State)
+	assert.Equal(t, int32(3), p1.Version)
+	assert.Equal(t, []string{"dataset-1"}, p1.GetDatasetIDs())
+	assert.Equal(t, map[string]bool{"group-1": true}, p1.GroupIDs)
+}
+
+func Test_orbAgent_RestartBackend_multiBackend(t *testing.T) {
+	pktvisor, otel := &recordingBackend{}, &recordingBackend{}
+	backend.Register("restart_test_pktvisor", pktvisor)
+	backend.Register("restart_test_otel", otel)
+	pm, err := manager.New(zap.NewNop(), config.Config{})
+	require.NoError(t, err)
+	for id, be := range map[string]string{"pv-1": "restart_test_pktvisor", "otel-1": "restart_test_otel"} {
+		require.NoError(t, pm.GetRepo().Update(policies.PolicyData{ID: id, Name: id, Backend: be, Version: 1, State: policies.Running}))
+	}
+	a := &orbAgent{
+		logger:        zap.NewNop(),
+		policyManager: pm,
+		backends:      map[string]backend.Backend{"restart_test_pktvisor": pktvisor, "restart_test_otel": otel},
+		backendState: map[string]*backend.State{
+			"restart_test_pktvisor": {Status: backend.Running},
+			"restart_test_otel":     {Status: backend.Running},
+		},
+	}
+
+	require.NoError(t, a.RestartBackend(context.Background(), "restart_test_pktvisor", "test"))
+
+	assert.Equal(t, []string{"remove pv-1", "reset", "apply pv-1"}, pktvisor.calls)
+	assert.Empty(t, otel.calls, "the policies of the other backends are not touched")
+	all, err := pm.GetRepo().GetAll()
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+	for _, p := range all {
+		assert.Equal(t, policies.Running, p.State, p.ID)
+	}
+}
+
+func Test_orbAgent_RestartBackend_unconfigured(t *testing.T) {
+	be := &recordingBackend{}
+	backend.Register("restart_test_unconfigured", be)
+	a := &orbAgent{logger: zap.NewNop(), backends: map[string]backend.Backend{}}
+
+	assert.EqualError(t, a.RestartBackend(context.Background(), "restart_test_unconfigured", "test"),
+		"specified backend is not configured: restart_test_unconfigured")
+	assert.Empty(t, be.calls)
+}
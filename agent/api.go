@@ -0,0 +1,280 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/orb-community/orb/fleet"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/policies"
+	"github.com/netboxlabs/orb-agent/agent/redact"
+	"github.com/netboxlabs/orb-agent/agent/version"
+)
+
+const (
+	apiReadHeaderTimeout = 5 * time.Second
+	apiShutdownTimeout   = 5 * time.Second
+	apiRestartReason     = "requested through local API"
+)
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type backendStateResponse struct {
+	Status            string    `json:"status"`
+	RestartCount      int64     `json:"restart_count"`
+	LastError         string    `json:"last_error,omitempty"`
+	LastRestartTS     time.Time `json:"last_restart_ts,omitempty"`
+	LastRestartReason string    `json:"last_restart_reason,omitempty"`
+}
+
+type policyResponse struct {
+	ID              string      `json:"id"`
+	Name            string      `json:"name"`
+	Backend         string      `json:"backend"`
+	Version         int32       `json:"version"`
+	State           string      `json:"state"`
+	BackendErr      string      `json:"backend_error,omitempty"`
+	Datasets        []string    `json:"datasets"`
+	GroupIDs        []string    `json:"group_ids"`
+	LastScrapeBytes int64       `json:"last_scrape_bytes,omitempty"`
+	LastScrapeTS    time.Time   `json:"last_scrape_ts,omitempty"`
+	Data            interface{} `json:"data,omitempty"`
+}
+
+type statusResponse struct {
+	Version       string                          `json:"version"`
+	Commit        string                          `json:"commit"`
+	AgentID       string                          `json:"agent_id,omitempty"`
+	ConfigManager string                          `json:"config_manager"`
+	Connected     bool                            `json:"connected"`
+	StartTime     time.Time                       `json:"start_time"`
+	UpTime        string                          `json:"up_time"`
+	Backends      map[string]backendStateResponse `json:"backends"`
+	PolicyCount   int                             `json:"policy_count"`
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func toPolicyResponse(pd policies.PolicyData) policyResponse {
+	return policyResponse{
+		ID:              pd.ID,
+		Name:            pd.Name,
+		Backend:         pd.Backend,
+		Version:         pd.Version,
+		State:           pd.State.String(),
+		BackendErr:      pd.BackendErr,
+		Datasets:        sortedKeys(pd.Datasets),
+		GroupIDs:        sortedKeys(pd.GroupIDs),
+		LastScrapeBytes: pd.LastScrapeBytes,
+		LastScrapeTS:    pd.LastScrapeTS,
+		Data:            redact.Data(pd.Data),
+	}
+}
+
+func (a *orbAgent) writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		a.logger.Error("failed to encode local API response", zap.Error(err))
+	}
+}
+
+func (a *orbAgent) writeError(w http.ResponseWriter, status int, err error) {
+	a.writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+func (a *orbAgent) newAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", a.handleHealthz)
+	mux.HandleFunc("GET /readyz", a.handleReadyz)
+	mux.Handle("GET /metrics", a.newMetricsHandler())
+	mux.HandleFunc("GET /api/v1/status", a.handleAPIStatus)
+	mux.HandleFunc("GET /api/v1/backends", a.handleAPIBackends)
+	mux.HandleFunc("POST /api/v1/backends/{name}/restart", a.handleAPIRestartBackend)
+	mux.HandleFunc("GET /api/v1/policies", a.handleAPIPolicies)
+	mux.HandleFunc("POST /api/v1/policies", a.handleAPIApplyPolicy)
+	mux.HandleFunc("DELETE /api/v1/policies/{id}", a.handleAPIRemovePolicy)
+	mux.HandleFunc("GET /api/v1/capabilities", a.handleAPICapabilities)
+	return mux
+}
+
+func (a *orbAgent) startAPIServer() error {
+	address := a.config.OrbAgent.HTTP.Address
+	if address == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	a.apiServer = &http.Server{
+		Handler:           a.newAPIHandler(),
+		ReadHeaderTimeout: apiReadHeaderTimeout,
+	}
+	go func() {
+		if err := a.apiServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.logger.Error("local API server failed", zap.Error(err))
+		}
+	}()
+	a.logger.Info("local API server started", zap.String("address", listener.Addr().String()))
+	// the API has no authentication
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok && !tcpAddr.IP.IsLoopback() {
+		a.logger.Warn("local API server is reachable from other hosts, it has no authentication: bind it to a loopback address", zap.String("address", listener.Addr().String()))
+	}
+	return nil
+}
+
+func (a *orbAgent) stopAPIServer() {
+	if a.apiServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+	defer cancel()
+	if err := a.apiServer.Shutdown(ctx); err != nil {
+		a.logger.Error("failed to stop local API server", zap.Error(err))
+	}
+	a.apiServer = nil
+}
+
+func (a *orbAgent) backendStates() map[string]backendStateResponse {
+	states := make(map[string]backendStateResponse, len(a.backends))
+	for name := range a.backends {
+		state, ok := a.getBackendState(name)
+		if !ok {
+			continue
+		}
+		states[name] = backendStateResponse{
+			Status:            state.Status.String(),
+			RestartCount:      state.RestartCount,
+			LastError:         state.LastError,
+			LastRestartTS:     state.LastRestartTS,
+			LastRestartReason: state.LastRestartReason,
+		}
+	}
+	return states
+}
+
+func (a *orbAgent) handleAPIStatus(w http.ResponseWriter, _ *http.Request) {
+	pdata, err := a.policyManager.GetPolicyState()
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, statusResponse{
+		Version:       version.GetBuildVersion(),
+		Commit:        version.GetBuildCommit(),
+		AgentID:       a.agentID,
+		ConfigManager: a.config.OrbAgent.ConfigManager.Active,
+		Connected:     a.client != nil && a.client.IsConnected(),
+		StartTime:     a.startTime,
+		UpTime:        time.Since(a.startTime).Truncate(time.Second).String(),
+		Backends:      a.backendStates(),
+		PolicyCount:   len(pdata),
+	})
+}
+
+func (a *orbAgent) handleAPIBackends(w http.ResponseWriter, _ *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.backendStates())
+}
+
+func (a *orbAgent) handleAPIRestartBackend(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, ok := a.backends[name]; !ok {
+		a.writeError(w, http.StatusNotFound, errors.New("backend not running: "+name))
+		return
+	}
+	a.rpcMutex.Lock()
+	defer a.rpcMutex.Unlock()
+	ctx := a.configManager.GetContext(r.Context())
+	if err := a.RestartBackend(ctx, name, apiRestartReason); err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	state, _ := a.getBackendState(name)
+	a.writeJSON(w, http.StatusOK, backendStateResponse{
+		Status:            state.Status.String(),
+		RestartCount:      state.RestartCount,
+		LastError:         state.LastError,
+		LastRestartTS:     state.LastRestartTS,
+		LastRestartReason: state.LastRestartReason,
+	})
+}
+
+func (a *orbAgent) handleAPIPolicies(w http.ResponseWriter, _ *http.Request) {
+	pdata, err := a.policyManager.GetPolicyState()
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	sort.Slice(pdata, func(i, j int) bool { return pdata[i].ID < pdata[j].ID })
+	resp := make([]policyResponse, 0, len(pdata))
+	for _, pd := range pdata {
+		resp = append(resp, toPolicyResponse(pd))
+	}
+	a.writeJSON(w, http.StatusOK, resp)
+}
+
+func (a *orbAgent) handleAPIApplyPolicy(w http.ResponseWriter, r *http.Request) {
+	var payload fleet.AgentPolicyRPCPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if payload.ID == "" || payload.Name == "" || payload.Backend == "" {
+		a.writeError(w, http.StatusBadRequest, errors.New("policy id, name and backend are required"))
+		return
+	}
+	if _, ok := a.backends[payload.Backend]; !ok {
+		a.writeError(w, http.StatusBadRequest, errors.New("backend not running: "+payload.Backend))
+		return
+	}
+	payload.Action = "manage"
+	if payload.DatasetID == "" {
+		payload.DatasetID = uuid.NewString()
+	}
+
+	a.rpcMutex.Lock()
+	defer a.rpcMutex.Unlock()
+	a.policyManager.ManagePolicy(payload)
+	pd, err := a.policyManager.GetRepo().Get(payload.ID)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, toPolicyResponse(pd))
+}
+
+func (a *orbAgent) handleAPIRemovePolicy(w http.ResponseWriter, r *http.Request) {
+	a.rpcMutex.Lock()
+	defer a.rpcMutex.Unlock()
+	pd, err := a.policyManager.GetRepo().Get(r.PathValue("id"))
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err := a.policyManager.RemovePolicy(pd.ID, pd.Name, pd.Backend); err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *orbAgent) handleAPICapabilities(w http.ResponseWriter, _ *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.getCapabilities())
+}
@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/config"
+	"github.com/netboxlabs/orb-agent/agent/policies"
+)
+
+func newAPITestAgent(t *testing.T, plcies ...policies.PolicyData) (*orbAgent, *fakePolicyManager) {
+	pm := newFakePolicyManager(t, plcies...)
+	a := &orbAgent{
+		logger:        zap.NewNop(),
+		policyManager: pm,
+		backends: map[string]backend.Backend{
+			"pktvisor": &fakeBackend{version: "4.4.0"},
+		},
+		backendState: map[string]*backend.State{
+			"pktvisor": {Status: backend.Running, RestartCount: 2, LastRestartReason: "failed during heartbeat"},
+		},
+	}
+	return a, pm
+}
+
+func doAPIRequest(t *testing.T, a *orbAgent, method string, path string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	a.newAPIHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+func Test_orbAgent_apiStatus(t *testing.T) {
+	a, _ := newAPITestAgent(t, policies.PolicyData{ID: "p1", Name: "p1", Backend: "pktvisor"})
+
+	rec := doAPIRequest(t, a, http.MethodGet, "/api/v1/status", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var got statusResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.False(t, got.Connected)
+	assert.Equal(t, 1, got.PolicyCount)
+	require.Contains(t, got.Backends, "pktvisor")
+	assert.Equal(t, "running", got.Backends["pktvisor"].Status)
+	assert.Equal(t, int64(2), got.Backends["pktvisor"].RestartCount)
+}
+
+func Test_orbAgent_apiPolicies(t *testing.T) {
+	a, pm := newAPITestAgent(t, policies.PolicyData{
+		ID:       "p1",
+		Name:     "p1",
+		Backend:  "pktvisor",
+		State:    policies.Running,
+		Datasets: map[string]bool{"d2": true, "d1": true},
+		Data:     map[string]interface{}{"scope": []interface{}{map[string]interface{}{"hostname": "h1", "password": "device-pass"}}},
+	})
+
+	rec := doAPIRequest(t, a, http.MethodGet, "/api/v1/policies", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list []policyResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, "running", list[0].State)
+	assert.Equal(t, []string{"d1", "d2"}, list[0].Datasets)
+	assert.NotContains(t, rec.Body.String(), "device-pass")
+	assert.Contains(t, rec.Body.String(), `"hostname":"h1"`)
+
+	body := []byte(`{"id":"p2","name":"p2","backend":"pktvisor","version":1,"data":{"kind":"collection"}}`)
+	rec = doAPIRequest(t, a, http.MethodPost, "/api/v1/policies", body)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, pm.managed, 1)
+	assert.Equal(t, "manage", pm.managed[0].Action)
+	assert.NotEmpty(t, pm.managed[0].DatasetID)
+
+	rec = doAPIRequest(t, a, http.MethodPost, "/api/v1/policies", []byte(`{"id":"p3","name":"p3","backend":"unknown"}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doAPIRequest(t, a, http.MethodPost, "/api/v1/policies", []byte(`{"name":"p3"}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doAPIRequest(t, a, http.MethodDelete, "/api/v1/policies/p1", nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, []string{"p1"}, pm.removed)
+
+	rec = doAPIRequest(t, a, http.MethodDelete, "/api/v1/policies/p1", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func Test_orbAgent_apiRestartUnknownBackend(t *testing.T) {
+	a, _ := newAPITestAgent(t)
+
+	rec := doAPIRequest(t, a, http.MethodPost, "/api/v1/backends/unknown/restart", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = doAPIRequest(t, a, http.MethodGet, "/api/v1/backends/unknown/restart", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// contextBackend runs until the context it was reset with is done
+type contextBackend struct {
+	fakeBackend
+	stopped atomic.Bool
+}
+
+func (c *contextBackend) FullReset(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		c.stopped.Store(true)
+	}()
+	return nil
+}
+
+func Test_orbAgent_apiRestartBackend(t *testing.T) {
+	be := &contextBackend{}
+	backend.Register("api_restart_test", be)
+	a, _ := newAPITestAgent(t)
+	a.configManager = config.New(zap.NewNop(), config.ManagerConfig{Active: "local"})
+	a.backends["api_restart_test"] = be
+	a.backendState["api_restart_test"] = &backend.State{Status: backend.Running}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/backends/api_restart_test/restart", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	a.newAPIHandler().ServeHTTP(rec, req)
+	// the server cancels the request context once the handler returns
+	cancel()
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var got backendStateResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, int64(1), got.RestartCount)
+	assert.Equal(t, apiRestartReason, got.LastRestartReason)
+	assert.Never(t, be.stopped.Load, 200*time.Millisecond, 10*time.Millisecond, "the backend outlives the request")
+}
+
+func Test_orbAgent_healthz(t *testing.T) {
+	a, _ := newAPITestAgent(t)
+
+	rec := doAPIRequest(t, a, http.MethodGet, "/healthz", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.asyncContext = ctx
+	a.markAlive(time.Now())
+	rec = doAPIRequest(t, a, http.MethodGet, "/healthz", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	a.markAlive(time.Now().Add(-livenessTolerance * a.config.OrbAgent.Timing.Heartbeat()).Add(-time.Second))
+	rec = doAPIRequest(t, a, http.MethodGet, "/healthz", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	a.markAlive(time.Now())
+	cancel()
+	rec = doAPIRequest(t, a, http.MethodGet, "/healthz", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func Test_orbAgent_readyz(t *testing.T) {
+	a, pm := newAPITestAgent(t, policies.PolicyData{ID: "p1", Name: "p1", Backend: "pktvisor", State: policies.Running})
+
+	rec := doAPIRequest(t, a, http.MethodGet, "/readyz", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got readinessResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.True(t, got.Ready)
+	assert.Equal(t, "running", got.Backends["pktvisor"].Status)
+
+	require.NoError(t, pm.repo.Update(policies.PolicyData{ID: "p2", Name: "p2", Backend: "pktvisor", State: policies.FailedToApply, BackendErr: "invalid tap"}))
+	rec = doAPIRequest(t, a, http.MethodGet, "/readyz", nil)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	got = readinessResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.False(t, got.Ready)
+	require.Contains(t, got.Policies, "p2")
+	assert.NotContains(t, got.Policies, "p1")
+	assert.Equal(t, "invalid tap", got.Policies["p2"].Error)
+}
+
+func Test_orbAgent_metrics(t *testing.T) {
+	a, _ := newAPITestAgent(t,
+		policies.PolicyData{ID: "p1", Name: "p1", Backend: "pktvisor", State: policies.Running},
+		policies.PolicyData{ID: "p2", Name: "p2", Backend: "pktvisor", State: policies.FailedToApply},
+	)
+
+	rec := doAPIRequest(t, a, http.MethodGet, "/metrics", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, `orb_agent_backend_status{backend="pktvisor",status="running"} 1`)
+	assert.Contains(t, body, `orb_agent_backend_status{backend="pktvisor",status="offline"} 0`)
+	assert.Contains(t, body, `orb_agent_backend_restarts_total{backend="pktvisor"} 2`)
+	assert.Contains(t, body, `orb_agent_policies{state="running"} 1`)
+	assert.Contains(t, body, `orb_agent_policies{state="failed_to_apply"} 1`)
+	assert.Contains(t, body, `orb_agent_control_plane_request_attempts_total{request="agent_policies"} 0`)
+}
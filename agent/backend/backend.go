@@ -46,6 +46,20 @@ func (s RunningStatus) String() string {
 	return runningStatusMap[s]
 }
 
+// ProcessExit describes a backend process exiting without being stopped by the agent
+type ProcessExit struct {
+	// Code is the exit code, -1 when the process was killed by a signal or could not be waited for
+	Code int
+	// Reason describes the exit, e.g. "exit code 1"
+	Reason string
+	Time   time.Time
+}
+
+// Failed tells whether the process exited with an error
+func (e ProcessExit) Failed() bool {
+	return e.Code != 0
+}
+
 // Backend is the interface that all backends must implement
 type Backend interface {
 	Configure(*zap.Logger, policies.PolicyRepo, map[string]interface{}, config.BackendCommons) error
@@ -64,6 +78,35 @@ type Backend interface {
 	RemovePolicy(data policies.PolicyData) error
 }
 
+// PolicyValidator is implemented by backends able to check a policy offline, without a running backend.
+// config is the backend configuration from orb.backends, it may be nil
+type PolicyValidator interface {
+	ValidatePolicyData(config map[string]interface{}, data interface{}) error
+}
+
+// SchemaProvider is implemented by backends describing their options and policies as JSON Schema
+type SchemaProvider interface {
+	ConfigSchema() map[string]interface{}
+	PolicySchema() map[string]interface{}
+}
+
+// ExitNotifier is implemented by backends reporting the unexpected exit of their process as soon as it happens
+type ExitNotifier interface {
+	// SetExitHandler sets the function called when the backend process exits without being stopped
+	SetExitHandler(func(ProcessExit))
+}
+
+// StopReporter is implemented by backends telling whether their processes had to be killed on stop
+type StopReporter interface {
+	// Killed reports whether the last Stop killed a process still running after its stop timeout
+	Killed() bool
+}
+
+// ReadinessTuner is implemented by backends whose readiness probing is configurable
+type ReadinessTuner interface {
+	SetReadiness(config.ReadinessConfig)
+}
+
 var registry = make(map[string]Backend)
 
 // Register registers backend
@@ -0,0 +1,54 @@
+// Package backendtest provides helpers to test the processes spawned by backends
+package backendtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/backend/supervisor"
+)
+
+// Recorder is a fake backend binary recording the command line and the environment variable it is started with
+type Recorder struct {
+	// Binary is the path of the fake binary, to be configured as the backend executable
+	Binary string
+	out    string
+}
+
+// NewRecorder writes a fake binary recording its command line and the value of env
+func NewRecorder(t *testing.T, env string) *Recorder {
+	dir := t.TempDir()
+	r := &Recorder{Binary: filepath.Join(dir, "backend"), out: filepath.Join(dir, "out")}
+	script := fmt.Sprintf("#!/bin/sh\necho \"$0 $*\" > %q\necho \"$%s\" >> %q\nexec sleep 30\n", r.out, env, r.out)
+	require.NoError(t, os.WriteFile(r.Binary, []byte(script), 0o700))
+	return r
+}
+
+// Run spawns the process described by opts, which must run the recorder binary, and returns its command line and
+// the value of the recorded environment variable once it stopped
+func (r *Recorder) Run(t *testing.T, opts supervisor.Options) (string, string) {
+	opts.Readiness = nil
+	opts.Restart = nil
+	sup := supervisor.New(zap.NewNop(), opts)
+	require.NoError(t, sup.Start(context.Background()))
+	require.Eventually(t, func() bool {
+		content, err := os.ReadFile(r.out)
+		return err == nil && strings.Count(string(content), "\n") == 2
+	}, 10*time.Second, 10*time.Millisecond)
+	_, err := sup.Stop(context.Background())
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(r.out)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	require.Len(t, lines, 2)
+	return lines[0], lines[1]
+}
@@ -3,34 +3,39 @@ package devicediscovery
 import (
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/go-cmd/cmd"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 
 	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/backend/supervisor"
 	"github.com/netboxlabs/orb-agent/agent/config"
 	"github.com/netboxlabs/orb-agent/agent/policies"
+	"github.com/netboxlabs/orb-agent/agent/redact"
 )
 
 var _ backend.Backend = (*deviceDiscoveryBackend)(nil)
+var _ backend.PolicyValidator = (*deviceDiscoveryBackend)(nil)
+var _ backend.StopReporter = (*deviceDiscoveryBackend)(nil)
+var _ backend.ExitNotifier = (*deviceDiscoveryBackend)(nil)
+var _ backend.ReadinessTuner = (*deviceDiscoveryBackend)(nil)
 
 const (
 	versionTimeout      = 2
 	capabilitiesTimeout = 5
-	readinessBackoff    = 10
-	readinessTimeout    = 10
 	applyPolicyTimeout  = 10
 	removePolicyTimeout = 20
 	defaultExec         = "device-discovery"
 	defaultAPIHost      = "localhost"
 	defaultAPIPort      = "8072"
+	// read by the Diode SDK when no API key is given on the command line
+	diodeAPIKeyEnv = "DIODE_API_KEY"
 )
 
 type deviceDiscoveryBackend struct {
@@ -47,8 +52,10 @@ type deviceDiscoveryBackend struct {
 	diodeAppNamePrefix string
 
 	startTime  time.Time
-	proc       *cmd.Cmd
-	statusChan <-chan cmd.Status
+	sup        *supervisor.Supervisor
+	killed     atomic.Bool
+	onExit     func(backend.ProcessExit)
+	readiness  config.ReadinessConfig
 	cancelFunc context.CancelFunc
 	ctx        context.Context
 
@@ -96,117 +103,96 @@ func (d *deviceDiscoveryBackend) SetCommsClient(agentID string, client *mqtt.Cli
 }
 
 func (d *deviceDiscoveryBackend) Version() (string, error) {
+	return d.version(versionTimeout)
+}
+
+func (d *deviceDiscoveryBackend) version(timeout int32) (string, error) {
 	var info info
-	err := d.request("status", &info, http.MethodGet, http.NoBody, "application/json", versionTimeout)
+	err := d.request("status", &info, http.MethodGet, http.NoBody, "application/json", timeout)
 	if err != nil {
 		return "", err
 	}
 	return info.Version, nil
 }
 
-func (d *deviceDiscoveryBackend) Start(ctx context.Context, cancelFunc context.CancelFunc) error {
-	d.startTime = time.Now()
-	d.cancelFunc = cancelFunc
-	d.ctx = ctx
-
-	pvOptions := []string{
-		"--host", d.apiHost,
-		"--port", d.apiPort,
-		"--diode-target", d.diodeTarget,
-		"--diode-api-key", d.diodeAPIKey,
-		"--diode-app-name-prefix", d.diodeAppNamePrefix,
-	}
-
-	d.logger.Info("device-discovery startup", zap.Strings("arguments", pvOptions))
-
-	d.proc = cmd.NewCmdOptions(cmd.Options{
-		Buffered:  false,
-		Streaming: true,
-	}, d.exec, pvOptions...)
-	d.statusChan = d.proc.Start()
-
-	// log STDOUT and STDERR lines streaming from Cmd
-	doneChan := make(chan struct{})
-	go func() {
-		defer func() {
-			if doneChan != nil {
-				close(doneChan)
-			}
-		}()
-		for d.proc.Stdout != nil || d.proc.Stderr != nil {
-			select {
-			case line, open := <-d.proc.Stdout:
-				if !open {
-					d.proc.Stdout = nil
-					continue
-				}
-				d.logger.Info("device-discovery stdout", zap.String("log", line))
-			case line, open := <-d.proc.Stderr:
-				if !open {
-					d.proc.Stderr = nil
-					continue
-				}
-				d.logger.Info("device-discovery stderr", zap.String("log", line))
+// processOptions describes the device-discovery process. The Diode API key is handed over through the environment
+// rather than the command line, where anyone able to list processes could read it.
+func (d *deviceDiscoveryBackend) processOptions() supervisor.Options {
+	readiness := d.readiness.WithDefaults()
+	opts := supervisor.Options{
+		Name:   "device-discovery",
+		Binary: d.exec,
+		Args: []string{
+			"--host", d.apiHost,
+			"--port", d.apiPort,
+			"--diode-target", d.diodeTarget,
+			"--diode-app-name-prefix", d.diodeAppNamePrefix,
+		},
+		// wait for simple startup errors
+		StartupGrace: time.Second,
+		Readiness: func() error {
+			version, err := d.version(readiness.TimeoutSeconds())
+			if err != nil {
+				return err
 			}
-		}
-	}()
-
-	// wait for simple startup errors
-	time.Sleep(time.Second)
-
-	status := d.proc.Status()
-
-	if status.Error != nil {
-		d.logger.Error("device-discovery startup error", zap.Error(status.Error))
-		return status.Error
+			d.logger.Info("device-discovery readiness ok, got version ", zap.String("device_discovery_version", version))
+			return nil
+		},
+		ReadinessAttempts: readiness.Attempts,
+		ReadinessBackoff:  readiness.Backoff,
+		OnExit:            d.processExited,
 	}
-
-	if status.Complete {
-		err := d.proc.Stop()
-		if err != nil {
-			d.logger.Error("proc.Stop error", zap.Error(err))
-		}
-		return errors.New("device-discovery startup error, check log")
+	if d.diodeAPIKey != "" {
+		opts.Env = []string{diodeAPIKeyEnv + "=" + d.diodeAPIKey}
 	}
+	return opts
+}
 
-	d.logger.Info("device-discovery process started", zap.Int("pid", status.PID))
+func (d *deviceDiscoveryBackend) SetReadiness(readiness config.ReadinessConfig) {
+	d.readiness = readiness
+}
 
-	var readinessErr error
-	for backoff := 0; backoff < readinessBackoff; backoff++ {
-		version, readinessErr := d.Version()
-		if readinessErr == nil {
-			d.logger.Info("device-discovery readiness ok, got version ", zap.String("device_discovery_version", version))
-			break
-		}
-		backoffDuration := time.Duration(backoff) * time.Second
-		d.logger.Info("device-discovery is not ready, trying again with backoff", zap.String("backoff backoffDuration", backoffDuration.String()))
-		time.Sleep(backoffDuration)
-	}
+func (d *deviceDiscoveryBackend) SetExitHandler(handler func(backend.ProcessExit)) {
+	d.onExit = handler
+}
 
-	if readinessErr != nil {
-		d.logger.Error("device-discovery error on readiness", zap.Error(readinessErr))
-		err := d.proc.Stop()
-		if err != nil {
-			d.logger.Error("proc.Stop error", zap.Error(err))
-		}
-		return readinessErr
+// processExited reports the exits the agent did not ask for to the exit handler
+func (d *deviceDiscoveryBackend) processExited(exit supervisor.Exit) {
+	if !exit.Expected && d.onExit != nil {
+		d.onExit(exit.ProcessExit())
 	}
+}
 
-	return nil
+func (d *deviceDiscoveryBackend) Start(ctx context.Context, cancelFunc context.CancelFunc) error {
+	d.startTime = time.Now()
+	d.cancelFunc = cancelFunc
+	d.ctx = ctx
+
+	d.sup = supervisor.New(d.logger, d.processOptions())
+	d.logger.Info("device-discovery startup", zap.Strings("arguments", redact.Args(d.sup.Args())))
+	return d.sup.Start(ctx)
 }
 
 func (d *deviceDiscoveryBackend) Stop(ctx context.Context) error {
 	d.logger.Info("routine call to stop device-discovery", zap.Any("routine", ctx.Value(config.ContextKey("routine"))))
 	defer d.cancelFunc()
-	err := d.proc.Stop()
-	finalStatus := <-d.statusChan
+	d.killed.Store(false)
+	if d.sup == nil {
+		return nil
+	}
+	killed, err := d.sup.Stop(ctx)
+	d.killed.Store(killed)
 	if err != nil {
 		d.logger.Error("device-discovery shutdown error", zap.Error(err))
 	}
-	d.logger.Info("device-discovery process stopped", zap.Int("pid", finalStatus.PID), zap.Int("exit_code", finalStatus.Exit))
 	return nil
 }
 
+// Killed reports whether the last Stop killed the device-discovery process
+func (d *deviceDiscoveryBackend) Killed() bool {
+	return d.killed.Load()
+}
+
 func (d *deviceDiscoveryBackend) FullReset(ctx context.Context) error {
 	// force a stop, which stops scrape as well. if proc is dead, it no ops.
 	if state, _, _ := d.getProcRunningStatus(); state == backend.Running {
@@ -265,7 +251,7 @@ func (d *deviceDiscoveryBackend) ApplyPolicy(data policies.PolicyData, updatePol
 		}
 	}
 
-	d.logger.Debug("device-discovery policy apply", zap.String("policy_id", data.ID), zap.Any("data", data.Data))
+	d.logger.Debug("device-discovery policy apply", zap.String("policy_id", data.ID), zap.Any("data", redact.Data(data.Data)))
 
 	fullPolicy := map[string]interface{}{
 		"policies": map[string]interface{}{
@@ -275,14 +261,14 @@ func (d *deviceDiscoveryBackend) ApplyPolicy(data policies.PolicyData, updatePol
 
 	policyYaml, err := yaml.Marshal(fullPolicy)
 	if err != nil {
-		d.logger.Warn("yaml policy marshal failure", zap.String("policy_id", data.ID), zap.Any("policy", fullPolicy))
+		d.logger.Warn("yaml policy marshal failure", zap.String("policy_id", data.ID), zap.Any("policy", redact.Data(fullPolicy)))
 		return err
 	}
 
 	var resp map[string]interface{}
 	err = d.request("policies", &resp, http.MethodPost, bytes.NewBuffer(policyYaml), "application/x-yaml", applyPolicyTimeout)
 	if err != nil {
-		d.logger.Warn("yaml policy application failure", zap.String("policy_id", data.ID), zap.ByteString("policy", policyYaml))
+		d.logger.Warn("yaml policy application failure", zap.String("policy_id", data.ID), zap.ByteString("policy", redact.Bytes(policyYaml)))
 		return err
 	}
 
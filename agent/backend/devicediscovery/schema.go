@@ -0,0 +1,48 @@
+package devicediscovery
+
+import "github.com/netboxlabs/orb-agent/agent/backend"
+
+var _ backend.SchemaProvider = (*deviceDiscoveryBackend)(nil)
+
+// ConfigSchema describes the device discovery backend options
+func (d *deviceDiscoveryBackend) ConfigSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"host": map[string]interface{}{"type": "string", "default": defaultAPIHost, "description": "Host of the device-discovery REST API."},
+			"port": map[string]interface{}{"type": "string", "default": defaultAPIPort, "description": "Port of the device-discovery REST API, as a string."},
+		},
+	}
+}
+
+// PolicySchema describes a device discovery policy
+func (d *deviceDiscoveryBackend) PolicySchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"scope"},
+		"properties": map[string]interface{}{
+			"config": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"schedule": map[string]interface{}{"type": "string", "description": "Cron expression, the policy runs once when omitted."},
+					"defaults": map[string]interface{}{"type": "object", "description": "Default values of the discovered entities, e.g. site."},
+				},
+			},
+			"scope": map[string]interface{}{
+				"type":     "array",
+				"minItems": 1,
+				"items": map[string]interface{}{
+					"type":     "object",
+					"required": []interface{}{"hostname", "username", "password"},
+					"properties": map[string]interface{}{
+						"driver":        map[string]interface{}{"type": "string", "description": "NAPALM driver, detected when omitted."},
+						"hostname":      map[string]interface{}{"type": "string"},
+						"username":      map[string]interface{}{"type": "string"},
+						"password":      map[string]interface{}{"type": "string"},
+						"optional_args": map[string]interface{}{"type": "object"},
+					},
+				},
+			},
+		},
+	}
+}
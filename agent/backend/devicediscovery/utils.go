@@ -10,32 +10,18 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/metrics"
 )
 
 func (d *deviceDiscoveryBackend) getProcRunningStatus() (backend.RunningStatus, string, error) {
-	if d.proc == nil {
+	if d.sup == nil {
 		return backend.Unknown, "backend not started yet", nil
 	}
-	status := d.proc.Status()
-
-	if status.Error != nil {
-		errMsg := fmt.Sprintf("device-discovery process error: %v", status.Error)
-		return backend.BackendError, errMsg, status.Error
-	}
-
-	if status.Complete {
-		err := d.proc.Stop()
-		return backend.Offline, "device-discovery process ended", err
-	}
-
-	if status.StopTs > 0 {
-		return backend.Offline, "device-discovery process ended", nil
-	}
-	return backend.Running, "", nil
+	return d.sup.Status()
 }
 
 // note this needs to be stateless because it is called for multiple go routines
-func (d *deviceDiscoveryBackend) request(url string, payload interface{}, method string, body io.Reader, contentType string, timeout int32) error {
+func (d *deviceDiscoveryBackend) request(url string, payload interface{}, method string, body io.Reader, contentType string, timeout int32) (err error) {
 	client := http.Client{
 		Timeout: time.Second * time.Duration(timeout),
 	}
@@ -46,6 +32,11 @@ func (d *deviceDiscoveryBackend) request(url string, payload interface{}, method
 		return err
 	}
 
+	start := time.Now()
+	defer func() {
+		metrics.ObserveBackendRequest("device_discovery", method, start, err)
+	}()
+
 	URL := fmt.Sprintf("%s://%s:%s/api/v1/%s", d.apiProtocol, d.apiHost, d.apiPort, url)
 
 	req, err := http.NewRequest(method, URL, body)
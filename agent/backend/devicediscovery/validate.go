@@ -0,0 +1,52 @@
+package devicediscovery
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+)
+
+// ValidatePolicyData checks the config and scope of a device discovery policy offline
+func (d *deviceDiscoveryBackend) ValidatePolicyData(_ map[string]interface{}, data interface{}) error {
+	policy, ok := data.(map[string]interface{})
+	if !ok {
+		return errors.New("policy must be a map")
+	}
+	if cfg, ok := policy["config"]; ok && cfg != nil {
+		cfgMap, ok := cfg.(map[string]interface{})
+		if !ok {
+			return errors.New("config must be a map")
+		}
+		if schedule, ok := cfgMap["schedule"]; ok {
+			s, ok := schedule.(string)
+			if !ok {
+				return errors.New("config.schedule must be a string")
+			}
+			if err := backend.ValidateSchedule(s); err != nil {
+				return fmt.Errorf("config.schedule: %w", err)
+			}
+		}
+		if defaults, ok := cfgMap["defaults"]; ok {
+			if _, ok := defaults.(map[string]interface{}); !ok {
+				return errors.New("config.defaults must be a map")
+			}
+		}
+	}
+	scope, ok := policy["scope"].([]interface{})
+	if !ok || len(scope) == 0 {
+		return errors.New("scope must be a non empty list of devices")
+	}
+	for i, entry := range scope {
+		device, ok := entry.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("scope[%d] must be a map", i)
+		}
+		for _, field := range []string{"hostname", "username", "password"} {
+			if value, ok := device[field]; !ok || value == nil || value == "" {
+				return fmt.Errorf("scope[%d].%s is required", i, field)
+			}
+		}
+	}
+	return nil
+}
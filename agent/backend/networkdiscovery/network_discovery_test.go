@@ -0,0 +1,34 @@
+package networkdiscovery
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netboxlabs/orb-agent/agent/backend/backendtest"
+)
+
+const testAPIKey = "diode-secret-api-key"
+
+func TestNewProcess_apiKeyNotInArgs(t *testing.T) {
+	recorder := backendtest.NewRecorder(t, diodeAPIKeyEnv)
+	d := &networkDiscoveryBackend{
+		exec:               recorder.Binary,
+		apiHost:            defaultAPIHost,
+		apiPort:            defaultAPIPort,
+		diodeTarget:        "grpc://localhost:8080/diode",
+		diodeAPIKey:        testAPIKey,
+		diodeAppNamePrefix: "agent01",
+	}
+	opts := d.processOptions()
+	assert.NotContains(t, strings.Join(opts.Args, " "), testAPIKey)
+	assert.NotContains(t, opts.Args, "--diode-api-key")
+	assert.Contains(t, opts.Env, diodeAPIKeyEnv+"="+testAPIKey)
+
+	// the spawned process gets the key from its environment only
+	args, apiKey := recorder.Run(t, opts)
+	assert.NotContains(t, args, testAPIKey)
+	assert.Contains(t, args, "--diode-target")
+	assert.Equal(t, testAPIKey, apiKey)
+}
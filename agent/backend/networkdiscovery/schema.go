@@ -0,0 +1,46 @@
+package networkdiscovery
+
+import "github.com/netboxlabs/orb-agent/agent/backend"
+
+var _ backend.SchemaProvider = (*networkDiscoveryBackend)(nil)
+
+// ConfigSchema describes the network discovery backend options
+func (d *networkDiscoveryBackend) ConfigSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"host": map[string]interface{}{"type": "string", "default": defaultAPIHost, "description": "Host of the network-discovery REST API."},
+			"port": map[string]interface{}{"type": "string", "default": defaultAPIPort, "description": "Port of the network-discovery REST API, as a string."},
+		},
+	}
+}
+
+// PolicySchema describes a network discovery policy
+func (d *networkDiscoveryBackend) PolicySchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"scope"},
+		"properties": map[string]interface{}{
+			"config": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"schedule": map[string]interface{}{"type": "string", "description": "Cron expression, the policy runs once when omitted."},
+					"timeout":  map[string]interface{}{"type": "integer", "minimum": 1, "description": "Scan timeout in minutes."},
+					"defaults": map[string]interface{}{"type": "object", "description": "Default values of the discovered IP addresses, e.g. description."},
+				},
+			},
+			"scope": map[string]interface{}{
+				"type":     "object",
+				"required": []interface{}{"targets"},
+				"properties": map[string]interface{}{
+					"targets": map[string]interface{}{
+						"type":        "array",
+						"minItems":    1,
+						"items":       map[string]interface{}{"type": "string", "minLength": 1},
+						"description": "Hosts, IP ranges or subnets to scan.",
+					},
+				},
+			},
+		},
+	}
+}
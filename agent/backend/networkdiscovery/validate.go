@@ -0,0 +1,56 @@
+package networkdiscovery
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+)
+
+// ValidatePolicyData checks the config and scope of a network discovery policy offline
+func (d *networkDiscoveryBackend) ValidatePolicyData(_ map[string]interface{}, data interface{}) error {
+	policy, ok := data.(map[string]interface{})
+	if !ok {
+		return errors.New("policy must be a map")
+	}
+	if cfg, ok := policy["config"]; ok && cfg != nil {
+		cfgMap, ok := cfg.(map[string]interface{})
+		if !ok {
+			return errors.New("config must be a map")
+		}
+		if schedule, ok := cfgMap["schedule"]; ok {
+			s, ok := schedule.(string)
+			if !ok {
+				return errors.New("config.schedule must be a string")
+			}
+			if err := backend.ValidateSchedule(s); err != nil {
+				return fmt.Errorf("config.schedule: %w", err)
+			}
+		}
+		if timeout, ok := cfgMap["timeout"]; ok {
+			t, ok := timeout.(int)
+			if !ok || t <= 0 {
+				return errors.New("config.timeout must be a positive number of minutes")
+			}
+		}
+		if defaults, ok := cfgMap["defaults"]; ok {
+			if _, ok := defaults.(map[string]interface{}); !ok {
+				return errors.New("config.defaults must be a map")
+			}
+		}
+	}
+	scope, ok := policy["scope"].(map[string]interface{})
+	if !ok {
+		return errors.New("scope section is required")
+	}
+	targets, ok := scope["targets"].([]interface{})
+	if !ok || len(targets) == 0 {
+		return errors.New("scope.targets must be a non empty list")
+	}
+	for i, target := range targets {
+		if s, ok := target.(string); !ok || s == "" {
+			return fmt.Errorf("scope.targets[%d] must be a non empty string", i)
+		}
+	}
+	return nil
+}
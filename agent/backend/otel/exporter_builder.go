@@ -18,6 +18,7 @@ type openTelemetryConfig struct {
 	Processors map[string]interface{} `yaml:"processors,omitempty"`
 	Extensions map[string]interface{} `yaml:"extensions,omitempty"`
 	Exporters  map[string]interface{} `yaml:"exporters"`
+	Connectors map[string]interface{} `yaml:"connectors,omitempty"`
 	Service    *service               `yaml:"service"`
 }
 
@@ -31,8 +32,9 @@ type tls struct {
 }
 
 type service struct {
-	Pipelines *pipelines `yaml:"pipelines"`
-	Telemetry *telemetry `yaml:"telemetry,omitempty"`
+	Extensions []string   `yaml:"extensions,omitempty"`
+	Pipelines  *pipelines `yaml:"pipelines"`
+	Telemetry  *telemetry `yaml:"telemetry,omitempty"`
 }
 
 type telemetry struct {
@@ -119,16 +121,28 @@ func (e *exporterBuilder) MergeDefaultValueWithPolicy(config openTelemetryConfig
 	config.Service.Telemetry = tel
 	// Override metrics exporter and append attributes/policy_data processor
 	if config.Service.Pipelines.Metrics != nil {
-		config.Service.Pipelines.Metrics.Exporters = []string{"otlp"}
+		config.Service.Pipelines.Metrics.Exporters = connectorExporters(config, config.Service.Pipelines.Metrics)
 		config.Service.Pipelines.Metrics.Processors = append(config.Service.Pipelines.Metrics.Processors, "transform/policy_data")
 	}
 	if config.Service.Pipelines.Traces != nil {
-		config.Service.Pipelines.Traces.Exporters = []string{"otlp"}
+		config.Service.Pipelines.Traces.Exporters = connectorExporters(config, config.Service.Pipelines.Traces)
 		config.Service.Pipelines.Traces.Processors = append(config.Service.Pipelines.Traces.Processors, "transform/policy_data")
 	}
 	if config.Service.Pipelines.Logs != nil {
-		config.Service.Pipelines.Logs.Exporters = []string{"otlp"}
+		config.Service.Pipelines.Logs.Exporters = connectorExporters(config, config.Service.Pipelines.Logs)
 		config.Service.Pipelines.Logs.Processors = append(config.Service.Pipelines.Logs.Processors, "transform/policy_data")
 	}
 	return config, nil
 }
+
+// connectorExporters returns the exporters of a pipeline once merged: the connectors it exports to, feeding
+// other pipelines, are kept and every other exporter is replaced by the agent's otlp one
+func connectorExporters(config openTelemetryConfig, p *pipeline) []string {
+	var exporters []string
+	for _, id := range p.Exporters {
+		if _, ok := config.Connectors[id]; ok {
+			exporters = append(exporters, id)
+		}
+	}
+	return append(exporters, "otlp")
+}
@@ -2,10 +2,15 @@ package otel
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -18,6 +23,8 @@ import (
 )
 
 var _ backend.Backend = (*openTelemetryBackend)(nil)
+var _ backend.PolicyValidator = (*openTelemetryBackend)(nil)
+var _ backend.StopReporter = (*openTelemetryBackend)(nil)
 
 const (
 	defaultPath = "otelcol-contrib"
@@ -36,8 +43,15 @@ type openTelemetryBackend struct {
 
 	// Context for controlling the context cancellation
 	mainContext        context.Context
-	runningCollectors  map[string]runningPolicy
 	mainCancelFunction context.CancelFunc
+	// collectors of the applied policies, by policy ID
+	collectorsMu      sync.Mutex
+	runningCollectors map[string]runningPolicy
+	// mode is per-policy, with a collector per policy, or shared, with every policy in the shared collector
+	mode   string
+	shared *sharedCollector
+	// set when the last Stop killed a collector
+	killed atomic.Bool
 
 	mqttClient *mqtt.Client
 
@@ -50,6 +64,12 @@ type openTelemetryBackend struct {
 	otelReceiverHost   string
 	otelReceiverPort   int
 	otelExecutablePath string
+
+	// component types built into the collector, by kind, nil when unknown
+	components map[string][]string
+	// receiver types policies may or may not use on this agent, the denied ones win
+	allowedReceivers []string
+	deniedReceivers  []string
 }
 
 // Configure initializes the backend with the given configuration
@@ -61,10 +81,13 @@ func (o *openTelemetryBackend) Configure(logger *zap.Logger, repo policies.Polic
 	o.policyRepo = repo
 	var err error
 	o.otelReceiverTaps = []string{"otelcol-contrib", "receivers", "processors", "extensions"}
-	o.policyConfigDirectory, err = os.MkdirTemp("", "otel-policies")
-	if err != nil {
-		o.logger.Error("failed to create temporary directory for policy configs", zap.Error(err))
-		return err
+	// a reload configures the backend again, the policy configs stay in the same directory
+	if o.policyConfigDirectory == "" {
+		o.policyConfigDirectory, err = os.MkdirTemp("", "otel-policies")
+		if err != nil {
+			o.logger.Error("failed to create temporary directory for policy configs", zap.Error(err))
+			return err
+		}
 	}
 	if path, ok := config["binary"].(string); ok {
 		o.otelExecutablePath = path
@@ -76,11 +99,25 @@ func (o *openTelemetryBackend) Configure(logger *zap.Logger, repo policies.Polic
 		o.logger.Error("otelcol-contrib: binary not found", zap.Error(err))
 		return err
 	}
-	if err != nil {
-		o.logger.Error("failed to create temporary directory for policy configs", zap.Error(err))
+	o.agentTags = common.Otel.AgentTags
+
+	if o.allowedReceivers, o.deniedReceivers, err = receiverLists(config); err != nil {
 		return err
 	}
-	o.agentTags = common.Otel.AgentTags
+
+	o.mode = perPolicyMode
+	if mode, ok := config["mode"].(string); ok && mode != "" {
+		o.mode = mode
+	}
+	if err := validateMode(o.mode); err != nil {
+		return err
+	}
+	if o.shared == nil {
+		o.shared = &sharedCollector{policies: make(map[string]sharedPolicy)}
+	}
+	o.shared.mu.Lock()
+	o.shared.configPath = filepath.Join(o.policyConfigDirectory, sharedConfigFileName)
+	o.shared.mu.Unlock()
 
 	if otelPort, ok := config["otlp_port"]; ok {
 		o.otelReceiverPort, err = strconv.Atoi(otelPort.(string))
@@ -134,7 +171,11 @@ func (o *openTelemetryBackend) Version() (string, error) {
 }
 
 func (o *openTelemetryBackend) Start(ctx context.Context, cancelFunc context.CancelFunc) (err error) {
-	o.runningCollectors = make(map[string]runningPolicy)
+	o.collectorsMu.Lock()
+	if o.runningCollectors == nil {
+		o.runningCollectors = make(map[string]runningPolicy)
+	}
+	o.collectorsMu.Unlock()
 	o.mainCancelFunction = cancelFunc
 	o.mainContext = ctx
 	o.startTime = time.Now()
@@ -149,43 +190,100 @@ func (o *openTelemetryBackend) Start(ctx context.Context, cancelFunc context.Can
 		return err
 	}
 	o.logger.Info("starting open-telemetry backend using version", zap.String("version", currentVersion))
-	policiesData, err := o.policyRepo.GetAll()
-	if err != nil {
-		cancelFunc()
-		o.logger.Error("failed to start otel backend, policies are absent")
-		return err
-	}
-	for _, policyData := range policiesData {
-		if err := o.ApplyPolicy(policyData, true); err != nil {
-			o.logger.Error("failed to start otel backend, failed to apply policy", zap.Error(err))
-			cancelFunc()
-			return err
-		}
-		o.logger.Info("policy applied successfully", zap.String("policy_id", policyData.ID))
-	}
+	o.loadComponents()
+	// the policies are applied by the agent once the backend started: restored on boot, re-applied on restart
 
 	return nil
 }
 
-func (o *openTelemetryBackend) Stop(_ context.Context) error {
+// Stop stops the collectors of every policy and waits for them to exit, those still running once ctx is done are
+// killed
+func (o *openTelemetryBackend) Stop(ctx context.Context) error {
 	o.logger.Info("stopping all running policies")
-	o.mainCancelFunction()
-	for policyID, policyEntry := range o.runningCollectors {
-		o.logger.Debug("stopping policy context", zap.String("policy_id", policyID))
-		policyEntry.ctx.Done()
+	o.collectorsMu.Lock()
+	collectors := o.runningCollectors
+	o.runningCollectors = make(map[string]runningPolicy)
+	o.collectorsMu.Unlock()
+	o.killed.Store(false)
+	var wg sync.WaitGroup
+	for policyID, policyEntry := range collectors {
+		o.logger.Debug("stopping policy collector", zap.String("policy_id", policyID))
+		wg.Add(1)
+		go func(policyEntry runningPolicy) {
+			defer wg.Done()
+			if o.stopCollector(ctx, policyEntry) {
+				o.killed.Store(true)
+			}
+		}(policyEntry)
+	}
+	wg.Wait()
+	if o.shared != nil {
+		o.shared.mu.Lock()
+		if o.stopSharedCollector(ctx) {
+			o.killed.Store(true)
+		}
+		o.shared.policies = make(map[string]sharedPolicy)
+		o.shared.mu.Unlock()
+	}
+	if o.mainCancelFunction != nil {
+		o.mainCancelFunction()
 	}
 	return nil
 }
 
+// Killed reports whether the last Stop killed a collector
+func (o *openTelemetryBackend) Killed() bool {
+	return o.killed.Load()
+}
+
 func (o *openTelemetryBackend) FullReset(ctx context.Context) error {
-	o.logger.Info("restarting otel backend", zap.Int("running policies", len(o.runningCollectors)))
-	backendCtx, cancelFunc := context.WithCancel(context.WithValue(ctx, config.ContextKey("routine"), "otel"))
+	o.logger.Info("restarting otel backend", zap.Int("running policies", o.collectorCount()))
+	// collectors of policies the agent failed to remove would otherwise be left behind
+	if err := o.Stop(ctx); err != nil {
+		return err
+	}
+	// the collectors outlive the API request or RPC asking for the reset, Stop stops them
+	backendCtx, cancelFunc := context.WithCancel(context.WithValue(context.WithoutCancel(ctx), config.ContextKey("routine"), "otel"))
 	if err := o.Start(backendCtx, cancelFunc); err != nil {
 		return err
 	}
 	return nil
 }
 
+// receiverLists decodes the allowed_receivers and denied_receivers options of the backend config
+func receiverLists(config map[string]interface{}) ([]string, []string, error) {
+	allowed, err := stringList(config["allowed_receivers"])
+	if err != nil {
+		return nil, nil, fmt.Errorf("allowed_receivers: %w", err)
+	}
+	denied, err := stringList(config["denied_receivers"])
+	if err != nil {
+		return nil, nil, fmt.Errorf("denied_receivers: %w", err)
+	}
+	return allowed, denied, nil
+}
+
+// stringList converts a list from the backend config
+func stringList(v interface{}) ([]string, error) {
+	switch list := v.(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		return list, nil
+	case []interface{}:
+		ret := make([]string, 0, len(list))
+		for i, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("item %d must be a string", i)
+			}
+			ret = append(ret, s)
+		}
+		return ret, nil
+	}
+	return nil, errors.New("must be a list of strings")
+}
+
 // Register registers otel backend
 func Register() bool {
 	backend.Register("otel", &openTelemetryBackend{})
@@ -203,11 +301,32 @@ func (o *openTelemetryBackend) GetCapabilities() (capabilities map[string]interf
 	return
 }
 
-// GetRunningStatus returns cross-reference the Processes using the os, with the policies and contexts
+// GetRunningStatus derives the backend status from the state of the policy collectors, and updates the state of
+// their policies
 func (o *openTelemetryBackend) GetRunningStatus() (backend.RunningStatus, string, error) {
-	amountCollectors := len(o.runningCollectors)
-	if amountCollectors > 0 {
-		return backend.Running, fmt.Sprintf("opentelemetry backend running with %d policies", amountCollectors), nil
+	var running, restarting int
+	var failed []string
+	for _, policyEntry := range o.collectors() {
+		status, errMsg := o.syncPolicyState(policyEntry)
+		switch status {
+		case backend.Running:
+			running++
+		case backend.Waiting:
+			restarting++
+		default:
+			failed = append(failed, fmt.Sprintf("policy %s: %s", policyEntry.policyID, errMsg))
+		}
+	}
+	switch {
+	case running > 0 && len(failed) > 0:
+		return backend.Running, fmt.Sprintf("opentelemetry backend running with %d policies, %d failed: %s",
+			running+restarting, len(failed), strings.Join(failed, "; ")), nil
+	case running > 0:
+		return backend.Running, fmt.Sprintf("opentelemetry backend running with %d policies", running+restarting), nil
+	case len(failed) > 0:
+		return backend.BackendError, "opentelemetry collectors failed: " + strings.Join(failed, "; "), nil
+	case restarting > 0:
+		return backend.Waiting, fmt.Sprintf("opentelemetry backend is restarting %d collectors", restarting), nil
 	}
 	return backend.Waiting, "opentelemetry backend is waiting for policy to come to start running", nil
 }
@@ -2,34 +2,33 @@ package otel
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"os"
 
-	"github.com/go-cmd/cmd"
 	"go.uber.org/zap"
-	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/backend/supervisor"
 	"github.com/netboxlabs/orb-agent/agent/config"
 	"github.com/netboxlabs/orb-agent/agent/policies"
+	"github.com/netboxlabs/orb-agent/agent/redact"
 )
 
 const tempFileNamePattern = "otel-%s-config.yml"
 
 type runningPolicy struct {
-	ctx        context.Context
 	cancel     context.CancelFunc
 	policyID   string
 	policyData policies.PolicyData
-	statusChan *cmd.Status
+	sup        *supervisor.Supervisor
 }
 
 func (o *openTelemetryBackend) ApplyPolicy(newPolicyData policies.PolicyData, updatePolicy bool) error {
 	o.logger.Debug("applying policy", zap.String("policy_id", newPolicyData.ID))
 	policyYaml, err := yaml.Marshal(newPolicyData.Data)
 	if err != nil {
-		o.logger.Warn("yaml policy marshal failure", zap.String("policy_id", newPolicyData.ID), zap.Any("policy", newPolicyData.Data))
+		o.logger.Warn("yaml policy marshal failure", zap.String("policy_id", newPolicyData.ID), zap.Any("policy", redact.Data(newPolicyData.Data)))
 		return err
 	}
 	builder := getExporterBuilder(o.logger, o.otelReceiverHost, o.otelReceiverPort)
@@ -44,6 +43,9 @@ func (o *openTelemetryBackend) ApplyPolicy(newPolicyData policies.PolicyData, up
 	if err != nil {
 		return err
 	}
+	if o.mode == sharedMode {
+		return o.applySharedPolicy(newPolicyData, otelConfig)
+	}
 	newPolicyYaml, err := yaml.Marshal(otelConfig)
 	if err != nil {
 		return err
@@ -54,7 +56,7 @@ func (o *openTelemetryBackend) ApplyPolicy(newPolicyData policies.PolicyData, up
 			zap.String("policy_id", newPolicyData.ID),
 			zap.Int32("version", newPolicyData.Version),
 			zap.String("policy_path", newPolicyPath))
-		if err := os.WriteFile(newPolicyPath, newPolicyYaml, os.ModeTemporary); err != nil {
+		if err := os.WriteFile(newPolicyPath, newPolicyYaml, 0o600); err != nil {
 			return err
 		}
 		if err = o.addRunner(newPolicyData, newPolicyPath); err != nil {
@@ -74,15 +76,13 @@ func (o *openTelemetryBackend) ApplyPolicy(newPolicyData policies.PolicyData, up
 
 			o.removePolicyControl(currentPolicyData.ID)
 
-			if err := os.WriteFile(currentPolicyPath, newPolicyYaml, os.ModeTemporary); err != nil {
+			if err := os.WriteFile(currentPolicyPath, newPolicyYaml, 0o600); err != nil {
 				return err
 			}
+			// the policy manager saves the policy, with its secret references rather than the resolved values
 			if err := o.addRunner(newPolicyData, currentPolicyPath); err != nil {
 				return err
 			}
-			if err := o.policyRepo.Update(newPolicyData); err != nil {
-				return err
-			}
 		} else {
 			o.logger.Info("current policy version is newer than the one being applied, skipping",
 				zap.String("policy_id", newPolicyData.ID),
@@ -96,40 +96,33 @@ func (o *openTelemetryBackend) ApplyPolicy(newPolicyData policies.PolicyData, up
 
 func (o *openTelemetryBackend) addRunner(policyData policies.PolicyData, policyFilePath string) error {
 	policyContext, policyCancel := context.WithCancel(context.WithValue(o.mainContext, config.ContextKey("policy_id"), policyData.ID))
-	command := cmd.NewCmdOptions(cmd.Options{Buffered: false, Streaming: true}, o.otelExecutablePath, "--config", policyFilePath)
-	go func(ctx context.Context, logger *zap.Logger) {
-		status := command.Start()
-		o.logger.Info("starting otel policy", zap.String("policy_id", policyData.ID),
-			zap.Any("status", command.Status()), zap.Int("process id", command.Status().PID))
-		for !command.Status().Complete {
-			select {
-			case v := <-ctx.Done():
-				err := command.Stop()
-				if err != nil && !slices.Contains([]string{"command not running", "no such process"}, err.Error()) {
-					logger.Error("failed to stop otel", zap.String("policy_id", policyData.ID),
-						zap.Any("value", v), zap.Error(err))
-				}
-				return
-			case line := <-command.Stdout:
-				if line != "" {
-					logger.Info("otel stdout", zap.String("policy_id", policyData.ID), zap.String("line", line))
-				}
-			case line := <-command.Stderr:
-				if line != "" {
-					logger.Warn("otel stderr", zap.String("policy_id", policyData.ID), zap.String("line", line))
-				}
-			case finalStatus := <-status:
-				logger.Info("otel finished", zap.String("policy_id", policyData.ID), zap.Any("status", finalStatus))
+	var sup *supervisor.Supervisor
+	sup = supervisor.New(o.logger.With(zap.String("policy_id", policyData.ID)), supervisor.Options{
+		Name:   "otel",
+		Binary: o.otelExecutablePath,
+		Args:   []string{"--config", policyFilePath},
+		// a collector exiting on its own is restarted, until it is crash looping
+		Restart: &supervisor.RestartPolicy{},
+		OnExit: func(exit supervisor.Exit) {
+			if !exit.Expected {
+				o.collectorChanged(policyData.ID, sup)
 			}
-		}
-	}(policyContext, o.logger)
-	status := command.Status()
+		},
+		OnRestart: func() {
+			o.collectorChanged(policyData.ID, sup)
+		},
+	})
+	// the collector is stopped when the policy context is cancelled
+	if err := sup.Start(policyContext); err != nil {
+		policyCancel()
+		return err
+	}
+	o.logger.Info("starting otel policy", zap.String("policy_id", policyData.ID), zap.Int("process id", sup.PID()))
 	policyEntry := runningPolicy{
-		ctx:        policyContext,
 		cancel:     policyCancel,
 		policyID:   policyData.ID,
 		policyData: policyData,
-		statusChan: &status,
+		sup:        sup,
 	}
 	o.addPolicyControl(policyEntry, policyData.ID)
 
@@ -137,19 +130,100 @@ func (o *openTelemetryBackend) addRunner(policyData policies.PolicyData, policyF
 }
 
 func (o *openTelemetryBackend) addPolicyControl(policyEntry runningPolicy, policyID string) {
+	o.collectorsMu.Lock()
+	defer o.collectorsMu.Unlock()
 	o.runningCollectors[policyID] = policyEntry
 }
 
+// removePolicyControl stops the collector of the policy and waits for it to exit
 func (o *openTelemetryBackend) removePolicyControl(policyID string) {
+	o.collectorsMu.Lock()
 	policy, ok := o.runningCollectors[policyID]
+	delete(o.runningCollectors, policyID)
+	o.collectorsMu.Unlock()
 	if !ok {
 		o.logger.Error("did not find a running collector for policy id", zap.String("policy_id", policyID))
 		return
 	}
+	o.stopCollector(context.Background(), policy)
+}
+
+// stopCollector stops the collector of the policy and reports whether it had to be killed
+func (o *openTelemetryBackend) stopCollector(ctx context.Context, policy runningPolicy) bool {
+	killed, err := policy.sup.Stop(ctx)
+	if err != nil {
+		o.logger.Error("otel collector shutdown error", zap.String("policy_id", policy.policyID), zap.Error(err))
+	}
 	policy.cancel()
+	return killed
+}
+
+func (o *openTelemetryBackend) collectors() []runningPolicy {
+	if o.mode == sharedMode {
+		return o.sharedCollectors()
+	}
+	o.collectorsMu.Lock()
+	defer o.collectorsMu.Unlock()
+	ret := make([]runningPolicy, 0, len(o.runningCollectors))
+	for _, policy := range o.runningCollectors {
+		ret = append(ret, policy)
+	}
+	return ret
+}
+
+func (o *openTelemetryBackend) collectorCount() int {
+	if o.mode == sharedMode {
+		return len(o.sharedCollectors())
+	}
+	o.collectorsMu.Lock()
+	defer o.collectorsMu.Unlock()
+	return len(o.runningCollectors)
+}
+
+// collectorChanged updates the state of the policy once its collector exited or was restarted, unless the
+// collector was since replaced or removed
+func (o *openTelemetryBackend) collectorChanged(policyID string, sup *supervisor.Supervisor) {
+	o.collectorsMu.Lock()
+	policy, ok := o.runningCollectors[policyID]
+	o.collectorsMu.Unlock()
+	if !ok || policy.sup != sup {
+		return
+	}
+	o.syncPolicyState(policy)
+}
+
+// syncPolicyState reports the state of the collector process into its policy: failed to apply, with the reason, as
+// long as the collector is not running
+func (o *openTelemetryBackend) syncPolicyState(policy runningPolicy) (backend.RunningStatus, string) {
+	status, errMsg, _ := policy.sup.Status()
+	state, backendErr := policies.Running, ""
+	if status != backend.Running {
+		state, backendErr = policies.FailedToApply, errMsg
+	}
+	current, err := o.policyRepo.Get(policy.policyID)
+	if err != nil || current.Version != policy.policyData.Version {
+		return status, errMsg
+	}
+	if current.State == state && current.BackendErr == backendErr {
+		return status, errMsg
+	}
+	if status == backend.Running {
+		o.logger.Info("otel collector recovered", zap.String("policy_id", policy.policyID))
+	} else {
+		o.logger.Warn("otel collector not running", zap.String("policy_id", policy.policyID), zap.String("reason", errMsg))
+	}
+	current.State = state
+	current.BackendErr = backendErr
+	if err := o.policyRepo.Update(current); err != nil {
+		o.logger.Error("failed to update policy state", zap.String("policy_id", policy.policyID), zap.Error(err))
+	}
+	return status, errMsg
 }
 
 func (o *openTelemetryBackend) RemovePolicy(data policies.PolicyData) error {
+	if o.mode == sharedMode {
+		return o.removeSharedPolicy(data.ID)
+	}
 	if o.policyRepo.Exists(data.ID) {
 		o.removePolicyControl(data.ID)
 		policyPath := fmt.Sprintf("%s/%s", o.policyConfigDirectory, fmt.Sprintf(tempFileNamePattern, data.ID))
@@ -163,16 +237,3 @@ func (o *openTelemetryBackend) RemovePolicy(data policies.PolicyData) error {
 	o.logger.Warn("no policy was removed, policy not found", zap.String("policy_id", data.ID))
 	return nil
 }
-
-func (o *openTelemetryBackend) ValidatePolicy(otelConfig openTelemetryConfig) error {
-	if otelConfig.Service.Pipelines.Logs == nil &&
-		otelConfig.Service.Pipelines.Metrics == nil &&
-		otelConfig.Service.Pipelines.Traces == nil {
-		return errors.New("no pipelines defined")
-	}
-	if len(otelConfig.Receivers) == 0 {
-		return errors.New("no receivers defined")
-	}
-
-	return nil
-}
@@ -0,0 +1,64 @@
+package otel
+
+import "github.com/netboxlabs/orb-agent/agent/backend"
+
+var _ backend.SchemaProvider = (*openTelemetryBackend)(nil)
+
+// ConfigSchema describes the OpenTelemetry backend options
+func (o *openTelemetryBackend) ConfigSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"binary":    map[string]interface{}{"type": "string", "default": defaultPath, "description": "Path or name of the otelcol-contrib binary."},
+			"otlp_host": map[string]interface{}{"type": "string", "default": defaultHost, "description": "Host of the agent OTLP receiver policies export to."},
+			"otlp_port": map[string]interface{}{"type": "string", "default": "4316", "pattern": "^[0-9]+$", "description": "Port of the agent OTLP receiver policies export to, as a string."},
+			"allowed_receivers": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"},
+				"description": "Receiver types policies may use, any type built into the collector when empty."},
+			"denied_receivers": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"},
+				"description": "Receiver types policies may not use, e.g. filelog on shared hosts."},
+			"mode": map[string]interface{}{"type": "string", "enum": []interface{}{perPolicyMode, sharedMode}, "default": perPolicyMode,
+				"description": "Run a collector per policy, or every policy in a single collector reloaded as policies change."},
+		},
+		"additionalProperties": false,
+	}
+}
+
+// PolicySchema describes an OpenTelemetry collector policy
+func (o *openTelemetryBackend) PolicySchema() map[string]interface{} {
+	component := map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": []interface{}{"object", "null"}}}
+	pipeline := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"receivers":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"processors": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"exporters":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+	}
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"receivers", "service"},
+		"properties": map[string]interface{}{
+			"receivers":  component,
+			"processors": component,
+			"exporters":  component,
+			"extensions": component,
+			"connectors": component,
+			"service": map[string]interface{}{
+				"type":     "object",
+				"required": []interface{}{"pipelines"},
+				"properties": map[string]interface{}{
+					"extensions": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+					"pipelines": map[string]interface{}{
+						"type":          "object",
+						"minProperties": 1,
+						"properties": map[string]interface{}{
+							"metrics": pipeline,
+							"traces":  pipeline,
+							"logs":    pipeline,
+						},
+					},
+				},
+			},
+		},
+	}
+}
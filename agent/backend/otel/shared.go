@@ -0,0 +1,323 @@
+package otel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/netboxlabs/orb-agent/agent/backend/supervisor"
+	"github.com/netboxlabs/orb-agent/agent/config"
+	"github.com/netboxlabs/orb-agent/agent/policies"
+)
+
+const (
+	// perPolicyMode runs a collector per policy
+	perPolicyMode = "per-policy"
+	// sharedMode runs the policies in a single collector
+	sharedMode = "shared"
+
+	sharedConfigFileName = "otel-shared-config.yml"
+	validateTimeout      = 30 * time.Second
+)
+
+// sharedCollector runs every policy of the backend in a single collector, reloaded as policies are applied and
+// removed
+type sharedCollector struct {
+	mu         sync.Mutex
+	configPath string
+	policies   map[string]sharedPolicy
+	cancel     context.CancelFunc
+	sup        *supervisor.Supervisor
+}
+
+type sharedPolicy struct {
+	data   policies.PolicyData
+	config openTelemetryConfig
+}
+
+// sharedCollectorConfig is a collector config merging policies, their pipelines are named after them
+type sharedCollectorConfig struct {
+	Receivers  map[string]interface{} `yaml:"receivers"`
+	Processors map[string]interface{} `yaml:"processors,omitempty"`
+	Extensions map[string]interface{} `yaml:"extensions,omitempty"`
+	Exporters  map[string]interface{} `yaml:"exporters"`
+	Service    sharedService          `yaml:"service"`
+}
+
+type sharedService struct {
+	Extensions []string             `yaml:"extensions,omitempty"`
+	Pipelines  map[string]*pipeline `yaml:"pipelines"`
+	Telemetry  *telemetry           `yaml:"telemetry,omitempty"`
+}
+
+// namespacedID scopes a component or pipeline ID to a policy: type[/name] becomes type/<policy_id>[/name]
+func namespacedID(id string, policyID string) string {
+	componentType, name, found := strings.Cut(id, "/")
+	if !found {
+		return componentType + "/" + policyID
+	}
+	return componentType + "/" + policyID + "/" + name
+}
+
+func namespacedIDs(ids []string, policyID string) []string {
+	if ids == nil {
+		return nil
+	}
+	ret := make([]string, 0, len(ids))
+	for _, id := range ids {
+		ret = append(ret, namespacedID(id, policyID))
+	}
+	return ret
+}
+
+func namespacedComponents(target map[string]interface{}, components map[string]interface{}, policyID string) {
+	for id, component := range components {
+		target[namespacedID(id, policyID)] = component
+	}
+}
+
+// mergePolicyConfigs merges the configs of the policies, once merged with the default values, into a single
+// collector config. Receivers, processors, extensions and pipelines are scoped to their policy, the exporters to the
+// agent are shared.
+func mergePolicyConfigs(configs map[string]openTelemetryConfig) sharedCollectorConfig {
+	merged := sharedCollectorConfig{
+		Receivers:  make(map[string]interface{}),
+		Processors: make(map[string]interface{}),
+		Extensions: make(map[string]interface{}),
+		Exporters:  make(map[string]interface{}),
+		Service:    sharedService{Pipelines: make(map[string]*pipeline)},
+	}
+	policyIDs := make([]string, 0, len(configs))
+	for policyID := range configs {
+		policyIDs = append(policyIDs, policyID)
+	}
+	sort.Strings(policyIDs)
+	for _, policyID := range policyIDs {
+		policyConfig := configs[policyID]
+		namespacedComponents(merged.Receivers, policyConfig.Receivers, policyID)
+		namespacedComponents(merged.Processors, policyConfig.Processors, policyID)
+		namespacedComponents(merged.Extensions, policyConfig.Extensions, policyID)
+		for id, exporter := range policyConfig.Exporters {
+			merged.Exporters[id] = exporter
+		}
+		if policyConfig.Service == nil {
+			continue
+		}
+		merged.Service.Extensions = append(merged.Service.Extensions, namespacedIDs(policyConfig.Service.Extensions, policyID)...)
+		if merged.Service.Telemetry == nil {
+			merged.Service.Telemetry = policyConfig.Service.Telemetry
+		}
+		if policyConfig.Service.Pipelines == nil {
+			continue
+		}
+		for signal, p := range map[string]*pipeline{
+			"metrics": policyConfig.Service.Pipelines.Metrics,
+			"traces":  policyConfig.Service.Pipelines.Traces,
+			"logs":    policyConfig.Service.Pipelines.Logs,
+		} {
+			if p == nil {
+				continue
+			}
+			merged.Service.Pipelines[signal+"/"+policyID] = &pipeline{
+				Receivers:  namespacedIDs(p.Receivers, policyID),
+				Processors: namespacedIDs(p.Processors, policyID),
+				Exporters:  p.Exporters,
+			}
+		}
+	}
+	return merged
+}
+
+// writeConfig validates the collector config merging the policies with the collector, then writes it in place of
+// the current one
+func (o *openTelemetryBackend) writeConfig(policies map[string]sharedPolicy) error {
+	configs := make(map[string]openTelemetryConfig, len(policies))
+	for policyID, policy := range policies {
+		configs[policyID] = policy.config
+	}
+	sharedYaml, err := yaml.Marshal(mergePolicyConfigs(configs))
+	if err != nil {
+		return err
+	}
+	candidatePath := o.shared.configPath + ".new"
+	if err := os.WriteFile(candidatePath, sharedYaml, 0o600); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(o.mainContext, validateTimeout)
+	defer cancel()
+	if output, err := exec.CommandContext(ctx, o.otelExecutablePath, "validate", "--config", candidatePath).CombinedOutput(); err != nil {
+		_ = os.Remove(candidatePath)
+		return fmt.Errorf("invalid shared collector config: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return os.Rename(candidatePath, o.shared.configPath)
+}
+
+// applySharedPolicy adds or updates the policy in the shared collector config, then starts or reloads the collector.
+// A policy making the shared config invalid is not applied, the collector keeps running the other policies.
+func (o *openTelemetryBackend) applySharedPolicy(policyData policies.PolicyData, otelConfig openTelemetryConfig) error {
+	s := o.shared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.policies[policyData.ID]; ok && current.data.Version > policyData.Version {
+		o.logger.Info("current policy version is newer than the one being applied, skipping",
+			zap.String("policy_id", policyData.ID),
+			zap.Int32("current_version", current.data.Version),
+			zap.Int32("incoming_version", policyData.Version))
+		return nil
+	}
+	o.logger.Info("applying policy to the shared collector",
+		zap.String("policy_id", policyData.ID),
+		zap.Int32("version", policyData.Version),
+		zap.String("config_path", s.configPath))
+	next := make(map[string]sharedPolicy, len(s.policies)+1)
+	for policyID, policy := range s.policies {
+		next[policyID] = policy
+	}
+	next[policyData.ID] = sharedPolicy{data: policyData, config: otelConfig}
+	if err := o.writeConfig(next); err != nil {
+		return err
+	}
+	previous := s.policies
+	s.policies = next
+	if err := o.reloadSharedCollector(); err != nil {
+		s.policies = previous
+		if writeErr := o.writeConfig(previous); writeErr != nil {
+			o.logger.Error("failed to restore the shared collector config", zap.Error(writeErr))
+		}
+		return err
+	}
+	return nil
+}
+
+// removeSharedPolicy removes the policy from the shared collector config, then reloads the collector, or stops it
+// once it has no policy left
+func (o *openTelemetryBackend) removeSharedPolicy(policyID string) error {
+	s := o.shared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.policies[policyID]; !ok {
+		o.logger.Warn("no policy was removed, policy not found", zap.String("policy_id", policyID))
+		return nil
+	}
+	o.logger.Info("removing policy from the shared collector", zap.String("policy_id", policyID))
+	if len(s.policies) == 1 {
+		s.policies = make(map[string]sharedPolicy)
+		o.stopSharedCollector(context.Background())
+		// This is a temp file, if it fails to remove, it will be erased once the container is restarted
+		if err := os.Remove(s.configPath); err != nil {
+			o.logger.Warn("failed to remove shared collector config, this won't fail policy removal", zap.Error(err))
+		}
+		return nil
+	}
+	next := make(map[string]sharedPolicy, len(s.policies)-1)
+	for id, policy := range s.policies {
+		if id != policyID {
+			next[id] = policy
+		}
+	}
+	if err := o.writeConfig(next); err != nil {
+		return err
+	}
+	s.policies = next
+	return o.reloadSharedCollector()
+}
+
+// reloadSharedCollector starts the shared collector, or has the running one reload its config. s.mu must be held.
+func (o *openTelemetryBackend) reloadSharedCollector() error {
+	s := o.shared
+	if s.sup != nil && s.sup.PID() != 0 {
+		err := s.sup.Signal(syscall.SIGHUP)
+		if err == nil {
+			o.logger.Info("reloading shared otel collector", zap.Int("policies", len(s.policies)))
+			return nil
+		}
+		o.logger.Warn("failed to reload shared otel collector, restarting it", zap.Error(err))
+	}
+	o.stopSharedCollector(context.Background())
+
+	ctx, cancel := context.WithCancel(context.WithValue(o.mainContext, config.ContextKey("routine"), "otel_shared"))
+	var sup *supervisor.Supervisor
+	sup = supervisor.New(o.logger, supervisor.Options{
+		Name:   "otel",
+		Binary: o.otelExecutablePath,
+		Args:   []string{"--config", s.configPath},
+		// the collector exiting on its own is restarted, until it is crash looping
+		Restart: &supervisor.RestartPolicy{},
+		OnExit: func(exit supervisor.Exit) {
+			if !exit.Expected {
+				o.sharedCollectorChanged(sup)
+			}
+		},
+		OnRestart: func() {
+			o.sharedCollectorChanged(sup)
+		},
+	})
+	if err := sup.Start(ctx); err != nil {
+		cancel()
+		return err
+	}
+	o.logger.Info("started shared otel collector", zap.Int("process id", sup.PID()), zap.Int("policies", len(s.policies)))
+	s.sup = sup
+	s.cancel = cancel
+	return nil
+}
+
+// stopSharedCollector stops the shared collector, waits for it to exit and reports whether it had to be killed. s.mu
+// must be held.
+func (o *openTelemetryBackend) stopSharedCollector(ctx context.Context) bool {
+	s := o.shared
+	if s.sup == nil {
+		return false
+	}
+	killed, err := s.sup.Stop(ctx)
+	if err != nil {
+		o.logger.Error("otel collector shutdown error", zap.Error(err))
+	}
+	s.cancel()
+	s.sup = nil
+	s.cancel = nil
+	return killed
+}
+
+// sharedCollectors returns the policies of the shared collector, each with the shared collector as its own
+func (o *openTelemetryBackend) sharedCollectors() []runningPolicy {
+	s := o.shared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sup == nil {
+		return nil
+	}
+	ret := make([]runningPolicy, 0, len(s.policies))
+	for policyID, policy := range s.policies {
+		ret = append(ret, runningPolicy{policyID: policyID, policyData: policy.data, sup: s.sup})
+	}
+	return ret
+}
+
+// sharedCollectorChanged updates the state of every policy once the shared collector exited or was restarted,
+// unless the collector was since replaced
+func (o *openTelemetryBackend) sharedCollectorChanged(sup *supervisor.Supervisor) {
+	for _, policy := range o.sharedCollectors() {
+		if policy.sup != sup {
+			return
+		}
+		o.syncPolicyState(policy)
+	}
+}
+
+func validateMode(mode string) error {
+	switch mode {
+	case perPolicyMode, sharedMode:
+		return nil
+	}
+	return fmt.Errorf("invalid otel mode %q, must be %s or %s", mode, perPolicyMode, sharedMode)
+}
@@ -0,0 +1,124 @@
+package otel
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/policies"
+)
+
+const sharedTestPolicy = `
+receivers:
+  httpcheck:
+    targets:
+      - endpoint: http://orb.live
+  httpcheck/custom:
+    targets:
+      - endpoint: http://orb.community
+processors:
+  batch:
+service:
+  pipelines:
+    metrics:
+      receivers: [httpcheck, httpcheck/custom]
+      processors: [batch]
+`
+
+func buildPolicyConfig(t *testing.T, policyID string, policy string) openTelemetryConfig {
+	builder := getExporterBuilder(zap.NewNop(), "localhost", 4317)
+	otelConfig, err := builder.GetStructFromYaml(policy)
+	require.NoError(t, err)
+	otelConfig, err = builder.MergeDefaultValueWithPolicy(otelConfig, policyID, policyID+"_name")
+	require.NoError(t, err)
+	return otelConfig
+}
+
+func TestMergePolicyConfigs(t *testing.T) {
+	merged := mergePolicyConfigs(map[string]openTelemetryConfig{
+		"p1": buildPolicyConfig(t, "p1", sharedTestPolicy),
+		"p2": buildPolicyConfig(t, "p2", sharedTestPolicy),
+	})
+
+	assert.ElementsMatch(t, []string{"httpcheck/p1", "httpcheck/p1/custom", "httpcheck/p2", "httpcheck/p2/custom"}, keys(merged.Receivers))
+	assert.ElementsMatch(t, []string{"batch/p1", "transform/p1/policy_data", "batch/p2", "transform/p2/policy_data"}, keys(merged.Processors))
+	assert.Equal(t, []string{"otlp"}, keys(merged.Exporters))
+	require.Len(t, merged.Service.Pipelines, 2)
+	assert.Equal(t, &pipeline{
+		Receivers:  []string{"httpcheck/p2", "httpcheck/p2/custom"},
+		Processors: []string{"batch/p2", "transform/p2/policy_data"},
+		Exporters:  []string{"otlp"},
+	}, merged.Service.Pipelines["metrics/p2"])
+
+	policyData, err := yaml.Marshal(merged.Processors["transform/p2/policy_data"])
+	require.NoError(t, err)
+	assert.Contains(t, string(policyData), `set(attributes["policy_id"], "p2")`)
+	assert.Contains(t, string(policyData), `set(attributes["policy_name"], "p2_name")`)
+}
+
+func keys(m map[string]interface{}) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+func TestOpenTelemetryBackend_sharedCollector(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "events")
+	o := newTestBackend(t, `if [ "$1" = validate ]; then ! grep -q broken "$3"; exit $?; fi
+echo start >> `+out+`
+trap 'echo reload >> `+out+`' HUP
+while true; do sleep 0.05; done`)
+	o.mode = sharedMode
+	o.shared = &sharedCollector{configPath: filepath.Join(t.TempDir(), sharedConfigFileName), policies: make(map[string]sharedPolicy)}
+	apply := func(id string, policy string) error {
+		var data map[string]interface{}
+		require.NoError(t, yaml.Unmarshal([]byte(policy), &data))
+		pd := policies.PolicyData{ID: id, Name: id, Backend: "otel", Version: 1, State: policies.Running, Data: data}
+		require.NoError(t, o.policyRepo.Update(pd))
+		return o.ApplyPolicy(pd, false)
+	}
+	events := func() []string {
+		got, _ := os.ReadFile(out)
+		return strings.Fields(string(got))
+	}
+
+	require.NoError(t, apply("p1", sharedTestPolicy))
+	pid := o.shared.sup.PID()
+	require.NoError(t, apply("p2", sharedTestPolicy))
+	assert.Equal(t, pid, o.shared.sup.PID(), "the collector is reloaded, not restarted")
+	assert.Eventually(t, func() bool { return len(events()) == 2 }, 2*time.Second, 20*time.Millisecond)
+	assert.Equal(t, []string{"start", "reload"}, events())
+
+	assert.ErrorContains(t, apply("p3", strings.ReplaceAll(sharedTestPolicy, "batch", "broken")), "invalid shared collector config")
+	sharedConfig, err := os.ReadFile(o.shared.configPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(sharedConfig), "metrics/p2")
+	assert.NotContains(t, string(sharedConfig), "p3")
+
+	status, msg, _ := o.GetRunningStatus()
+	assert.Equal(t, backend.Running, status)
+	assert.Equal(t, "opentelemetry backend running with 2 policies", msg)
+
+	require.NoError(t, o.RemovePolicy(policies.PolicyData{ID: "p1"}))
+	sharedConfig, err = os.ReadFile(o.shared.configPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(sharedConfig), "p1")
+	require.NoError(t, o.RemovePolicy(policies.PolicyData{ID: "p2"}))
+	assert.Nil(t, o.shared.sup, "the collector is stopped once it has no policy left")
+	assert.NoFileExists(t, o.shared.configPath)
+	status, _, _ = o.GetRunningStatus()
+	assert.Equal(t, backend.Waiting, status)
+
+	require.NoError(t, o.Stop(context.Background()))
+}
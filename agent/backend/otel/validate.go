@@ -0,0 +1,260 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+)
+
+// componentKinds are the kinds of components reported by otelcol-contrib components
+var componentKinds = []string{"receivers", "processors", "exporters", "extensions", "connectors"}
+
+// PolicyError is a problem found in an otel policy, Path locates it in the policy
+type PolicyError struct {
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e PolicyError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// PolicyErrors lists the problems found in an otel policy
+type PolicyErrors []PolicyError
+
+func (e PolicyErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, policyErr := range e {
+		messages = append(messages, policyErr.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns each problem, so they can be reported one by one
+func (e PolicyErrors) Unwrap() []error {
+	ret := make([]error, 0, len(e))
+	for _, policyErr := range e {
+		ret = append(ret, policyErr)
+	}
+	return ret
+}
+
+// componentType returns the type of a component ID, type[/name]
+func componentType(id string) string {
+	componentType, _, _ := strings.Cut(id, "/")
+	return componentType
+}
+
+// loadComponents retrieves the component types built into the collector. The collector then rejects policies using
+// other types, unless it does not report them.
+func (o *openTelemetryBackend) loadComponents() {
+	components, err := collectorComponents(o.otelExecutablePath)
+	if err != nil {
+		o.logger.Warn("failed to retrieve otel collector components, component types are not checked", zap.Error(err))
+		return
+	}
+	o.components = components
+}
+
+// collectorComponents lists the component types built into the collector binary
+func collectorComponents(binary string) (map[string][]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, binary, "components").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list components: %w", err)
+	}
+	components, err := parseComponents(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse components: %w", err)
+	}
+	return components, nil
+}
+
+// parseComponents parses the output of otelcol-contrib components, listing components by name or as
+// {name, stability}, depending on the collector version
+func parseComponents(output []byte) (map[string][]string, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(output, &raw); err != nil {
+		return nil, err
+	}
+	components := make(map[string][]string)
+	for _, kind := range componentKinds {
+		entries, _ := raw[kind].([]interface{})
+		for _, entry := range entries {
+			switch v := entry.(type) {
+			case string:
+				components[kind] = append(components[kind], v)
+			case map[string]interface{}:
+				if name, ok := v["name"].(string); ok {
+					components[kind] = append(components[kind], name)
+				}
+			}
+		}
+	}
+	if len(components["receivers"]) == 0 {
+		return nil, errors.New("no receivers reported")
+	}
+	return components, nil
+}
+
+// checkComponents checks the type of the components defined for kind
+func (o *openTelemetryBackend) checkComponents(kind string, components map[string]interface{}) PolicyErrors {
+	var errs PolicyErrors
+	singular := strings.TrimSuffix(kind, "s")
+	for _, id := range sortedKeys(components) {
+		path := kind + "." + id
+		typ := componentType(id)
+		switch {
+		case typ == "":
+			errs = append(errs, PolicyError{Path: path, Message: fmt.Sprintf("%s ID must start with its type", singular)})
+			continue
+		case o.components[kind] != nil && !slices.Contains(o.components[kind], typ):
+			errs = append(errs, PolicyError{Path: path, Message: fmt.Sprintf("unknown %s type %q", singular, typ)})
+			continue
+		}
+		if kind != "receivers" {
+			continue
+		}
+		if slices.Contains(o.deniedReceivers, typ) {
+			errs = append(errs, PolicyError{Path: path, Message: fmt.Sprintf("receiver type %q is denied on this agent", typ)})
+		} else if len(o.allowedReceivers) > 0 && !slices.Contains(o.allowedReceivers, typ) {
+			errs = append(errs, PolicyError{Path: path, Message: fmt.Sprintf("receiver type %q is not allowed on this agent, allowed types: %s",
+				typ, strings.Join(o.allowedReceivers, ", "))})
+		}
+	}
+	return errs
+}
+
+// checkReferences checks that the components referenced at path are defined, once
+func checkReferences(path string, kind string, refs []string, defined map[string]interface{}) PolicyErrors {
+	var errs PolicyErrors
+	seen := make(map[string]bool, len(refs))
+	for i, ref := range refs {
+		refPath := fmt.Sprintf("%s[%d]", path, i)
+		if _, ok := defined[ref]; !ok {
+			errs = append(errs, PolicyError{Path: refPath, Message: fmt.Sprintf("%s %q is not defined", kind, ref)})
+		}
+		if seen[ref] {
+			errs = append(errs, PolicyError{Path: refPath, Message: fmt.Sprintf("%s %q is referenced more than once", kind, ref)})
+		}
+		seen[ref] = true
+	}
+	return errs
+}
+
+// union returns the components defined in any of maps, e.g. the receivers and connectors a pipeline may receive from
+func union(maps ...map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for _, m := range maps {
+		for key, value := range m {
+			ret[key] = value
+		}
+	}
+	return ret
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// ValidatePolicy checks the components of a policy and the references of its pipelines. Component types are checked
+// against the collector and the receiver types allowed on the agent once the backend is configured. All problems are
+// returned as PolicyErrors.
+func (o *openTelemetryBackend) ValidatePolicy(otelConfig openTelemetryConfig) error {
+	if otelConfig.Service == nil || otelConfig.Service.Pipelines == nil {
+		return PolicyErrors{{Message: "no pipelines defined"}}
+	}
+	if otelConfig.Service.Pipelines.Logs == nil &&
+		otelConfig.Service.Pipelines.Metrics == nil &&
+		otelConfig.Service.Pipelines.Traces == nil {
+		return PolicyErrors{{Message: "no pipelines defined"}}
+	}
+	if len(otelConfig.Receivers) == 0 {
+		return PolicyErrors{{Message: "no receivers defined"}}
+	}
+
+	var errs PolicyErrors
+	errs = append(errs, o.checkComponents("receivers", otelConfig.Receivers)...)
+	errs = append(errs, o.checkComponents("processors", otelConfig.Processors)...)
+	// the exporters of a policy are replaced by the agent's own, their types do not matter
+	errs = append(errs, o.checkComponents("connectors", otelConfig.Connectors)...)
+	errs = append(errs, o.checkComponents("extensions", otelConfig.Extensions)...)
+	// connectors join pipelines, exporting from one and receiving in another
+	receivers := union(otelConfig.Receivers, otelConfig.Connectors)
+	exporters := union(otelConfig.Exporters, otelConfig.Connectors)
+	for _, p := range []struct {
+		signal   string
+		pipeline *pipeline
+	}{
+		{"metrics", otelConfig.Service.Pipelines.Metrics},
+		{"traces", otelConfig.Service.Pipelines.Traces},
+		{"logs", otelConfig.Service.Pipelines.Logs},
+	} {
+		if p.pipeline == nil {
+			continue
+		}
+		path := "service.pipelines." + p.signal
+		if len(p.pipeline.Receivers) == 0 {
+			errs = append(errs, PolicyError{Path: path + ".receivers", Message: "at least one receiver is required"})
+		}
+		errs = append(errs, checkReferences(path+".receivers", "receiver", p.pipeline.Receivers, receivers)...)
+		errs = append(errs, checkReferences(path+".processors", "processor", p.pipeline.Processors, otelConfig.Processors)...)
+		errs = append(errs, checkReferences(path+".exporters", "exporter", p.pipeline.Exporters, exporters)...)
+	}
+	errs = append(errs, checkReferences("service.extensions", "extension", otelConfig.Service.Extensions, otelConfig.Extensions)...)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidatePolicyData checks the policy data offline, with the same checks applied before running a policy.
+// The receiver allow and deny lists are read from config, the backend itself is left untouched. Unless the
+// backend is running, the component types are listed from the configured collector binary; when that fails,
+// a backend.SkippedCheckError is returned along with the problems found.
+func (o *openTelemetryBackend) ValidatePolicyData(config map[string]interface{}, data interface{}) error {
+	policyYaml, err := yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var otelConfig openTelemetryConfig
+	if err := yaml.Unmarshal(policyYaml, &otelConfig); err != nil {
+		return err
+	}
+	validator := &openTelemetryBackend{components: o.components}
+	if validator.allowedReceivers, validator.deniedReceivers, err = receiverLists(config); err != nil {
+		return err
+	}
+	var skipped error
+	if validator.components == nil {
+		binary, ok := config["binary"].(string)
+		if !ok || binary == "" {
+			binary = defaultPath
+		}
+		if validator.components, err = collectorComponents(binary); err != nil {
+			skipped = backend.SkippedCheckError{Check: "otel component types", Reason: err}
+		}
+	}
+	err = validator.ValidatePolicy(otelConfig)
+	if skipped == nil {
+		return err
+	}
+	return errors.Join(err, skipped)
+}
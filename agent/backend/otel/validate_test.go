@@ -0,0 +1,135 @@
+package otel
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/netboxlabs/orb-agent/agent/policies"
+)
+
+func TestParseComponents(t *testing.T) {
+	current, err := parseComponents([]byte(`
+buildinfo:
+  command: otelcol-contrib
+  version: 0.100.0
+receivers:
+  - name: filelog
+    stability:
+      logs: Beta
+  - name: hostmetrics
+processors:
+  - name: batch
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"filelog", "hostmetrics"}, current["receivers"])
+	assert.Equal(t, []string{"batch"}, current["processors"])
+
+	legacy, err := parseComponents([]byte("receivers:\n  - hostmetrics\nextensions:\n  - health_check\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hostmetrics"}, legacy["receivers"])
+	assert.Equal(t, []string{"health_check"}, legacy["extensions"])
+
+	_, err = parseComponents([]byte("not: components"))
+	assert.Error(t, err)
+}
+
+func TestOpenTelemetryBackend_ValidatePolicy(t *testing.T) {
+	const policy = `
+receivers:
+  hostmetrics:
+  filelog/app:
+  mystery:
+processors:
+  batch:
+extensions:
+  health_check:
+service:
+  extensions: [health_check, zpages]
+  pipelines:
+    metrics:
+      receivers: [hostmetrics]
+      processors: [batch]
+    logs:
+      receivers: [filelog/app, mystery]
+`
+	o := &openTelemetryBackend{
+		logger: zap.NewNop(),
+		components: map[string][]string{
+			"receivers":  {"filelog", "hostmetrics"},
+			"processors": {"batch"},
+			"extensions": {"health_check", "zpages"},
+		},
+	}
+	var data map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(policy), &data))
+
+	err := o.ValidatePolicyData(map[string]interface{}{"denied_receivers": []interface{}{"filelog"}}, data)
+	var policyErrs PolicyErrors
+	require.ErrorAs(t, err, &policyErrs)
+	assert.Equal(t, PolicyErrors{
+		{Path: "receivers.filelog/app", Message: `receiver type "filelog" is denied on this agent`},
+		{Path: "receivers.mystery", Message: `unknown receiver type "mystery"`},
+		{Path: "service.extensions[1]", Message: `extension "zpages" is not defined`},
+	}, policyErrs)
+	assert.Equal(t, `receivers.filelog/app: receiver type "filelog" is denied on this agent; receivers.mystery: unknown receiver type "mystery"; service.extensions[1]: extension "zpages" is not defined`, err.Error())
+
+	err = o.ValidatePolicyData(map[string]interface{}{"allowed_receivers": []interface{}{"hostmetrics"}}, data)
+	require.ErrorAs(t, err, &policyErrs)
+	assert.Equal(t, PolicyError{Path: "receivers.filelog/app", Message: `receiver type "filelog" is not allowed on this agent, allowed types: hostmetrics`}, policyErrs[0])
+}
+
+func TestOpenTelemetryBackend_ValidatePolicyConnectors(t *testing.T) {
+	const policy = `
+receivers:
+  filelog:
+connectors:
+  count:
+exporters:
+  debug:
+service:
+  pipelines:
+    logs:
+      receivers: [filelog]
+      exporters: [count, debug]
+    metrics:
+      receivers: [count]
+      exporters: [otlp]
+`
+	o := &openTelemetryBackend{
+		logger:     zap.NewNop(),
+		components: map[string][]string{"receivers": {"filelog"}, "connectors": {"count"}},
+	}
+	var otelConfig openTelemetryConfig
+	require.NoError(t, yaml.Unmarshal([]byte(policy), &otelConfig))
+
+	err := o.ValidatePolicy(otelConfig)
+	var policyErrs PolicyErrors
+	require.ErrorAs(t, err, &policyErrs)
+	assert.Equal(t, PolicyErrors{
+		{Path: "service.pipelines.metrics.exporters[0]", Message: `exporter "otlp" is not defined`},
+	}, policyErrs, "a connector is accepted as a pipeline receiver and exporter")
+
+	merged, err := getExporterBuilder(zap.NewNop(), "localhost", 4317).MergeDefaultValueWithPolicy(otelConfig, "p1", "p1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"count", "otlp"}, merged.Service.Pipelines.Logs.Exporters, "the connector is kept")
+	assert.Equal(t, []string{"otlp"}, merged.Service.Pipelines.Metrics.Exporters)
+	assert.Contains(t, merged.Connectors, "count")
+}
+
+func TestOpenTelemetryBackend_ApplyPolicyInvalid(t *testing.T) {
+	o := newTestBackend(t, "exec sleep 30")
+	o.deniedReceivers = []string{"filelog"}
+	var data map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte("receivers:\n  filelog:\nservice:\n  pipelines:\n    logs:\n      receivers: [filelog]\n"), &data))
+
+	err := o.ApplyPolicy(policies.PolicyData{ID: "p1", Name: "p1", Backend: "otel", Version: 1, Data: data}, false)
+	var policyErrs PolicyErrors
+	assert.True(t, errors.As(err, &policyErrs))
+	assert.EqualError(t, err, `receivers.filelog: receiver type "filelog" is denied on this agent`)
+	assert.Equal(t, 0, o.collectorCount(), "no collector is started for an invalid policy")
+}
@@ -2,30 +2,33 @@ package pktvisor
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/go-cmd/cmd"
 	"go.uber.org/zap"
 
 	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/backend/supervisor"
 	"github.com/netboxlabs/orb-agent/agent/config"
 	"github.com/netboxlabs/orb-agent/agent/policies"
+	"github.com/netboxlabs/orb-agent/agent/redact"
 )
 
 var _ backend.Backend = (*pktvisorBackend)(nil)
+var _ backend.PolicyValidator = (*pktvisorBackend)(nil)
+var _ backend.StopReporter = (*pktvisorBackend)(nil)
+var _ backend.ExitNotifier = (*pktvisorBackend)(nil)
+var _ backend.ReadinessTuner = (*pktvisorBackend)(nil)
 
 const (
 	defaultBinary       = "pktvisord"
-	readinessBackoff    = 10
-	readinessTimeout    = 10
 	applyPolicyTimeout  = 10
 	removePolicyTimeout = 20
 	versionTimeout      = 2
@@ -49,8 +52,10 @@ type pktvisorBackend struct {
 	binary          string
 	configFile      string
 	pktvisorVersion string
-	proc            *cmd.Cmd
-	statusChan      <-chan cmd.Status
+	sup             *supervisor.Supervisor
+	killed          atomic.Bool
+	onExit          func(backend.ProcessExit)
+	readiness       config.ReadinessConfig
 	startTime       time.Time
 	cancelFunc      context.CancelFunc
 	ctx             context.Context
@@ -130,6 +135,21 @@ func (p *pktvisorBackend) Version() (string, error) {
 	return appInfo.App.Version, nil
 }
 
+func (p *pktvisorBackend) SetReadiness(readiness config.ReadinessConfig) {
+	p.readiness = readiness
+}
+
+func (p *pktvisorBackend) SetExitHandler(handler func(backend.ProcessExit)) {
+	p.onExit = handler
+}
+
+// processExited reports the exits the agent did not ask for to the exit handler
+func (p *pktvisorBackend) processExited(exit supervisor.Exit) {
+	if !exit.Expected && p.onExit != nil {
+		p.onExit(exit.ProcessExit())
+	}
+}
+
 func (p *pktvisorBackend) Start(ctx context.Context, cancelFunc context.CancelFunc) error {
 	// this should record the start time whether it's successful or not
 	// because it is used by the automatic restart system for last attempt
@@ -176,98 +196,50 @@ func (p *pktvisorBackend) Start(ctx context.Context, cancelFunc context.CancelFu
 		pvOptions = append(pvOptions, "--cp-custom", ctx.Value("agent_id").(string))
 	}
 
-	p.logger.Info("pktvisor startup", zap.Strings("arguments", pvOptions))
-
-	p.proc = cmd.NewCmdOptions(cmd.Options{
-		Buffered:  false,
-		Streaming: true,
-	}, p.binary, pvOptions...)
-	p.statusChan = p.proc.Start()
-
-	// log STDOUT and STDERR lines streaming from Cmd
-	doneChan := make(chan struct{})
-	go func() {
-		defer func() {
-			if doneChan != nil {
-				close(doneChan)
+	p.logger.Info("pktvisor startup", zap.Strings("arguments", redact.Args(pvOptions)))
+
+	readiness := p.readiness.WithDefaults()
+	p.sup = supervisor.New(p.logger, supervisor.Options{
+		Name:   "pktvisor",
+		Binary: p.binary,
+		Args:   pvOptions,
+		// wait for simple startup errors
+		StartupGrace: time.Second,
+		Readiness: func() error {
+			var appMetrics AppInfo
+			if err := p.request("metrics/app", &appMetrics, http.MethodGet, http.NoBody, "application/json", readiness.TimeoutSeconds()); err != nil {
+				return err
 			}
-		}()
-		for p.proc.Stdout != nil || p.proc.Stderr != nil {
-			select {
-			case line, open := <-p.proc.Stdout:
-				if !open {
-					p.proc.Stdout = nil
-					continue
-				}
-				p.logger.Info("pktvisor stdout", zap.String("log", line))
-			case line, open := <-p.proc.Stderr:
-				if !open {
-					p.proc.Stderr = nil
-					continue
-				}
-				p.logger.Info("pktvisor stderr", zap.String("log", line))
-			}
-		}
-	}()
-
-	// wait for simple startup errors
-	time.Sleep(time.Second)
-
-	status := p.proc.Status()
-
-	if status.Error != nil {
-		p.logger.Error("pktvisor startup error", zap.Error(status.Error))
-		return status.Error
-	}
-
-	if status.Complete {
-		err = p.proc.Stop()
-		if err != nil {
-			p.logger.Error("proc.Stop error", zap.Error(err))
-		}
-		return errors.New("pktvisor startup error, check log")
-	}
-
-	p.logger.Info("pktvisor process started", zap.Int("pid", status.PID))
-
-	var readinessError error
-	for backoff := 0; backoff < readinessBackoff; backoff++ {
-		var appMetrics AppInfo
-		readinessError = p.request("metrics/app", &appMetrics, http.MethodGet, http.NoBody, "application/json", readinessTimeout)
-		if readinessError == nil {
 			p.logger.Info("pktvisor readiness ok, got version ", zap.String("pktvisor_version", appMetrics.App.Version))
-			break
-		}
-		backoffDuration := time.Duration(backoff) * time.Second
-		p.logger.Info("pktvisor is not ready, trying again with backoff", zap.String("backoff backoffDuration", backoffDuration.String()))
-		time.Sleep(backoffDuration)
-	}
-
-	if readinessError != nil {
-		p.logger.Error("pktvisor error on readiness", zap.Error(readinessError))
-		err = p.proc.Stop()
-		if err != nil {
-			p.logger.Error("proc.Stop error", zap.Error(err))
-		}
-		return readinessError
-	}
-
-	return nil
+			return nil
+		},
+		ReadinessAttempts: readiness.Attempts,
+		ReadinessBackoff:  readiness.Backoff,
+		OnExit:            p.processExited,
+	})
+	return p.sup.Start(ctx)
 }
 
 func (p *pktvisorBackend) Stop(ctx context.Context) error {
 	p.logger.Info("routine call to stop pktvisor", zap.Any("routine", ctx.Value(config.ContextKey("routine"))))
 	defer p.cancelFunc()
-	err := p.proc.Stop()
-	finalStatus := <-p.statusChan
+	p.killed.Store(false)
+	if p.sup == nil {
+		return nil
+	}
+	killed, err := p.sup.Stop(ctx)
+	p.killed.Store(killed)
 	if err != nil {
 		p.logger.Error("pktvisor shutdown error", zap.Error(err))
 	}
-
-	p.logger.Info("pktvisor process stopped", zap.Int("pid", finalStatus.PID), zap.Int("exit_code", finalStatus.Exit))
 	return nil
 }
 
+// Killed reports whether the last Stop killed the pktvisor process
+func (p *pktvisorBackend) Killed() bool {
+	return p.killed.Load()
+}
+
 // Configure this will set configurations, but if not set, will use the following defaults
 func (p *pktvisorBackend) Configure(logger *zap.Logger, repo policies.PolicyRepo, config map[string]interface{}, common config.BackendCommons) error {
 	p.logger = logger
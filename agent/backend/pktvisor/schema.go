@@ -0,0 +1,51 @@
+package pktvisor
+
+import "github.com/netboxlabs/orb-agent/agent/backend"
+
+var _ backend.SchemaProvider = (*pktvisorBackend)(nil)
+
+// ConfigSchema describes the pktvisor backend options
+func (p *pktvisorBackend) ConfigSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"binary":      map[string]interface{}{"type": "string", "default": "/usr/local/sbin/pktvisord", "description": "Path of the pktvisord binary."},
+			"config_file": map[string]interface{}{"type": "string", "default": "/opt/orb/agent.yaml", "description": "Config file passed to pktvisord."},
+			"api_host":    map[string]interface{}{"type": "string", "default": defaultAPIHost, "description": "Host of the pktvisord admin API."},
+			"api_port":    map[string]interface{}{"type": "string", "default": defaultAPIPort, "description": "Port of the pktvisord admin API, as a string."},
+		},
+	}
+}
+
+// PolicySchema describes a pktvisor collection policy
+func (p *pktvisorBackend) PolicySchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"input", "handlers"},
+		"properties": map[string]interface{}{
+			"kind": map[string]interface{}{"type": "string", "enum": []interface{}{"collection"}},
+			"input": map[string]interface{}{
+				"type":     "object",
+				"required": []interface{}{"input_type"},
+				"anyOf": []interface{}{
+					map[string]interface{}{"required": []interface{}{"tap"}},
+					map[string]interface{}{"required": []interface{}{"tap_selector"}},
+				},
+				"properties": map[string]interface{}{
+					"tap":          map[string]interface{}{"type": "string"},
+					"tap_selector": map[string]interface{}{"type": "object"},
+					"input_type":   map[string]interface{}{"type": "string"},
+					"config":       map[string]interface{}{"type": "object"},
+					"filter":       map[string]interface{}{"type": "object"},
+				},
+			},
+			"handlers": map[string]interface{}{
+				"type":     "object",
+				"required": []interface{}{"modules"},
+				"properties": map[string]interface{}{
+					"modules": map[string]interface{}{"type": "object"},
+				},
+			},
+		},
+	}
+}
@@ -10,10 +10,11 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/metrics"
 )
 
 // note this needs to be stateless because it is called for multiple go routines
-func (p *pktvisorBackend) request(url string, payload interface{}, method string, body io.Reader, contentType string, timeout int32) error {
+func (p *pktvisorBackend) request(url string, payload interface{}, method string, body io.Reader, contentType string, timeout int32) (err error) {
 	client := http.Client{
 		Timeout: time.Second * time.Duration(timeout),
 	}
@@ -24,6 +25,11 @@ func (p *pktvisorBackend) request(url string, payload interface{}, method string
 		return err
 	}
 
+	start := time.Now()
+	defer func() {
+		metrics.ObserveBackendRequest("pktvisor", method, start, err)
+	}()
+
 	URL := fmt.Sprintf("%s://%s:%s/api/v1/%s", p.adminAPIProtocol, p.adminAPIHost, p.adminAPIPort, url)
 
 	req, err := http.NewRequest(method, URL, body)
@@ -74,25 +80,10 @@ func (p *pktvisorBackend) request(url string, payload interface{}, method string
 }
 
 func (p *pktvisorBackend) getProcRunningStatus() (backend.RunningStatus, string, error) {
-	if p.proc == nil {
+	if p.sup == nil {
 		return backend.Unknown, "backend not started yet", nil
 	}
-	status := p.proc.Status()
-
-	if status.Error != nil {
-		errMsg := fmt.Sprintf("pktvisor process error: %v", status.Error)
-		return backend.BackendError, errMsg, status.Error
-	}
-
-	if status.Complete {
-		err := p.proc.Stop()
-		return backend.Offline, "pktvisor process ended", err
-	}
-
-	if status.StopTs > 0 {
-		return backend.Offline, "pktvisor process ended", nil
-	}
-	return backend.Running, "", nil
+	return p.sup.Status()
 }
 
 // also used for HTTP REST API readiness check
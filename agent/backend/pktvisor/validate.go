@@ -0,0 +1,36 @@
+package pktvisor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ValidatePolicyData checks the shape of a pktvisor policy offline. pktvisord performs the full validation when
+// the policy is applied.
+func (p *pktvisorBackend) ValidatePolicyData(_ map[string]interface{}, data interface{}) error {
+	policy, ok := data.(map[string]interface{})
+	if !ok {
+		return errors.New("policy must be a map")
+	}
+	if kind, ok := policy["kind"]; ok && kind != "collection" {
+		return fmt.Errorf("unsupported policy kind: %v", kind)
+	}
+	input, ok := policy["input"].(map[string]interface{})
+	if !ok {
+		return errors.New("input section is required")
+	}
+	if input["tap"] == nil && input["tap_selector"] == nil {
+		return errors.New("input must define either tap or tap_selector")
+	}
+	if _, ok := input["input_type"].(string); !ok {
+		return errors.New("input.input_type is required")
+	}
+	handlers, ok := policy["handlers"].(map[string]interface{})
+	if !ok {
+		return errors.New("handlers section is required")
+	}
+	if _, ok := handlers["modules"].(map[string]interface{}); !ok {
+		return errors.New("handlers.modules is required")
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package supervisor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the process in its own process group, so signals also reach the processes it spawns
+func setProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func signalProcess(c *exec.Cmd, sig syscall.Signal) error {
+	if err := syscall.Kill(-c.Process.Pid, sig); err == nil {
+		return nil
+	}
+	return c.Process.Signal(sig)
+}
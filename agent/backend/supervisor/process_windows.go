@@ -0,0 +1,15 @@
+package supervisor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(_ *exec.Cmd) {}
+
+func signalProcess(c *exec.Cmd, sig syscall.Signal) error {
+	if sig == syscall.SIGKILL {
+		return c.Process.Kill()
+	}
+	return c.Process.Signal(sig)
+}
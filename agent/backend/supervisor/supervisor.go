@@ -0,0 +1,513 @@
+package supervisor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+)
+
+const (
+	defaultStopTimeout       = 10 * time.Second
+	defaultInitialBackoff    = time.Second
+	defaultMaxBackoff        = time.Minute
+	defaultCrashLoopRestarts = 5
+	defaultCrashLoopWindow   = 5 * time.Minute
+)
+
+// RestartPolicy configures the automatic restart of a process exiting on its own
+type RestartPolicy struct {
+	// InitialBackoff is the delay before the first restart, doubled on each restart within CrashLoopWindow
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between restarts
+	MaxBackoff time.Duration
+	// CrashLoopRestarts restarts within CrashLoopWindow mark the process as crash looping, it is then no longer
+	// restarted
+	CrashLoopRestarts int
+	CrashLoopWindow   time.Duration
+}
+
+// WithDefaults returns the policy with the unset fields set to their default
+func (p RestartPolicy) WithDefaults() RestartPolicy {
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = defaultInitialBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = defaultMaxBackoff
+	}
+	if p.CrashLoopRestarts == 0 {
+		p.CrashLoopRestarts = defaultCrashLoopRestarts
+	}
+	if p.CrashLoopWindow == 0 {
+		p.CrashLoopWindow = defaultCrashLoopWindow
+	}
+	return p
+}
+
+// Backoff returns the jittered delay before the nth restart within the crash loop window
+func (p RestartPolicy) Backoff(n int) time.Duration {
+	d := p.InitialBackoff
+	for i := 0; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxBackoff)
+	// +/- 20% so processes failing together do not restart in lockstep
+	return time.Duration(float64(d) * (0.8 + 0.4*rand.Float64()))
+}
+
+// Options configures a supervised process
+type Options struct {
+	// Name identifies the process in the logs, e.g. "pktvisor"
+	Name   string
+	Binary string
+	Args   []string
+	// Env is added to the agent environment
+	Env []string
+	// StartupGrace is how long Start waits for the process to fail on simple startup errors
+	StartupGrace time.Duration
+	// Readiness is probed after the startup grace period until it succeeds, at most ReadinessAttempts times
+	// with a linear backoff of ReadinessBackoff
+	Readiness         func() error
+	ReadinessAttempts int
+	ReadinessBackoff  time.Duration
+	// StopTimeout is how long Stop waits after SIGTERM before sending SIGKILL
+	StopTimeout time.Duration
+	// Restart enables the automatic restart of the process when it exits on its own
+	Restart *RestartPolicy
+	// OnExit is called every time the process exits, whether it was stopped or not
+	OnExit func(Exit)
+	// OnRestart is called once an automatically restarted process is ready
+	OnRestart func()
+}
+
+// Exit describes the end of a process
+type Exit struct {
+	PID int
+	// Code is the exit code, -1 when the process was killed by a signal
+	Code int
+	// Err is set when the process could not be waited for
+	Err error
+	// Expected is set when the process exited because it was stopped
+	Expected bool
+	Time     time.Time
+	Uptime   time.Duration
+}
+
+func (e Exit) String() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	if e.Code < 0 {
+		return "killed by a signal"
+	}
+	return fmt.Sprintf("exit code %d", e.Code)
+}
+
+// ProcessExit converts the exit for the backend exit handlers
+func (e Exit) ProcessExit() backend.ProcessExit {
+	code := e.Code
+	if e.Err != nil {
+		code = -1
+	}
+	return backend.ProcessExit{Code: code, Reason: e.String(), Time: e.Time}
+}
+
+// Supervisor runs a backend process: it pumps its output to the logs, probes its readiness, stops it gracefully
+// and, when configured, restarts it when it exits on its own
+type Supervisor struct {
+	logger *zap.Logger
+	opts   Options
+
+	mu         sync.Mutex
+	cmd        *exec.Cmd
+	done       chan struct{}
+	startTime  time.Time
+	lastExit   *Exit
+	ctx        context.Context
+	cancel     context.CancelFunc
+	started    bool
+	stopping   bool
+	restarting bool
+	crashLoop  bool
+	restarts   []time.Time
+}
+
+// New creates a supervisor for the process described by opts
+func New(logger *zap.Logger, opts Options) *Supervisor {
+	if opts.StopTimeout == 0 {
+		opts.StopTimeout = defaultStopTimeout
+	}
+	if opts.Restart != nil {
+		restart := opts.Restart.WithDefaults()
+		opts.Restart = &restart
+	}
+	return &Supervisor{logger: logger, opts: opts}
+}
+
+// Args returns the command line arguments of the process
+func (s *Supervisor) Args() []string {
+	return s.opts.Args
+}
+
+// PID returns the process ID of the running process, 0 if it is not running
+func (s *Supervisor) PID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd == nil || s.cmd.Process == nil || isClosed(s.done) {
+		return 0
+	}
+	return s.cmd.Process.Pid
+}
+
+// LastExit returns how the process last exited, if it did
+func (s *Supervisor) LastExit() (Exit, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastExit == nil {
+		return Exit{}, false
+	}
+	return *s.lastExit, true
+}
+
+// Done returns a channel closed when the current process exits
+func (s *Supervisor) Done() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return s.done
+}
+
+func isClosed(ch chan struct{}) bool {
+	if ch == nil {
+		return true
+	}
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Supervisor) pump(r io.Reader, stream string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	log := s.logger.Info
+	if stream == "stderr" {
+		log = s.logger.Warn
+	}
+	for scanner.Scan() {
+		log(s.opts.Name+" "+stream, zap.String("log", scanner.Text()))
+	}
+}
+
+// launch starts a new process
+func (s *Supervisor) launch() error {
+	c := exec.Command(s.opts.Binary, s.opts.Args...)
+	if len(s.opts.Env) > 0 {
+		c.Env = append(os.Environ(), s.opts.Env...)
+	}
+	setProcessGroup(c)
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := c.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	s.mu.Lock()
+	s.cmd = c
+	s.done = done
+	s.startTime = time.Now()
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go s.pump(stdout, "stdout", &wg)
+	go s.pump(stderr, "stderr", &wg)
+	go func() {
+		// the output must be fully read before waiting for the process
+		wg.Wait()
+		err := c.Wait()
+		s.exited(c, done, err)
+	}()
+	return nil
+}
+
+func (s *Supervisor) exited(c *exec.Cmd, done chan struct{}, err error) {
+	exit := Exit{PID: c.Process.Pid, Code: -1, Time: time.Now()}
+	if c.ProcessState != nil {
+		exit.Code = c.ProcessState.ExitCode()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		exit.Err = err
+	}
+
+	s.mu.Lock()
+	exit.Uptime = exit.Time.Sub(s.startTime)
+	exit.Expected = s.stopping
+	s.lastExit = &exit
+	close(done)
+	restart := s.started && !s.stopping && s.opts.Restart != nil && s.ctx != nil && s.ctx.Err() == nil
+	if restart {
+		s.restarting = true
+	}
+	ctx := s.ctx
+	s.mu.Unlock()
+
+	if exit.Expected {
+		s.logger.Info(s.opts.Name+" process stopped", zap.Int("pid", exit.PID), zap.Int("exit_code", exit.Code))
+	} else {
+		s.logger.Warn(s.opts.Name+" process exited unexpectedly", zap.Int("pid", exit.PID), zap.Int("exit_code", exit.Code),
+			zap.Duration("uptime", exit.Uptime), zap.Error(exit.Err))
+	}
+	if s.opts.OnExit != nil {
+		s.opts.OnExit(exit)
+	}
+	if restart {
+		go s.restart(ctx)
+	}
+}
+
+func (s *Supervisor) restart(ctx context.Context) {
+	policy := s.opts.Restart
+	s.mu.Lock()
+	now := time.Now()
+	recent := s.restarts[:0]
+	for _, t := range s.restarts {
+		if now.Sub(t) < policy.CrashLoopWindow {
+			recent = append(recent, t)
+		}
+	}
+	s.restarts = recent
+	if len(recent) >= policy.CrashLoopRestarts {
+		s.crashLoop = true
+		s.restarting = false
+		s.mu.Unlock()
+		s.logger.Error(s.opts.Name+" is crash looping, not restarting it",
+			zap.Int("restarts", len(recent)), zap.Duration("window", policy.CrashLoopWindow))
+		return
+	}
+	delay := policy.Backoff(len(recent))
+	s.mu.Unlock()
+
+	s.logger.Info("restarting "+s.opts.Name, zap.Duration("backoff", delay))
+	select {
+	case <-ctx.Done():
+		s.mu.Lock()
+		s.restarting = false
+		s.mu.Unlock()
+		return
+	case <-time.After(delay):
+	}
+
+	s.mu.Lock()
+	s.restarts = append(s.restarts, time.Now())
+	s.restarting = false
+	if s.stopping {
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	if err := s.launch(); err != nil {
+		s.logger.Error("failed to restart "+s.opts.Name, zap.Error(err))
+		s.mu.Lock()
+		s.lastExit = &Exit{Code: -1, Err: err, Time: time.Now()}
+		s.restarting = true
+		s.mu.Unlock()
+		go s.restart(ctx)
+		return
+	}
+	s.logger.Info(s.opts.Name+" process restarted", zap.Int("pid", s.PID()))
+	if err := s.waitReady(ctx); err != nil {
+		s.logger.Error(s.opts.Name+" not ready after restart", zap.Error(err))
+		return
+	}
+	if s.opts.OnRestart != nil {
+		s.opts.OnRestart()
+	}
+}
+
+// waitReady probes the readiness of the process
+func (s *Supervisor) waitReady(ctx context.Context) error {
+	if s.opts.Readiness == nil {
+		return nil
+	}
+	done := s.Done()
+	var err error
+	for attempt := 0; attempt < max(s.opts.ReadinessAttempts, 1); attempt++ {
+		if err = s.opts.Readiness(); err == nil {
+			s.logger.Info(s.opts.Name + " readiness ok")
+			return nil
+		}
+		backoff := time.Duration(attempt) * s.opts.ReadinessBackoff
+		s.logger.Info(s.opts.Name+" is not ready, trying again with backoff", zap.Duration("backoff", backoff))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			return fmt.Errorf("%s exited before being ready, check log", s.opts.Name)
+		case <-time.After(backoff):
+		}
+	}
+	return err
+}
+
+// Start launches the process, waits for the startup grace period and probes its readiness. The process is
+// stopped when ctx is cancelled.
+func (s *Supervisor) Start(ctx context.Context) error {
+	s.mu.Lock()
+	if s.cmd != nil && !isClosed(s.done) {
+		s.mu.Unlock()
+		return fmt.Errorf("%s is already running", s.opts.Name)
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	s.ctx = runCtx
+	s.cancel = cancel
+	s.started = false
+	s.stopping = false
+	s.restarting = false
+	s.crashLoop = false
+	s.restarts = nil
+	s.mu.Unlock()
+
+	if err := s.launch(); err != nil {
+		cancel()
+		s.logger.Error(s.opts.Name+" startup error", zap.Error(err))
+		return err
+	}
+	pid := s.PID()
+	done := s.Done()
+
+	if s.opts.StartupGrace > 0 {
+		select {
+		case <-done:
+			cancel()
+			exit, _ := s.LastExit()
+			return fmt.Errorf("%s startup error (%s), check log", s.opts.Name, exit)
+		case <-ctx.Done():
+			_, _ = s.Stop(context.Background())
+			return ctx.Err()
+		case <-time.After(s.opts.StartupGrace):
+		}
+	}
+	s.logger.Info(s.opts.Name+" process started", zap.Int("pid", pid))
+
+	if err := s.waitReady(ctx); err != nil {
+		s.logger.Error(s.opts.Name+" error on readiness", zap.Error(err))
+		_, _ = s.Stop(context.Background())
+		return err
+	}
+
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+
+	go func() {
+		<-runCtx.Done()
+		_, _ = s.stop(context.Background(), runCtx)
+	}()
+	return nil
+}
+
+// Stop sends SIGTERM to the process and SIGKILL if it is still running after the stop timeout, or once ctx is
+// done. It reports whether the process had to be killed. Pending restarts are cancelled.
+func (s *Supervisor) Stop(ctx context.Context) (bool, error) {
+	return s.stop(ctx, nil)
+}
+
+// stop stops the process, only if it was started with runCtx when runCtx is not nil, so that a watch left
+// over from an earlier Start does not stop the process of a later one
+func (s *Supervisor) stop(ctx context.Context, runCtx context.Context) (bool, error) {
+	s.mu.Lock()
+	if runCtx != nil && s.ctx != runCtx {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.stopping = true
+	cancel := s.cancel
+	c := s.cmd
+	done := s.done
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	if c == nil || c.Process == nil || isClosed(done) {
+		return false, nil
+	}
+
+	if err := signalProcess(c, syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		s.logger.Warn("failed to send SIGTERM to "+s.opts.Name, zap.Int("pid", c.Process.Pid), zap.Error(err))
+	}
+	select {
+	case <-done:
+		return false, nil
+	case <-time.After(s.opts.StopTimeout):
+	case <-ctx.Done():
+	}
+
+	s.logger.Warn(s.opts.Name+" did not stop in time, killing it", zap.Int("pid", c.Process.Pid), zap.Duration("timeout", s.opts.StopTimeout))
+	if err := signalProcess(c, syscall.SIGKILL); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return true, fmt.Errorf("failed to kill %s: %w", s.opts.Name, err)
+	}
+	<-done
+	return true, nil
+}
+
+// Signal sends sig to the running process, e.g. SIGHUP for it to reload its configuration
+func (s *Supervisor) Signal(sig syscall.Signal) error {
+	s.mu.Lock()
+	c := s.cmd
+	done := s.done
+	s.mu.Unlock()
+	if c == nil || c.Process == nil || isClosed(done) {
+		return fmt.Errorf("%s is not running", s.opts.Name)
+	}
+	return signalProcess(c, sig)
+}
+
+// Status reports the state of the process in backend terms
+func (s *Supervisor) Status() (backend.RunningStatus, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.cmd == nil:
+		return backend.Unknown, "backend not started yet", nil
+	case !isClosed(s.done):
+		return backend.Running, "", nil
+	case s.crashLoop:
+		return backend.BackendError, fmt.Sprintf("%s is crash looping: %d restarts within %s, last %s",
+			s.opts.Name, len(s.restarts), s.opts.Restart.CrashLoopWindow, s.lastExit), nil
+	case s.restarting:
+		return backend.Waiting, fmt.Sprintf("%s is restarting after %s", s.opts.Name, s.lastExit), nil
+	case s.lastExit != nil && s.lastExit.Err != nil:
+		return backend.BackendError, fmt.Sprintf("%s process error: %v", s.opts.Name, s.lastExit.Err), s.lastExit.Err
+	default:
+		return backend.Offline, s.opts.Name + " process ended", nil
+	}
+}
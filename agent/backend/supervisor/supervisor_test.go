@@ -0,0 +1,272 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+)
+
+func script(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "backend.sh")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+content), 0o700))
+	return path
+}
+
+type exits struct {
+	mu  sync.Mutex
+	all []Exit
+}
+
+func (e *exits) add(exit Exit) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.all = append(e.all, exit)
+}
+
+func (e *exits) get() []Exit {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Exit{}, e.all...)
+}
+
+func TestSupervisor_StartStop(t *testing.T) {
+	var recorded exits
+	s := New(zap.NewNop(), Options{
+		Name:         "test",
+		Binary:       script(t, "exec sleep 30\n"),
+		StartupGrace: 100 * time.Millisecond,
+		OnExit:       recorded.add,
+	})
+	status, _, _ := s.Status()
+	assert.Equal(t, backend.Unknown, status)
+
+	require.NoError(t, s.Start(context.Background()))
+	status, _, _ = s.Status()
+	assert.Equal(t, backend.Running, status)
+	assert.NotZero(t, s.PID())
+
+	killed, err := s.Stop(context.Background())
+	require.NoError(t, err)
+	assert.False(t, killed)
+	status, msg, _ := s.Status()
+	assert.Equal(t, backend.Offline, status)
+	assert.Equal(t, "test process ended", msg)
+	require.Len(t, recorded.get(), 1)
+	assert.True(t, recorded.get()[0].Expected)
+	assert.Zero(t, s.PID())
+}
+
+func TestSupervisor_StartupError(t *testing.T) {
+	s := New(zap.NewNop(), Options{Name: "test", Binary: script(t, "exit 3\n"), StartupGrace: time.Second})
+	err := s.Start(context.Background())
+	assert.ErrorContains(t, err, "exit code 3")
+	exit, ok := s.LastExit()
+	require.True(t, ok)
+	assert.Equal(t, 3, exit.Code)
+
+	s = New(zap.NewNop(), Options{Name: "test", Binary: filepath.Join(t.TempDir(), "missing")})
+	assert.Error(t, s.Start(context.Background()))
+}
+
+func TestSupervisor_Readiness(t *testing.T) {
+	attempts := 0
+	s := New(zap.NewNop(), Options{
+		Name:   "test",
+		Binary: script(t, "exec sleep 30\n"),
+		Readiness: func() error {
+			attempts++
+			if attempts < 3 {
+				return errors.New("not ready")
+			}
+			return nil
+		},
+		ReadinessAttempts: 5,
+		ReadinessBackoff:  10 * time.Millisecond,
+	})
+	require.NoError(t, s.Start(context.Background()))
+	assert.Equal(t, 3, attempts)
+	killed, err := s.Stop(context.Background())
+	require.NoError(t, err)
+	assert.False(t, killed)
+
+	s = New(zap.NewNop(), Options{
+		Name:              "test",
+		Binary:            script(t, "exec sleep 30\n"),
+		Readiness:         func() error { return errors.New("not ready") },
+		ReadinessAttempts: 2,
+	})
+	assert.ErrorContains(t, s.Start(context.Background()), "not ready")
+	status, _, _ := s.Status()
+	assert.Equal(t, backend.Offline, status, "a process failing readiness is stopped")
+}
+
+func TestSupervisor_StopKillsAfterTimeout(t *testing.T) {
+	s := New(zap.NewNop(), Options{
+		Name:        "test",
+		Binary:      script(t, "trap '' TERM\nwhile true; do sleep 0.1; done\n"),
+		StopTimeout: 200 * time.Millisecond,
+	})
+	require.NoError(t, s.Start(context.Background()))
+	// give the shell time to install the trap
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	killed, err := s.Stop(context.Background())
+	require.NoError(t, err)
+	assert.True(t, killed)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	exit, ok := s.LastExit()
+	require.True(t, ok)
+	assert.Equal(t, -1, exit.Code, "killed by SIGKILL")
+}
+
+func TestSupervisor_StopOnContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := New(zap.NewNop(), Options{Name: "test", Binary: script(t, "exec sleep 30\n")})
+	require.NoError(t, s.Start(ctx))
+	done := s.Done()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process not stopped on context cancellation")
+	}
+}
+
+func TestSupervisor_EarlierContextCancel(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "started")
+	s := New(zap.NewNop(), Options{
+		Name:   "test",
+		Binary: script(t, "if [ -e "+marker+" ]; then exec sleep 30; fi\ntouch "+marker+"\n"),
+	})
+	first, cancelFirst := context.WithCancel(context.Background())
+	defer cancelFirst()
+	require.NoError(t, s.Start(first))
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("first process did not exit")
+	}
+
+	require.NoError(t, s.Start(context.Background()))
+	done := s.Done()
+	cancelFirst()
+	select {
+	case <-done:
+		t.Fatal("process stopped by the context of an earlier start")
+	case <-time.After(200 * time.Millisecond):
+	}
+	_, err := s.Stop(context.Background())
+	require.NoError(t, err)
+}
+
+func TestSupervisor_RestartAndCrashLoop(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "count")
+	var recorded exits
+	restarted := make(chan struct{}, 10)
+	s := New(zap.NewNop(), Options{
+		Name: "test",
+		// runs long enough to pass the startup grace period, then crashes
+		Binary:       script(t, "echo run >> "+counter+"\nsleep 0.2\nexit 1\n"),
+		StartupGrace: 50 * time.Millisecond,
+		Restart: &RestartPolicy{
+			InitialBackoff:    10 * time.Millisecond,
+			MaxBackoff:        20 * time.Millisecond,
+			CrashLoopRestarts: 2,
+			CrashLoopWindow:   time.Minute,
+		},
+		OnExit:    recorded.add,
+		OnRestart: func() { restarted <- struct{}{} },
+	})
+	require.NoError(t, s.Start(context.Background()))
+
+	require.Eventually(t, func() bool {
+		status, _, _ := s.Status()
+		return status == backend.BackendError
+	}, 10*time.Second, 20*time.Millisecond)
+
+	status, msg, _ := s.Status()
+	assert.Equal(t, backend.BackendError, status)
+	assert.Contains(t, msg, "crash looping")
+	assert.Len(t, restarted, 2)
+	all := recorded.get()
+	require.Len(t, all, 3)
+	for _, exit := range all {
+		assert.False(t, exit.Expected)
+		assert.Equal(t, 1, exit.Code)
+	}
+	content, err := os.ReadFile(counter)
+	require.NoError(t, err)
+	assert.Equal(t, "run\nrun\nrun\n", string(content))
+}
+
+func TestSupervisor_NoRestartAfterStop(t *testing.T) {
+	var recorded exits
+	s := New(zap.NewNop(), Options{
+		Name:    "test",
+		Binary:  script(t, "exec sleep 30\n"),
+		Restart: &RestartPolicy{InitialBackoff: 10 * time.Millisecond},
+		OnExit:  recorded.add,
+	})
+	require.NoError(t, s.Start(context.Background()))
+	killed, err := s.Stop(context.Background())
+	require.NoError(t, err)
+	assert.False(t, killed)
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, recorded.get(), 1)
+	status, _, _ := s.Status()
+	assert.Equal(t, backend.Offline, status)
+}
+
+func TestSupervisor_Signal(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "signals")
+	s := New(zap.NewNop(), Options{
+		Name:         "test",
+		Binary:       script(t, "trap 'echo hup >> "+out+"' HUP\nwhile true; do sleep 0.05; done\n"),
+		StartupGrace: 100 * time.Millisecond,
+	})
+	assert.Error(t, s.Signal(syscall.SIGHUP), "not started yet")
+	require.NoError(t, s.Start(context.Background()))
+	defer func() { _, _ = s.Stop(context.Background()) }()
+
+	require.NoError(t, s.Signal(syscall.SIGHUP))
+	assert.Eventually(t, func() bool {
+		got, _ := os.ReadFile(out)
+		return string(got) == "hup\n"
+	}, 2*time.Second, 20*time.Millisecond)
+	status, _, _ := s.Status()
+	assert.Equal(t, backend.Running, status)
+}
+
+func TestRestartPolicy_Backoff(t *testing.T) {
+	policy := RestartPolicy{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}.WithDefaults()
+	for n, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		got := policy.Backoff(n)
+		assert.GreaterOrEqual(t, got, want*8/10, n)
+		assert.LessOrEqual(t, got, want*12/10, n)
+	}
+}
+
+func TestSupervisor_OutputLevels(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	s := New(zap.New(core), Options{Name: "test", Binary: script(t, "echo out\necho err >&2\n")})
+	require.NoError(t, s.Start(context.Background()))
+	<-s.Done()
+
+	require.Eventually(t, func() bool { return logs.FilterMessage("test stderr").Len() == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, zapcore.InfoLevel, logs.FilterMessage("test stdout").All()[0].Level)
+	assert.Equal(t, zapcore.WarnLevel, logs.FilterMessage("test stderr").All()[0].Level)
+}
@@ -0,0 +1,151 @@
+package backend
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronDescriptors are the @ descriptors accepted in place of a cron expression, besides @every <duration>
+var cronDescriptors = []string{"@yearly", "@annually", "@monthly", "@weekly", "@daily", "@midnight", "@hourly"}
+
+// cronField describes the values accepted by a field of a cron expression
+type cronField struct {
+	name     string
+	min, max int
+	// names accepted instead of values, the first one standing for min
+	names []string
+	// whether the field is the day of month or the day of week, accepting ?, L, W and #
+	dayOfMonth, dayOfWeek bool
+}
+
+var (
+	secondField = cronField{name: "second", min: 0, max: 59}
+	cronFields  = []cronField{
+		{name: "minute", min: 0, max: 59},
+		{name: "hour", min: 0, max: 23},
+		{name: "day of month", min: 1, max: 31, dayOfMonth: true},
+		{name: "month", min: 1, max: 12, names: []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}},
+		// 7 is also sunday
+		{name: "day of week", min: 0, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}, dayOfWeek: true},
+	}
+)
+
+// ValidateSchedule checks the schedule of a policy offline. It accepts cron expressions with 5 fields (or 6, with
+// seconds) and @ descriptors such as @hourly.
+func ValidateSchedule(schedule string) error {
+	if strings.HasPrefix(schedule, "@") {
+		if every, ok := strings.CutPrefix(schedule, "@every "); ok {
+			if d, err := time.ParseDuration(strings.TrimSpace(every)); err != nil || d <= 0 {
+				return fmt.Errorf("invalid cron schedule %q: @every requires a positive duration", schedule)
+			}
+			return nil
+		}
+		if !slices.Contains(cronDescriptors, schedule) {
+			return fmt.Errorf("invalid cron schedule %q: unknown descriptor", schedule)
+		}
+		return nil
+	}
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 && len(fields) != 6 {
+		return fmt.Errorf("invalid cron schedule %q: expected 5 or 6 fields, got %d", schedule, len(fields))
+	}
+	specs := cronFields
+	if len(fields) == 6 {
+		specs = append([]cronField{secondField}, cronFields...)
+	}
+	for i, field := range fields {
+		if err := specs[i].validate(field); err != nil {
+			return fmt.Errorf("invalid cron schedule %q: %s field %q: %w", schedule, specs[i].name, field, err)
+		}
+	}
+	return nil
+}
+
+// validate checks a field, a comma separated list of values, ranges and steps
+func (f cronField) validate(field string) error {
+	for _, item := range strings.Split(field, ",") {
+		if err := f.validateItem(strings.ToUpper(item)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f cronField) validateItem(item string) error {
+	switch {
+	case item == "?" && (f.dayOfMonth || f.dayOfWeek):
+		return nil
+	case item == "L" && (f.dayOfMonth || f.dayOfWeek), item == "LW" && f.dayOfMonth:
+		return nil
+	case strings.HasSuffix(item, "W") && f.dayOfMonth:
+		_, err := f.value(strings.TrimSuffix(item, "W"))
+		return err
+	case strings.HasSuffix(item, "L") && f.dayOfWeek:
+		_, err := f.value(strings.TrimSuffix(item, "L"))
+		return err
+	case strings.Contains(item, "#") && f.dayOfWeek:
+		day, nth, _ := strings.Cut(item, "#")
+		if _, err := f.value(day); err != nil {
+			return err
+		}
+		if n, err := strconv.Atoi(nth); err != nil || n < 1 || n > 5 {
+			return fmt.Errorf("invalid occurrence %q, expected 1 to 5", nth)
+		}
+		return nil
+	}
+
+	rng, step, hasStep := strings.Cut(item, "/")
+	if hasStep {
+		if n, err := strconv.Atoi(step); err != nil || n < 1 {
+			return fmt.Errorf("invalid step %q", step)
+		}
+	}
+	if rng == "*" {
+		return nil
+	}
+	low, high, isRange := strings.Cut(rng, "-")
+	start, err := f.value(low)
+	if err != nil {
+		return err
+	}
+	if !isRange {
+		return nil
+	}
+	end, err := f.value(high)
+	if err != nil {
+		return err
+	}
+	if end < start {
+		return fmt.Errorf("invalid range %q", rng)
+	}
+	return nil
+}
+
+// value parses a single value of the field, by number or name
+func (f cronField) value(s string) (int, error) {
+	if i := slices.Index(f.names, s); i >= 0 {
+		return f.min + i, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", n, f.min, f.max)
+	}
+	return n, nil
+}
+
+// SkippedCheckError is returned by a PolicyValidator, alone or joined with the problems found, when one of its
+// checks could not run offline, e.g. without the backend binary. The policy is not invalid because of it.
+type SkippedCheckError struct {
+	Check  string
+	Reason error
+}
+
+func (e SkippedCheckError) Error() string {
+	return fmt.Sprintf("%s not checked: %v", e.Check, e.Reason)
+}
@@ -0,0 +1,18 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSchedule(t *testing.T) {
+	for _, schedule := range []string{"* * * * *", "0 */2 * * MON-FRI", "30 0 12 * * ?", "@hourly", "0 0 L * *",
+		"0,15,30-45/5 * 1W * 5L", "0 9 * JAN-mar MON#2", "@every 1h30m", "0 0 * * 7"} {
+		assert.NoError(t, ValidateSchedule(schedule), schedule)
+	}
+	for _, schedule := range []string{"", "* * * *", "* * * * * * *", "* * * * $", "abc * * * *", "60 * * * *",
+		"* 24 * * *", "0 0 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "0 0 * * MON#6", "@sometimes"} {
+		assert.Error(t, ValidateSchedule(schedule), schedule)
+	}
+}
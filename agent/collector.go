@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/metrics"
+	"github.com/netboxlabs/orb-agent/agent/policies"
+)
+
+var (
+	backendStatusDesc = prometheus.NewDesc("orb_agent_backend_status",
+		"Current running status of each backend, 1 for the active status.",
+		[]string{"backend", "status"}, nil)
+	backendRestartsDesc = prometheus.NewDesc("orb_agent_backend_restarts_total",
+		"Number of times each backend was restarted.",
+		[]string{"backend"}, nil)
+	policiesDesc = prometheus.NewDesc("orb_agent_policies",
+		"Number of policies known to the agent, by state.",
+		[]string{"state"}, nil)
+	requestAttemptsDesc = prometheus.NewDesc("orb_agent_control_plane_request_attempts_total",
+		"Number of times a control plane request was sent, including retries.",
+		[]string{"request"}, nil)
+	requestAcknowledgedDesc = prometheus.NewDesc("orb_agent_control_plane_request_acknowledged_total",
+		"Number of control plane requests answered by the control plane.",
+		[]string{"request"}, nil)
+)
+
+var (
+	backendStatuses = []backend.RunningStatus{backend.Unknown, backend.Running, backend.BackendError, backend.AgentError, backend.Offline, backend.Waiting}
+	policyStates    = []policies.PolicyState{policies.Unknown, policies.Running, policies.FailedToApply, policies.Offline, policies.NoTapMatch}
+)
+
+// agentCollector exposes agent state that is already tracked elsewhere, read at scrape time
+type agentCollector struct {
+	a *orbAgent
+}
+
+var _ prometheus.Collector = (*agentCollector)(nil)
+
+func (c *agentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- backendStatusDesc
+	ch <- backendRestartsDesc
+	ch <- policiesDesc
+	ch <- requestAttemptsDesc
+	ch <- requestAcknowledgedDesc
+}
+
+func (c *agentCollector) Collect(ch chan<- prometheus.Metric) {
+	for name := range c.a.backends {
+		state, ok := c.a.getBackendState(name)
+		if !ok {
+			continue
+		}
+		for _, status := range backendStatuses {
+			var value float64
+			if state.Status == status {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(backendStatusDesc, prometheus.GaugeValue, value, name, status.String())
+		}
+		ch <- prometheus.MustNewConstMetric(backendRestartsDesc, prometheus.CounterValue, float64(state.RestartCount), name)
+	}
+
+	pdata, err := c.a.policyManager.GetPolicyState()
+	if err != nil {
+		c.a.logger.Error("failed to retrieve policy state for metrics", zap.Error(err))
+	} else {
+		counts := make(map[policies.PolicyState]int, len(policyStates))
+		for _, pd := range pdata {
+			counts[pd.State]++
+		}
+		for _, state := range policyStates {
+			ch <- prometheus.MustNewConstMetric(policiesDesc, prometheus.GaugeValue, float64(counts[state]), state.String())
+		}
+	}
+
+	for _, name := range []string{groupMembershipRequest, agentPoliciesRequest} {
+		stats := c.a.requestRetries.get(name)
+		ch <- prometheus.MustNewConstMetric(requestAttemptsDesc, prometheus.CounterValue, float64(stats.Attempts.Load()), name)
+		ch <- prometheus.MustNewConstMetric(requestAcknowledgedDesc, prometheus.CounterValue, float64(stats.Acknowledged.Load()), name)
+	}
+}
+
+func (a *orbAgent) newMetricsHandler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		&agentCollector{a: a},
+	)
+	reg.MustRegister(metrics.Collectors()...)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
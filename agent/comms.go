@@ -0,0 +1,172 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/orb-community/orb/fleet"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/config"
+	"github.com/netboxlabs/orb-agent/agent/redact"
+)
+
+const (
+	mqttKeepAlive      = 10 * time.Second
+	mqttPingTimeout    = 5 * time.Second
+	mqttConnectTimeout = 5 * time.Minute
+	mqttMaxReconnect   = 2 * time.Minute
+
+	groupSubscribeTimeout = 5 * time.Second
+)
+
+func (a *orbAgent) connect(ctx context.Context, cfg config.MQTTConfig) (mqtt.Client, error) {
+	opts := mqtt.NewClientOptions().AddBroker(cfg.Address).SetClientID(cfg.ID)
+	opts.SetUsername(cfg.ID)
+	opts.SetPassword(cfg.Key)
+	opts.SetKeepAlive(mqttKeepAlive)
+	opts.SetPingTimeout(mqttPingTimeout)
+	opts.SetConnectTimeout(mqttConnectTimeout)
+	opts.SetMaxReconnectInterval(mqttMaxReconnect)
+	opts.SetDefaultPublishHandler(func(_ mqtt.Client, message mqtt.Message) {
+		a.logger.Info("message on unknown channel, ignoring", zap.String("topic", message.Topic()), zap.ByteString("payload", redact.Bytes(message.Payload())))
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		a.logger.Error("connection to mqtt lost, reconnecting", zap.Error(err))
+	})
+	opts.SetReconnectingHandler(func(_ mqtt.Client, _ *mqtt.ClientOptions) {
+		a.logger.Info("attempting to reconnect to mqtt", zap.String("address", cfg.Address))
+	})
+	// the session is clean, so subscriptions must be restored on every (re)connect
+	opts.SetAutoReconnect(true)
+	opts.SetCleanSession(true)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		go a.requestReconnection(ctx, client, cfg)
+	})
+
+	if !a.config.OrbAgent.ConfigManager.Backends.Cloud.TLS.Verify {
+		opts.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	c := mqtt.NewClient(opts)
+	if token := c.Connect(); token.Wait() && token.Error() != nil {
+		return nil, token.Error()
+	}
+
+	return c, nil
+}
+
+func (a *orbAgent) requestReconnection(ctx context.Context, client mqtt.Client, cfg config.MQTTConfig) {
+	select {
+	case <-ctx.Done():
+		return
+	default:
+	}
+	a.logger.Info("connected to mqtt", zap.String("address", cfg.Address), zap.String("agent_id", cfg.ID))
+	for name, be := range a.backends {
+		be.SetCommsClient(cfg.ID, &a.client, fmt.Sprintf("%s/?/%s", a.baseTopic, name))
+	}
+
+	if token := client.Subscribe(a.rpcFromCoreTopic, 1, a.handleRPCFromCore); token.Wait() && token.Error() != nil {
+		a.logger.Error("failed to subscribe to agent control plane RPC topic", zap.String("topic", a.rpcFromCoreTopic), zap.Error(token.Error()))
+		a.logger.Error("critical failure: unable to subscribe to control plane")
+		a.Stop(ctx)
+		return
+	}
+	a.logger.Info("completed RPC subscription to control plane", zap.String("topic", a.rpcFromCoreTopic))
+
+	if err := a.sendCapabilities(); err != nil {
+		a.logger.Error("failed to send agent capabilities", zap.Error(err))
+	}
+
+	if err := a.sendGroupMembershipReq(); err != nil {
+		a.logger.Error("failed to send group membership request", zap.Error(err))
+	}
+}
+
+func (a *orbAgent) nameAgentRPCTopics(channelID string) {
+	base := fmt.Sprintf("channels/%s/messages", channelID)
+	a.rpcToCoreTopic = fmt.Sprintf("%s/%s", base, fleet.RPCToCoreTopic)
+	a.rpcFromCoreTopic = fmt.Sprintf("%s/%s", base, fleet.RPCFromCoreTopic)
+	a.capabilitiesTopic = fmt.Sprintf("%s/%s", base, fleet.CapabilitiesTopic)
+	a.heartbeatsTopic = fmt.Sprintf("%s/%s", base, fleet.HeartbeatsTopic)
+	a.baseTopic = base
+}
+
+func (a *orbAgent) startComms(ctx context.Context, cfg config.MQTTConfig) error {
+	a.logger.Debug("starting mqtt connection")
+	a.mqttConfig = cfg
+	// named before connecting, as the topics are read by the heartbeats while reconnections run on the mqtt
+	// client routines
+	a.nameAgentRPCTopics(cfg.ChannelID)
+	a.agentID = cfg.ID
+	if a.client != nil && a.client.IsConnected() {
+		a.requestReconnection(ctx, a.client, cfg)
+		return nil
+	}
+	client, err := a.connect(ctx, cfg)
+	if err != nil {
+		a.logger.Error("connection failed", zap.String("channel", cfg.ChannelID), zap.String("agent_id", cfg.ID), zap.Error(err))
+		return ErrMqttConnection
+	}
+	a.client = client
+	return nil
+}
+
+func groupRPCFromCoreTopic(channelID string) string {
+	return fmt.Sprintf("channels/%s/messages/%s", channelID, fleet.RPCFromCoreTopic)
+}
+
+func (a *orbAgent) subscribeGroupChannels(groups []fleet.GroupMembershipData) {
+	for _, groupData := range groups {
+		rpcFromCoreTopic := groupRPCFromCoreTopic(groupData.ChannelID)
+
+		token := a.client.Subscribe(rpcFromCoreTopic, 1, a.handleGroupRPCFromCore)
+		ok := token.WaitTimeout(groupSubscribeTimeout)
+		if ok && token.Error() != nil {
+			a.logger.Error("failed to subscribe to group channel/topic", zap.String("group_id", groupData.GroupID), zap.String("group_name", groupData.Name), zap.String("topic", rpcFromCoreTopic), zap.Error(token.Error()))
+			continue
+		}
+		if !ok {
+			a.logger.Error("failed to subscribe to group channel/topic: time out", zap.String("group_id", groupData.GroupID), zap.String("group_name", groupData.Name), zap.String("topic", rpcFromCoreTopic))
+			continue
+		}
+		a.logger.Info("completed RPC subscription to group", zap.String("group_id", groupData.GroupID), zap.String("group_name", groupData.Name), zap.String("topic", rpcFromCoreTopic))
+		a.groupsMutex.Lock()
+		a.groupsInfos[groupData.GroupID] = groupInfo{
+			Name:      groupData.Name,
+			ChannelID: groupData.ChannelID,
+		}
+		a.groupsMutex.Unlock()
+	}
+}
+
+func (a *orbAgent) unsubscribeGroupChannels() {
+	a.logger.Debug("calling to unsub group channels")
+	a.groupsMutex.Lock()
+	defer a.groupsMutex.Unlock()
+	for id, info := range a.groupsInfos {
+		rpcFromCoreTopic := groupRPCFromCoreTopic(info.ChannelID)
+		if token := a.client.Unsubscribe(rpcFromCoreTopic); token.Wait() && token.Error() != nil {
+			a.logger.Warn("failed to unsubscribe to group channel", zap.String("group_id", id), zap.String("group_name", info.Name), zap.String("topic", rpcFromCoreTopic), zap.Error(token.Error()))
+			continue
+		}
+		a.logger.Info("completed RPC unsubscription to group", zap.String("group_id", id), zap.String("group_name", info.Name), zap.String("topic", rpcFromCoreTopic))
+	}
+	a.groupsInfos = make(map[string]groupInfo)
+}
+
+func (a *orbAgent) unsubscribeGroupChannel(channelID string, agentGroupID string) {
+	rpcFromCoreTopic := groupRPCFromCoreTopic(channelID)
+	if token := a.client.Unsubscribe(rpcFromCoreTopic); token.Wait() && token.Error() != nil {
+		a.logger.Warn("failed to unsubscribe to group channel", zap.String("topic", rpcFromCoreTopic), zap.Error(token.Error()))
+		return
+	}
+	a.logger.Info("completed RPC unsubscription to group", zap.String("topic", rpcFromCoreTopic))
+	a.groupsMutex.Lock()
+	delete(a.groupsInfos, agentGroupID)
+	a.groupsMutex.Unlock()
+}
@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/config"
+)
+
+type fakeToken struct {
+	err error
+}
+
+func (t *fakeToken) Wait() bool                       { return true }
+func (t *fakeToken) WaitTimeout(_ time.Duration) bool { return true }
+func (t *fakeToken) Error() error                     { return t.err }
+
+func (t *fakeToken) Done() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+type fakePublish struct {
+	topic   string
+	payload []byte
+}
+
+// fakeMQTTClient records publishes and (un)subscriptions; any other call panics
+type fakeMQTTClient struct {
+	mqtt.Client
+
+	mu           sync.Mutex
+	published    []fakePublish
+	subscribed   []string
+	unsubscribed []string
+	disconnected bool
+}
+
+func (c *fakeMQTTClient) IsConnected() bool { return true }
+
+func (c *fakeMQTTClient) Publish(topic string, _ byte, _ bool, payload interface{}) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published = append(c.published, fakePublish{topic: topic, payload: payload.([]byte)})
+	return &fakeToken{}
+}
+
+func (c *fakeMQTTClient) Subscribe(topic string, _ byte, _ mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscribed = append(c.subscribed, topic)
+	return &fakeToken{}
+}
+
+func (c *fakeMQTTClient) Unsubscribe(topics ...string) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unsubscribed = append(c.unsubscribed, topics...)
+	return &fakeToken{}
+}
+
+func (c *fakeMQTTClient) Disconnect(_ uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.disconnected = true
+}
+
+func (c *fakeMQTTClient) publishes() []fakePublish {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]fakePublish(nil), c.published...)
+}
+
+func Test_orbAgent_nameAgentRPCTopics(t *testing.T) {
+	a := orbAgent{}
+	a.nameAgentRPCTopics("chan-1")
+
+	assert.Equal(t, "channels/chan-1/messages", a.baseTopic)
+	assert.Equal(t, "channels/chan-1/messages/tocore", a.rpcToCoreTopic)
+	assert.Equal(t, "channels/chan-1/messages/fromcore", a.rpcFromCoreTopic)
+	assert.Equal(t, "channels/chan-1/messages/agent", a.capabilitiesTopic)
+	assert.Equal(t, "channels/chan-1/messages/hb", a.heartbeatsTopic)
+}
+
+func Test_orbAgent_startComms_connected(t *testing.T) {
+	client := &fakeMQTTClient{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := orbAgent{logger: zap.NewNop(), client: client, asyncContext: ctx}
+	cfg := config.MQTTConfig{ID: "agent-1", ChannelID: "chan-1"}
+
+	require.NoError(t, a.startComms(ctx, cfg))
+	assert.Equal(t, "agent-1", a.agentID)
+	assert.Equal(t, "channels/chan-1/messages/hb", a.heartbeatsTopic)
+	assert.Equal(t, []string{a.rpcFromCoreTopic}, client.subscribed)
+
+	// a reconnection only reads the topics, while the heartbeats do the same
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.requestReconnection(ctx, client, cfg)
+	}()
+	assert.Equal(t, "channels/chan-1/messages/hb", a.heartbeatsTopic)
+	<-done
+}
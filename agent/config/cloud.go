@@ -17,6 +17,9 @@ import (
 	"github.com/jmoiron/sqlx"
 	migrate "github.com/rubenv/sql-migrate"
 	"go.uber.org/zap"
+	_ "modernc.org/sqlite" // registers the pure Go "sqlite" database/sql driver
+
+	"github.com/netboxlabs/orb-agent/agent/redact"
 )
 
 var _ Manager = (*cloudConfigManager)(nil)
@@ -70,7 +73,7 @@ func (cc *cloudConfigManager) request(address string, token string, response int
 		return err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	cc.logger.Debug("cloud api request", zap.String("url", req.URL.String()), zap.ByteString("body", body))
+	cc.logger.Debug("cloud api request", zap.String("url", req.URL.String()), zap.ByteString("body", redact.Bytes(body)))
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 
 	res, getErr := client.Do(req)
@@ -158,7 +161,7 @@ func (cc *cloudConfigManager) autoProvision(apiAddress string, token string) (MQ
 
 func (cc *cloudConfigManager) GetConfig() (MQTTConfig, error) {
 	cc.logger.Info("using local config db", zap.String("filename", cc.config.DB.File))
-	db, err := sqlx.Connect("sqlite3", cc.config.DB.File)
+	db, err := sqlx.Connect("sqlite", cc.config.DB.File)
 	if err != nil {
 		return MQTTConfig{}, err
 	}
@@ -173,6 +176,7 @@ func (cc *cloudConfigManager) GetConfig() (MQTTConfig, error) {
 			zap.String("address", mqtt.Address),
 			zap.String("id", mqtt.ID))
 		return MQTTConfig{
+			Connect:   true,
 			Address:   mqtt.Address,
 			ID:        mqtt.ID,
 			Key:       mqtt.Key,
@@ -200,6 +204,7 @@ func (cc *cloudConfigManager) GetConfig() (MQTTConfig, error) {
 	} else {
 		// successfully loaded previous auto provision
 		dba.Address = mqtt.Address
+		dba.Connect = true
 		cc.logger.Info("using previous auto provisioned cloud configuration loaded from local storage",
 			zap.String("address", mqtt.Address),
 			zap.String("id", dba.ID))
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Restart conditions of a RestartPolicyConfig
+const (
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNever     = "never"
+)
+
+const (
+	defaultMaxRestarts   = 5
+	defaultRestartWindow = 10 * time.Minute
+)
+
+// RestartPolicyConfig represents when the agent restarts a backend whose process exited or stopped responding
+type RestartPolicyConfig struct {
+	// Condition is "on-failure" (default), "always" or "never". On failure, a backend exiting with a zero exit code
+	// is not restarted.
+	Condition string `mapstructure:"condition"`
+	// MaxRestarts within Window after which the agent gives up restarting the backend, 5 when unset
+	MaxRestarts int `mapstructure:"max_restarts"`
+	// Window is 10 minutes when unset
+	Window time.Duration `mapstructure:"window"`
+}
+
+// WithDefaults returns the policy with its unset fields set to their default
+func (r RestartPolicyConfig) WithDefaults() RestartPolicyConfig {
+	if r.Condition == "" {
+		r.Condition = RestartOnFailure
+	}
+	if r.MaxRestarts == 0 {
+		r.MaxRestarts = defaultMaxRestarts
+	}
+	if r.Window == 0 {
+		r.Window = defaultRestartWindow
+	}
+	return r
+}
+
+// Validate checks the restart policy
+func (r RestartPolicyConfig) Validate() error {
+	switch r.Condition {
+	case "", RestartAlways, RestartOnFailure, RestartNever:
+	default:
+		return fmt.Errorf("unknown restart condition %q, expected %s, %s or %s", r.Condition, RestartAlways, RestartOnFailure, RestartNever)
+	}
+	if r.MaxRestarts < 0 {
+		return fmt.Errorf("max_restarts must not be negative, got %d", r.MaxRestarts)
+	}
+	if r.Window < 0 {
+		return fmt.Errorf("window must not be negative, got %s", r.Window)
+	}
+	return nil
+}
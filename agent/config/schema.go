@@ -0,0 +1,201 @@
+package config
+
+import "maps"
+
+// SchemaDraft is the JSON Schema dialect of the generated schema
+const SchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// BackendSchema holds the JSON Schemas a backend contributes to the agent configuration schema
+type BackendSchema struct {
+	// Config describes the backend options under orb.backends.<name>
+	Config map[string]interface{}
+	// Policy describes a single policy under orb.policies.<name>.<policy name>
+	Policy map[string]interface{}
+}
+
+func stringSchema(description string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "description": description}
+}
+
+func boolSchema(description string) map[string]interface{} {
+	return map[string]interface{}{"type": "boolean", "description": description}
+}
+
+func objectSchema(description string, properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":                 "object",
+		"description":          description,
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func stringMapSchema(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":                 "object",
+		"description":          description,
+		"additionalProperties": map[string]interface{}{"type": "string"},
+	}
+}
+
+func commonsSchema() map[string]interface{} {
+	return objectSchema("Settings shared by every backend.", map[string]interface{}{
+		"otel": objectSchema("OpenTelemetry receiver the backends export to.", map[string]interface{}{
+			"host":       stringSchema("Host of the OTLP receiver."),
+			"port":       map[string]interface{}{"type": "integer", "description": "Port of the OTLP receiver.", "minimum": 0, "maximum": 65535},
+			"agent_tags": stringMapSchema("Tags added to the telemetry of every backend."),
+		}),
+		"diode": objectSchema("Diode server the discovery backends send results to.", map[string]interface{}{
+			"target":     stringSchema("Diode server address, e.g. grpc://diode.example.com:8080/diode."),
+			"api_key":    stringSchema("Diode API key."),
+			"agent_name": stringSchema("Name identifying this agent in Diode."),
+		}),
+	})
+}
+
+func durationSchema(description string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "pattern": "^([0-9]+(\\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$", "description": description}
+}
+
+func backendTimingProperties() map[string]interface{} {
+	return map[string]interface{}{
+		"restart_delay": durationSchema("How long after its last start a backend found not running by the heartbeat is restarted, 5m by default."),
+		"readiness": objectSchema("How the backend is probed for readiness once its process started.", map[string]interface{}{
+			"attempts": map[string]interface{}{"type": "integer", "minimum": 0, "description": "Probes before the backend start fails, 10 by default."},
+			"backoff":  durationSchema("Delay added between two probes at each attempt, 1s by default."),
+			"timeout":  durationSchema("Timeout of each probe, 10s by default."),
+		}),
+	}
+}
+
+func timingSchema() map[string]interface{} {
+	properties := backendTimingProperties()
+	properties["heartbeat_interval"] = durationSchema("How often the agent checks its backends and sends a heartbeat, 50s by default, at least 1s.")
+	properties["shutdown_timeout"] = durationSchema("How long the agent waits on stop for its policies to be removed and its backend processes to exit before killing them, 30s by default.")
+	properties["backends"] = map[string]interface{}{
+		"type":                 "object",
+		"description":          "Overrides of restart_delay and readiness, by backend name.",
+		"additionalProperties": objectSchema("Timings of the backend.", backendTimingProperties()),
+	}
+	return objectSchema("Intervals and timeouts of the agent, as Go durations such as 30s or 5m.", properties)
+}
+
+func configManagerSchema() map[string]interface{} {
+	return objectSchema("Where the agent gets its configuration from.", map[string]interface{}{
+		"active": map[string]interface{}{"type": "string", "enum": []interface{}{"local", "cloud"}, "description": "Active config manager."},
+		"backends": objectSchema("Config manager settings.", map[string]interface{}{
+			"local": objectSchema("Local config manager.", map[string]interface{}{
+				"config": stringSchema("Path to the local config file."),
+			}),
+			"orbcloud": objectSchema("Orb cloud config manager.", map[string]interface{}{
+				"config": objectSchema("Agent settings.", map[string]interface{}{
+					"agent_name":     stringSchema("Name of the agent."),
+					"auto_provision": boolSchema("Provision the agent through the API when no credentials are stored."),
+				}),
+				"api": objectSchema("Control plane API.", map[string]interface{}{
+					"address": stringSchema("API address."),
+					"token":   stringSchema("API token used for auto provisioning."),
+				}),
+				"mqtt": objectSchema("Control plane MQTT broker.", map[string]interface{}{
+					"connect":    boolSchema("Connect to the broker."),
+					"address":    stringSchema("Broker address."),
+					"id":         stringSchema("Agent ID."),
+					"key":        stringSchema("Agent key."),
+					"channel_id": stringSchema("Agent channel ID."),
+				}),
+				"tls": objectSchema("TLS settings.", map[string]interface{}{
+					"verify": boolSchema("Verify the server certificates."),
+				}),
+				"db": objectSchema("Local database storing provisioned credentials.", map[string]interface{}{
+					"file": stringSchema("Database file."),
+				}),
+				"tags": stringMapSchema("Agent tags sent when provisioning."),
+			}),
+		}),
+	})
+}
+
+// withPolicyID adds the agent level `id` field to a backend policy schema
+func withPolicyID(policy map[string]interface{}) map[string]interface{} {
+	if policy == nil {
+		policy = map[string]interface{}{"type": "object"}
+	}
+	policy = maps.Clone(policy)
+	properties, _ := policy["properties"].(map[string]interface{})
+	properties = maps.Clone(properties)
+	if properties == nil {
+		properties = make(map[string]interface{})
+	}
+	properties["id"] = stringSchema("Pins the policy ID, by default derived from the backend and policy name. Not passed to the backend.")
+	policy["properties"] = properties
+	return policy
+}
+
+// Schema returns the JSON Schema of the agent configuration file, including the options and policies of
+// the given backends
+func Schema(backends map[string]BackendSchema) map[string]interface{} {
+	backendProperties := map[string]interface{}{"common": commonsSchema()}
+	policyProperties := make(map[string]interface{}, len(backends))
+	for name, schema := range backends {
+		backendConfig := schema.Config
+		if backendConfig == nil {
+			backendConfig = map[string]interface{}{"type": "object"}
+		}
+		// a backend may be enabled with an empty entry
+		backendProperties[name] = map[string]interface{}{"anyOf": []interface{}{backendConfig, map[string]interface{}{"type": "null"}}}
+		policyProperties[name] = map[string]interface{}{
+			"type":                 "object",
+			"description":          "Policies run by the " + name + " backend, by policy name.",
+			"additionalProperties": withPolicyID(schema.Policy),
+		}
+	}
+
+	return map[string]interface{}{
+		"$schema":  SchemaDraft,
+		"title":    "Orb agent configuration",
+		"type":     "object",
+		"required": []interface{}{"orb"},
+		"properties": map[string]interface{}{
+			"version": map[string]interface{}{"type": []interface{}{"string", "number"}, "description": "Configuration file version, all merged files must use the same one."},
+			"orb": map[string]interface{}{
+				"type":     "object",
+				"required": []interface{}{"backends"},
+				"properties": map[string]interface{}{
+					"backends":       objectSchema("Backends run by the agent, by backend name.", backendProperties),
+					"policies":       objectSchema("Policies run by the agent, by backend name.", policyProperties),
+					"tags":           stringMapSchema("Tags attached to the agent."),
+					"config_manager": configManagerSchema(),
+					"policy_repo": objectSchema("Where policies are stored.", map[string]interface{}{
+						"type": map[string]interface{}{"type": "string", "enum": []interface{}{"memory", "sqlite"}, "description": "Policy repository type."},
+						"file": stringSchema("SQLite database file, required by the sqlite repository."),
+					}),
+					"http": objectSchema("Local HTTP status and control API.", map[string]interface{}{
+						"address": stringSchema("Listen address, e.g. localhost:10850. The API is disabled when empty. It has no authentication and must stay on a loopback address."),
+					}),
+					"secrets": objectSchema("Resolution of the ${env:VAR} and ${file:/path} references found in policies and common settings.", map[string]interface{}{
+						"strict": boolSchema("Fail the policy when a reference cannot be resolved, instead of keeping it as is."),
+						"redact_keys": map[string]interface{}{
+							"type":        "array",
+							"items":       map[string]interface{}{"type": "string", "format": "regex"},
+							"description": "Case-insensitive patterns of keys whose values are redacted from the logs, in addition to password, secret, token, api_key and key.",
+						},
+					}),
+					"restart_policy": objectSchema("When the agent restarts a backend whose process exited or stopped responding.", map[string]interface{}{
+						"condition": map[string]interface{}{
+							"type":        "string",
+							"enum":        []interface{}{RestartOnFailure, RestartAlways, RestartNever},
+							"description": "Restart always, never, or on failure only: not when the process exits with a zero exit code. Defaults to on-failure.",
+						},
+						"max_restarts": map[string]interface{}{"type": "integer", "minimum": 0, "description": "Restarts within the window after which the agent gives up restarting the backend, 5 when unset."},
+						"window":       durationSchema("Window of max_restarts, 10m by default."),
+					}),
+					"timing": timingSchema(),
+					"debug": objectSchema("Debug settings.", map[string]interface{}{
+						"enable": boolSchema("Enable debug logging."),
+					}),
+				},
+				"additionalProperties": false,
+			},
+		},
+	}
+}
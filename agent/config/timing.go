@@ -0,0 +1,151 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	defaultHeartbeatInterval = 50 * time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultRestartDelay      = 5 * time.Minute
+	defaultReadinessAttempts = 10
+	defaultReadinessBackoff  = time.Second
+	defaultReadinessTimeout  = 10 * time.Second
+	minHeartbeatInterval     = time.Second
+)
+
+// ReadinessConfig represents how a backend is probed for readiness once its process started. Unset fields keep
+// their default.
+type ReadinessConfig struct {
+	// Attempts is the number of probes before the backend start fails, 10 by default
+	Attempts int `mapstructure:"attempts"`
+	// Backoff is the delay added between two probes at each attempt, 1 second by default
+	Backoff time.Duration `mapstructure:"backoff"`
+	// Timeout of each probe, 10 seconds by default
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// BackendTimingConfig represents the timings of a backend. Unset fields keep their default.
+type BackendTimingConfig struct {
+	// RestartDelay is how long after its last start a backend found not running by the heartbeat is restarted,
+	// 5 minutes by default
+	RestartDelay time.Duration   `mapstructure:"restart_delay"`
+	Readiness    ReadinessConfig `mapstructure:"readiness"`
+}
+
+// TimingConfig represents the intervals and timeouts of the agent, with overrides per backend
+type TimingConfig struct {
+	// HeartbeatInterval is how often the agent checks its backends and sends a heartbeat, 50 seconds by default
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"`
+	// ShutdownTimeout is how long the agent waits on stop for its policies to be removed and its backend
+	// processes to exit before killing them, 30 seconds by default
+	ShutdownTimeout     time.Duration `mapstructure:"shutdown_timeout"`
+	BackendTimingConfig `mapstructure:",squash"`
+	// Backends overrides the backend timings by backend name
+	Backends map[string]BackendTimingConfig `mapstructure:"backends"`
+}
+
+// Heartbeat returns the heartbeat interval
+func (t TimingConfig) Heartbeat() time.Duration {
+	if t.HeartbeatInterval == 0 {
+		return defaultHeartbeatInterval
+	}
+	return t.HeartbeatInterval
+}
+
+// Shutdown returns the shutdown timeout
+func (t TimingConfig) Shutdown() time.Duration {
+	if t.ShutdownTimeout == 0 {
+		return defaultShutdownTimeout
+	}
+	return t.ShutdownTimeout
+}
+
+// ForBackend returns the timings of the named backend: its overrides, then the agent wide settings, then the defaults
+func (t TimingConfig) ForBackend(name string) BackendTimingConfig {
+	ret := t.Backends[name]
+	if ret.RestartDelay == 0 {
+		ret.RestartDelay = t.RestartDelay
+	}
+	if ret.Readiness.Attempts == 0 {
+		ret.Readiness.Attempts = t.Readiness.Attempts
+	}
+	if ret.Readiness.Backoff == 0 {
+		ret.Readiness.Backoff = t.Readiness.Backoff
+	}
+	if ret.Readiness.Timeout == 0 {
+		ret.Readiness.Timeout = t.Readiness.Timeout
+	}
+	return ret.withDefaults()
+}
+
+// WithDefaults returns the readiness settings with their unset fields set to their default
+func (r ReadinessConfig) WithDefaults() ReadinessConfig {
+	if r.Attempts == 0 {
+		r.Attempts = defaultReadinessAttempts
+	}
+	if r.Backoff == 0 {
+		r.Backoff = defaultReadinessBackoff
+	}
+	if r.Timeout == 0 {
+		r.Timeout = defaultReadinessTimeout
+	}
+	return r
+}
+
+// TimeoutSeconds returns the probe timeout in whole seconds, as used by the backend REST clients
+func (r ReadinessConfig) TimeoutSeconds() int32 {
+	return int32(math.Ceil(r.Timeout.Seconds()))
+}
+
+func (b BackendTimingConfig) withDefaults() BackendTimingConfig {
+	if b.RestartDelay == 0 {
+		b.RestartDelay = defaultRestartDelay
+	}
+	b.Readiness = b.Readiness.WithDefaults()
+	return b
+}
+
+// validate checks the timings, prefix locates them in the error messages
+func (b BackendTimingConfig) validate(prefix string) []error {
+	var errs []error
+	if b.RestartDelay < 0 {
+		errs = append(errs, fmt.Errorf("%srestart_delay must not be negative, got %s", prefix, b.RestartDelay))
+	}
+	if b.Readiness.Attempts < 0 {
+		errs = append(errs, fmt.Errorf("%sreadiness.attempts must not be negative, got %d", prefix, b.Readiness.Attempts))
+	}
+	if b.Readiness.Backoff < 0 {
+		errs = append(errs, fmt.Errorf("%sreadiness.backoff must not be negative, got %s", prefix, b.Readiness.Backoff))
+	}
+	if b.Readiness.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("%sreadiness.timeout must not be negative, got %s", prefix, b.Readiness.Timeout))
+	}
+	return errs
+}
+
+// Validate checks the timings, the backend names of the overrides are checked against the configured backends by
+// the caller
+func (t TimingConfig) Validate() error {
+	var errs []error
+	if t.HeartbeatInterval != 0 && t.HeartbeatInterval < minHeartbeatInterval {
+		errs = append(errs, fmt.Errorf("heartbeat_interval must be at least %s, got %s", minHeartbeatInterval, t.HeartbeatInterval))
+	}
+	if t.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("shutdown_timeout must not be negative, got %s", t.ShutdownTimeout))
+	}
+	errs = append(errs, t.BackendTimingConfig.validate("")...)
+	names := make([]string, 0, len(t.Backends))
+	for name := range t.Backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = append(errs, t.Backends[name].validate("backends."+name+".")...)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimingConfig_ForBackend(t *testing.T) {
+	timing := TimingConfig{
+		BackendTimingConfig: BackendTimingConfig{Readiness: ReadinessConfig{Attempts: 20}},
+		Backends: map[string]BackendTimingConfig{
+			"pktvisor": {RestartDelay: time.Minute, Readiness: ReadinessConfig{Timeout: 2500 * time.Millisecond}},
+		},
+	}
+	assert.Equal(t, 50*time.Second, timing.Heartbeat())
+	assert.Equal(t, 30*time.Second, timing.Shutdown())
+
+	pktvisor := timing.ForBackend("pktvisor")
+	assert.Equal(t, time.Minute, pktvisor.RestartDelay)
+	assert.Equal(t, ReadinessConfig{Attempts: 20, Backoff: time.Second, Timeout: 2500 * time.Millisecond}, pktvisor.Readiness)
+	assert.Equal(t, int32(3), pktvisor.Readiness.TimeoutSeconds())
+
+	otel := timing.ForBackend("otel")
+	assert.Equal(t, 5*time.Minute, otel.RestartDelay)
+	assert.Equal(t, ReadinessConfig{Attempts: 20, Backoff: time.Second, Timeout: 10 * time.Second}, otel.Readiness)
+}
+
+func TestTimingConfig_Validate(t *testing.T) {
+	assert.NoError(t, TimingConfig{}.Validate())
+	err := TimingConfig{
+		HeartbeatInterval: 100 * time.Millisecond,
+		ShutdownTimeout:   -time.Second,
+		Backends: map[string]BackendTimingConfig{
+			"pktvisor": {Readiness: ReadinessConfig{Attempts: -1}},
+		},
+	}.Validate()
+	assert.EqualError(t, err, "heartbeat_interval must be at least 1s, got 100ms\nshutdown_timeout must not be negative, got -1s\nbackends.pktvisor.readiness.attempts must not be negative, got -1")
+}
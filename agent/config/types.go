@@ -69,12 +69,39 @@ type BackendCommons struct {
 	}
 }
 
+// PolicyRepoConfig represents the configuration for the policy repository
+type PolicyRepoConfig struct {
+	// Type is either "memory" (default) or "sqlite"
+	Type string `mapstructure:"type"`
+	File string `mapstructure:"file"`
+}
+
+// HTTPConfig represents the configuration for the local HTTP status and control API
+type HTTPConfig struct {
+	// Address is the listen address, e.g. "localhost:10850". The API is disabled when empty. It has no
+	// authentication, so the address must stay on loopback.
+	Address string `mapstructure:"address"`
+}
+
+// SecretsConfig represents the configuration of the secret references resolution
+type SecretsConfig struct {
+	// Strict fails the policy, or the backends start, when a reference cannot be resolved instead of keeping it as is
+	Strict bool `mapstructure:"strict"`
+	// RedactKeys are patterns of additional keys whose values are redacted from the logs
+	RedactKeys []string `mapstructure:"redact_keys"`
+}
+
 // OrbAgent represents the configuration for the Orb agent
 type OrbAgent struct {
 	Backends      map[string]map[string]interface{} `mapstructure:"backends"`
 	Policies      map[string]map[string]interface{} `mapstructure:"policies"`
 	Tags          map[string]string                 `mapstructure:"tags"`
 	ConfigManager ManagerConfig                     `mapstructure:"config_manager"`
+	PolicyRepo    PolicyRepoConfig                  `mapstructure:"policy_repo"`
+	HTTP          HTTPConfig                        `mapstructure:"http"`
+	Secrets       SecretsConfig                     `mapstructure:"secrets"`
+	RestartPolicy RestartPolicyConfig               `mapstructure:"restart_policy"`
+	Timing        TimingConfig                      `mapstructure:"timing"`
 	Debug         struct {
 		Enable bool `mapstructure:"enable"`
 	} `mapstructure:"debug"`
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/policies"
+)
+
+// livenessTolerance is how many heartbeat periods may pass without the heartbeat routine
+// ticking before the agent is reported as not alive
+const livenessTolerance = 3
+
+type healthResponse struct {
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	LastHeartbeat time.Time `json:"last_heartbeat,omitempty"`
+}
+
+type backendReadiness struct {
+	Ready  bool   `json:"ready"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type policyReadiness struct {
+	Name    string `json:"name"`
+	Backend string `json:"backend"`
+	State   string `json:"state"`
+	Error   string `json:"error,omitempty"`
+}
+
+type readinessResponse struct {
+	Ready    bool                        `json:"ready"`
+	Backends map[string]backendReadiness `json:"backends"`
+	// Policies only lists the policies preventing the agent from being ready
+	Policies map[string]policyReadiness `json:"policies,omitempty"`
+	Error    string                     `json:"error,omitempty"`
+}
+
+// markAlive records that the heartbeat routine is still ticking
+func (a *orbAgent) markAlive(t time.Time) {
+	a.lastAlive.Store(t.UnixNano())
+}
+
+func (a *orbAgent) liveness() healthResponse {
+	if a.asyncContext == nil || a.asyncContext.Err() != nil {
+		return healthResponse{Status: "down", Error: "agent is not running"}
+	}
+	last := a.lastAlive.Load()
+	if last == 0 {
+		return healthResponse{Status: "down", Error: "heartbeat routine not started"}
+	}
+	lastHeartbeat := time.Unix(0, last)
+	if time.Since(lastHeartbeat) > livenessTolerance*a.config.OrbAgent.Timing.Heartbeat() {
+		return healthResponse{Status: "down", Error: "heartbeat routine stalled", LastHeartbeat: lastHeartbeat}
+	}
+	return healthResponse{Status: "ok", LastHeartbeat: lastHeartbeat}
+}
+
+func (a *orbAgent) readiness() readinessResponse {
+	resp := readinessResponse{Ready: true, Backends: make(map[string]backendReadiness, len(a.backends))}
+	for name, be := range a.backends {
+		status, errMsg, err := be.GetRunningStatus()
+		br := backendReadiness{
+			Ready:  status == backend.Running || status == backend.Waiting,
+			Status: status.String(),
+			Error:  errMsg,
+		}
+		if err != nil {
+			br.Error = err.Error()
+		}
+		if !br.Ready {
+			resp.Ready = false
+		}
+		resp.Backends[name] = br
+	}
+
+	pdata, err := a.policyManager.GetPolicyState()
+	if err != nil {
+		resp.Ready = false
+		resp.Error = err.Error()
+		return resp
+	}
+	for _, pd := range pdata {
+		if pd.State == policies.Running {
+			continue
+		}
+		resp.Ready = false
+		if resp.Policies == nil {
+			resp.Policies = make(map[string]policyReadiness)
+		}
+		resp.Policies[pd.ID] = policyReadiness{
+			Name:    pd.Name,
+			Backend: pd.Backend,
+			State:   pd.State.String(),
+			Error:   pd.BackendErr,
+		}
+	}
+	return resp
+}
+
+func (a *orbAgent) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	resp := a.liveness()
+	status := http.StatusOK
+	if resp.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	a.writeJSON(w, status, resp)
+}
+
+func (a *orbAgent) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	resp := a.readiness()
+	status := http.StatusOK
+	if !resp.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	a.writeJSON(w, status, resp)
+}
@@ -10,15 +10,10 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/metrics"
 	"github.com/netboxlabs/orb-agent/agent/policies"
 )
 
-// HeartbeatFreq how often to heartbeat
-const HeartbeatFreq = 50 * time.Second
-
-// RestartTime minimum time to wait between restarts
-const RestartTime = 5 * time.Minute
-
 func (a *orbAgent) sendSingleHeartbeat(ctx context.Context, t time.Time, agentsState fleet.State) {
 	if a.heartbeatsTopic == "" {
 		a.logger.Debug("heartbeat topic not yet set, skipping")
@@ -35,42 +30,45 @@ func (a *orbAgent) sendSingleHeartbeat(ctx context.Context, t time.Time, agentsS
 		}
 		besi := fleet.BackendStateInfo{}
 		backendStatus, errMsg, err := be.GetRunningStatus()
-		a.backendState[name].Status = backendStatus
-		besi.State = backendStatus.String()
-		if backendStatus != backend.Running {
-			a.logger.Error("backend not ready", zap.String("backend", name), zap.String("status", backendStatus.String()), zap.String("errMsg", errMsg), zap.Error(err))
+		a.updateBackendState(name, func(state *backend.State) {
+			state.Status = backendStatus
+			if backendStatus == backend.Running {
+				return
+			}
 			if err != nil {
-				a.backendState[name].LastError = fmt.Sprintf("failed to retrieve backend status: %v", err)
+				state.LastError = fmt.Sprintf("failed to retrieve backend status: %v", err)
 			} else if errMsg != "" {
-				a.backendState[name].LastError = errMsg
+				state.LastError = errMsg
 			}
+		})
+		besi.State = backendStatus.String()
+		if backendStatus != backend.Running {
+			a.logger.Error("backend not ready", zap.String("backend", name), zap.String("status", backendStatus.String()), zap.String("errMsg", errMsg), zap.Error(err))
 			// status is not running so we have a current error
-			besi.Error = a.backendState[name].LastError
-			if time.Since(be.GetStartTime()) >= RestartTime {
-				a.logger.Info("attempting backend restart due to failed status during heartbeat")
-				ctx = a.configManager.GetContext(ctx)
-				err := a.RestartBackend(ctx, name, "failed during heartbeat")
-				if err != nil {
-					a.logger.Error("failed to restart backend", zap.Error(err), zap.String("backend", name))
-				}
+			state, _ := a.getBackendState(name)
+			besi.Error = state.LastError
+			restartDelay := a.config.OrbAgent.Timing.ForBackend(name).RestartDelay
+			if time.Since(be.GetStartTime()) >= restartDelay {
+				a.watchdog.notify(backendEvent{name: name, reason: "failed during heartbeat", failed: true})
 			} else {
-				a.logger.Info("waiting to attempt backend restart due to failed status", zap.Duration("remaining_secs", RestartTime-(time.Since(be.GetStartTime()))))
+				a.logger.Info("waiting to attempt backend restart due to failed status", zap.Duration("remaining_secs", restartDelay-(time.Since(be.GetStartTime()))))
 			}
 		} else {
 			// status is Running so no current error
 			besi.Error = ""
 		}
-		if a.backendState[name].LastError != "" {
-			besi.LastError = a.backendState[name].LastError
+		state, _ := a.getBackendState(name)
+		if state.LastError != "" {
+			besi.LastError = state.LastError
 		}
-		if !a.backendState[name].LastRestartTS.IsZero() {
-			besi.LastRestartTS = a.backendState[name].LastRestartTS
+		if !state.LastRestartTS.IsZero() {
+			besi.LastRestartTS = state.LastRestartTS
 		}
-		if a.backendState[name].RestartCount > 0 {
-			besi.RestartCount = a.backendState[name].RestartCount
+		if state.RestartCount > 0 {
+			besi.RestartCount = state.RestartCount
 		}
-		if a.backendState[name].LastRestartReason != "" {
-			besi.LastRestartReason = a.backendState[name].LastRestartReason
+		if state.LastRestartReason != "" {
+			besi.LastRestartReason = state.LastRestartReason
 		}
 		bes[name] = besi
 	}
@@ -85,7 +83,7 @@ func (a *orbAgent) sendSingleHeartbeat(ctx context.Context, t time.Time, agentsS
 				pstate = pd.State.String()
 			}
 			// but if the policy backend is not running, policy isn't either
-			if bestate, ok := a.backendState[pd.Backend]; ok && bestate.Status != backend.Running {
+			if bestate, ok := a.getBackendState(pd.Backend); ok && bestate.Status != backend.Running {
 				pstate = policies.Unknown.String()
 				pd.BackendErr = "backend is unreachable"
 			}
@@ -105,12 +103,14 @@ func (a *orbAgent) sendSingleHeartbeat(ctx context.Context, t time.Time, agentsS
 	}
 
 	ag := make(map[string]fleet.GroupStateInfo)
+	a.groupsMutex.RLock()
 	for id, groupInfo := range a.groupsInfos {
 		ag[id] = fleet.GroupStateInfo{
 			GroupName:    groupInfo.Name,
 			GroupChannel: groupInfo.ChannelID,
 		}
 	}
+	a.groupsMutex.RUnlock()
 
 	hbData := fleet.Heartbeat{
 		SchemaVersion: fleet.CurrentHeartbeatSchemaVersion,
@@ -127,25 +127,40 @@ func (a *orbAgent) sendSingleHeartbeat(ctx context.Context, t time.Time, agentsS
 		return
 	}
 
-	if token := a.client.Publish(a.heartbeatsTopic, 1, false, body); token.Wait() && token.Error() != nil {
+	token := a.client.Publish(a.heartbeatsTopic, 1, false, body)
+	token.Wait()
+	metrics.ObserveHeartbeat(token.Error())
+	if token.Error() != nil {
 		a.logger.Error("error sending heartbeat", zap.Error(token.Error()))
 	}
 }
 
-func (a *orbAgent) sendHeartbeats(ctx context.Context, cancelFunc context.CancelFunc) {
+func (a *orbAgent) sendHeartbeats(ctx context.Context, cancelFunc context.CancelFunc, ticker *time.Ticker, done chan struct{}) {
 	a.logger.Debug("start heartbeats routine", zap.Any("routine", ctx.Value(routineKey)))
+	a.markAlive(time.Now())
 	a.sendSingleHeartbeat(ctx, time.Now(), fleet.Online)
 	defer func() {
+		ticker.Stop()
 		cancelFunc()
+		close(done)
 	}()
 	for {
 		select {
 		case <-ctx.Done():
 			a.logger.Debug("context done, stopping heartbeats routine")
-			a.sendSingleHeartbeat(ctx, time.Now(), fleet.Offline)
-			a.heartbeatCtx = nil
+			// on stop, the agent sends the offline heartbeat itself once its backends are stopped
+			if !a.stopping.Load() {
+				a.sendSingleHeartbeat(ctx, time.Now(), fleet.Offline)
+			}
+			// a restart may already have started a new heartbeat routine
+			a.heartbeatMutex.Lock()
+			if a.heartbeatCtx == ctx {
+				a.heartbeatCtx = nil
+			}
+			a.heartbeatMutex.Unlock()
 			return
-		case t := <-a.hbTicker.C:
+		case t := <-ticker.C:
+			a.markAlive(t)
 			a.sendSingleHeartbeat(ctx, t, fleet.Online)
 		}
 	}
@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/google/uuid"
+	"github.com/orb-community/orb/fleet"
+
+	"github.com/netboxlabs/orb-agent/agent/policies"
+)
+
+// localPolicyIDField is the optional policy field used to pin the ID of a policy from the config file
+const localPolicyIDField = "id"
+
+// localPolicyNamespace scopes the IDs derived for policies defined in the config file
+var localPolicyNamespace = uuid.MustParse("5b0d4a4e-8b8e-4e5c-9d3c-6f1b2c3d4e5f")
+
+// localPolicyID returns the explicit `id` field of a policy from the config file, or an ID derived
+// from its backend and name so the policy keeps the same identity across restarts and reloads
+func localPolicyID(beName string, pName string, data interface{}) string {
+	if m, ok := data.(map[string]interface{}); ok {
+		if id, ok := m[localPolicyIDField].(string); ok && id != "" {
+			return id
+		}
+	}
+	return uuid.NewSHA1(localPolicyNamespace, []byte(beName+"/"+pName)).String()
+}
+
+// localPolicyData strips the fields only meaningful to the agent before the policy is sent to a backend
+func localPolicyData(data interface{}) interface{} {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return data
+	}
+	if _, ok := m[localPolicyIDField]; !ok {
+		return data
+	}
+	stripped := make(map[string]interface{}, len(m)-1)
+	for k, v := range m {
+		if k != localPolicyIDField {
+			stripped[k] = v
+		}
+	}
+	return stripped
+}
+
+// localPolicyHash fingerprints the content of a policy from the config file
+func localPolicyHash(data interface{}) uint32 {
+	h := fnv.New32a()
+	// JSON sorts map keys and encodes numbers the same whatever their type, so equal policies always hash the
+	// same, including once read back from the policy repository
+	if err := json.NewEncoder(h).Encode(data); err != nil {
+		_, _ = fmt.Fprintf(h, "%#v", data)
+	}
+	return h.Sum32()
+}
+
+// localPolicyVersion returns the version to apply a policy from the config file with: the current version while
+// its content is unchanged, the next one once it changed, so that the backends see the change as an update
+func localPolicyVersion(current policies.PolicyData, data interface{}) int32 {
+	if localPolicyHash(current.Data) == localPolicyHash(data) {
+		return current.Version
+	}
+	return current.Version + 1
+}
+
+func localPolicyPayload(beName string, pName string, data interface{}) fleet.AgentPolicyRPCPayload {
+	id := localPolicyID(beName, pName, data)
+	return fleet.AgentPolicyRPCPayload{
+		Action:    "manage",
+		ID:        id,
+		DatasetID: id,
+		Name:      pName,
+		Backend:   beName,
+		Version:   1,
+		Data:      localPolicyData(data),
+	}
+}
+
+// applyLocalPolicy applies a policy defined in the config file, as an update of the policy with the same ID
+// when there is one
+func (a *orbAgent) applyLocalPolicy(beName string, pName string, data interface{}) {
+	payload := localPolicyPayload(beName, pName, data)
+	if current, err := a.policyManager.GetRepo().Get(payload.ID); err == nil {
+		payload.Version = localPolicyVersion(current, payload.Data)
+	}
+	a.policyManager.ManagePolicy(payload)
+}
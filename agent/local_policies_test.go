@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func Test_localPolicyPayload(t *testing.T) {
+	data := map[string]interface{}{"kind": "collection", "input": map[string]interface{}{"tap": "default_pcap"}}
+
+	payload := localPolicyPayload("pktvisor", "policy_1", data)
+	assert.Equal(t, "manage", payload.Action)
+	assert.NotEmpty(t, payload.ID)
+	assert.Equal(t, payload.ID, payload.DatasetID)
+	assert.Equal(t, int32(1), payload.Version)
+
+	// same backend, name and content always give the same identity
+	again := localPolicyPayload("pktvisor", "policy_1", map[string]interface{}{"input": map[string]interface{}{"tap": "default_pcap"}, "kind": "collection"})
+	assert.Equal(t, payload.ID, again.ID)
+
+	assert.NotEqual(t, payload.ID, localPolicyPayload("otel", "policy_1", data).ID)
+	assert.NotEqual(t, payload.ID, localPolicyPayload("pktvisor", "policy_2", data).ID)
+
+	changed := localPolicyPayload("pktvisor", "policy_1", map[string]interface{}{"kind": "collection"})
+	assert.Equal(t, payload.ID, changed.ID)
+}
+
+func Test_localPolicyPayload_explicitID(t *testing.T) {
+	data := map[string]interface{}{"id": "my-policy", "kind": "collection"}
+
+	payload := localPolicyPayload("pktvisor", "policy_1", data)
+	assert.Equal(t, "my-policy", payload.ID)
+	assert.Equal(t, map[string]interface{}{"kind": "collection"}, payload.Data)
+	assert.Contains(t, data, "id", "the config must not be modified")
+}
+
+func Test_orbAgent_applyLocalPolicy_version(t *testing.T) {
+	pm := newFakePolicyManager(t)
+	a := orbAgent{logger: zap.NewNop(), policyManager: pm}
+	id := localPolicyID("pktvisor", "policy_1", nil)
+	version := func() int32 {
+		p, err := pm.repo.Get(id)
+		require.NoError(t, err)
+		return p.Version
+	}
+
+	a.applyLocalPolicy("pktvisor", "policy_1", map[string]interface{}{"kind": "collection", "timeout": 5})
+	assert.Equal(t, int32(1), version())
+
+	// the repository may hand the policy back with other types, e.g. after a JSON round trip
+	stored, err := pm.repo.Get(id)
+	require.NoError(t, err)
+	stored.Data = map[string]interface{}{"timeout": float64(5), "kind": "collection"}
+	require.NoError(t, pm.repo.Update(stored))
+	a.applyLocalPolicy("pktvisor", "policy_1", map[string]interface{}{"kind": "collection", "timeout": 5})
+	assert.Equal(t, int32(1), version(), "unchanged content keeps its version")
+
+	// whatever its content hash, a change always gets a higher version
+	for i, data := range []map[string]interface{}{{"kind": "collection", "timeout": 10}, {"kind": "collection"}, {"kind": "collection", "timeout": 5}} {
+		a.applyLocalPolicy("pktvisor", "policy_1", data)
+		assert.Equal(t, int32(i+2), version())
+	}
+	assert.Empty(t, pm.removed, "changes are applied as updates")
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "orb_agent"
+
+// Policy operations
+const (
+	PolicyApply  = "apply"
+	PolicyRemove = "remove"
+)
+
+var (
+	policyOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "policy",
+		Name:      "operation_duration_seconds",
+		Help:      "Time taken by a backend to apply or remove a policy.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "operation"})
+
+	policyOperationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "policy",
+		Name:      "operation_failures_total",
+		Help:      "Number of policy apply or remove operations rejected by a backend.",
+	}, []string{"backend", "operation"})
+
+	heartbeats = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "heartbeats_published_total",
+		Help:      "Number of heartbeats published to the control plane, by result.",
+	}, []string{"result"})
+
+	backendRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of REST requests sent to backend admin APIs.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "method"})
+
+	backendRequestFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "request_failures_total",
+		Help:      "Number of REST requests to backend admin APIs that failed.",
+	}, []string{"backend", "method"})
+)
+
+// Collectors returns the collectors for the metrics recorded through this package
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		policyOperationDuration,
+		policyOperationFailures,
+		heartbeats,
+		backendRequestDuration,
+		backendRequestFailures,
+	}
+}
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// ObservePolicyOperation records the duration and outcome of a policy apply or remove started at start
+func ObservePolicyOperation(backend string, operation string, start time.Time, err error) {
+	policyOperationDuration.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		policyOperationFailures.WithLabelValues(backend, operation).Inc()
+	}
+}
+
+// ObserveHeartbeat records the outcome of a heartbeat publish
+func ObserveHeartbeat(err error) {
+	heartbeats.WithLabelValues(result(err)).Inc()
+}
+
+// ObserveBackendRequest records the duration and outcome of a backend REST request started at start
+func ObserveBackendRequest(backend string, method string, start time.Time, err error) {
+	backendRequestDuration.WithLabelValues(backend, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		backendRequestFailures.WithLabelValues(backend, method).Inc()
+	}
+}
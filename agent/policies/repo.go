@@ -2,10 +2,18 @@ package policies
 
 import (
 	"errors"
+	"sync"
 
 	"go.uber.org/zap"
 )
 
+var (
+	// ErrPolicyNotFound is returned when no policy matches the given ID
+	ErrPolicyNotFound = errors.New("unknown policy ID")
+	// ErrPolicyNameNotFound is returned when no policy matches the given name
+	ErrPolicyNameNotFound = errors.New("policy name not found")
+)
+
 // PolicyRepo is the interface for policy repositories
 type PolicyRepo interface {
 	Exists(policyID string) bool
@@ -13,28 +21,25 @@ type PolicyRepo interface {
 	Remove(policyID string) error
 	Update(data PolicyData) error
 	GetAll() ([]PolicyData, error)
+	GetByBackend(backendName string) ([]PolicyData, error)
 	GetByName(policyName string) (PolicyData, error)
 	EnsureDataset(policyID string, datasetID string) error
 	RemoveDataset(policyID string, datasetID string) (bool, error)
 	EnsureGroupID(policyID string, agentGroupID string) error
+	// Close releases the resources of the repository, it is not used afterwards
+	Close() error
 }
 
 type policyMemRepo struct {
 	logger *zap.Logger
 
+	mu      sync.RWMutex
 	db      map[string]PolicyData
 	nameMap map[string]string
 }
 
 var _ PolicyRepo = (*policyMemRepo)(nil)
 
-func (p policyMemRepo) GetByName(policyName string) (PolicyData, error) {
-	if id, ok := p.nameMap[policyName]; ok {
-		return p.Get(id)
-	}
-	return PolicyData{}, errors.New("policy name not found")
-}
-
 // NewMemRepo creates a new in-memory policy repository
 func NewMemRepo(logger *zap.Logger) (PolicyRepo, error) {
 	r := &policyMemRepo{
@@ -45,23 +50,40 @@ func NewMemRepo(logger *zap.Logger) (PolicyRepo, error) {
 	return r, nil
 }
 
-func (p policyMemRepo) EnsureDataset(policyID string, datasetID string) error {
+func (p *policyMemRepo) GetByName(policyName string) (PolicyData, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if id, ok := p.nameMap[policyName]; ok {
+		if policy, ok := p.db[id]; ok {
+			return policy.clone(), nil
+		}
+	}
+	return PolicyData{}, ErrPolicyNameNotFound
+}
+
+func (p *policyMemRepo) EnsureDataset(policyID string, datasetID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	policy, ok := p.db[policyID]
 	if !ok {
-		return errors.New("unknown policy ID")
+		return ErrPolicyNotFound
+	}
+	if policy.Datasets == nil {
+		policy.Datasets = make(map[string]bool)
 	}
 	policy.Datasets[datasetID] = true
+	p.db[policyID] = policy
 	return nil
 }
 
-func (p policyMemRepo) RemoveDataset(policyID string, datasetID string) (bool, error) {
+func (p *policyMemRepo) RemoveDataset(policyID string, datasetID string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	policy, ok := p.db[policyID]
 	if !ok {
-		return false, errors.New("unknown policy ID")
-	}
-	if ok := policy.Datasets[datasetID]; ok {
-		delete(policy.Datasets, datasetID)
+		return false, ErrPolicyNotFound
 	}
+	delete(policy.Datasets, datasetID)
 	// If after remove the policy it doesn't have others datasets,
 	// we can remove the policy from the agent
 	if len(policy.Datasets) > 0 {
@@ -70,58 +92,87 @@ func (p policyMemRepo) RemoveDataset(policyID string, datasetID string) (bool, e
 	return true, nil
 }
 
-func (p policyMemRepo) Exists(policyID string) bool {
+func (p *policyMemRepo) Exists(policyID string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	_, ok := p.db[policyID]
 	return ok
 }
 
-func (p policyMemRepo) Get(policyID string) (PolicyData, error) {
+func (p *policyMemRepo) Get(policyID string) (PolicyData, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	policy, ok := p.db[policyID]
 	if !ok {
-		return PolicyData{}, errors.New("unknown policy ID")
+		return PolicyData{}, ErrPolicyNotFound
 	}
-	return policy, nil
+	return policy.clone(), nil
 }
 
-func (p policyMemRepo) Remove(policyID string) error {
-	v, err := p.Get(policyID)
-	if err != nil {
-		return err
+func (p *policyMemRepo) Remove(policyID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, ok := p.db[policyID]
+	if !ok {
+		return ErrPolicyNotFound
 	}
 	delete(p.nameMap, v.Name)
 	delete(p.db, policyID)
 	return nil
 }
 
-func (p policyMemRepo) Update(data PolicyData) error {
+func (p *policyMemRepo) Update(data PolicyData) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	policy, ok := p.db[data.ID]
 	if ok {
 		// existed, clear old map
 		delete(p.nameMap, policy.Name)
 	}
-	p.db[data.ID] = data
+	// store a copy so later changes to the caller's maps don't leak into the repo
+	p.db[data.ID] = data.clone()
 	p.nameMap[data.Name] = data.ID
 	return nil
 }
 
-func (p policyMemRepo) GetAll() (ret []PolicyData, err error) {
-	ret = make([]PolicyData, len(p.db))
-	i := 0
+func (p *policyMemRepo) GetAll() (ret []PolicyData, err error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ret = make([]PolicyData, 0, len(p.db))
+	for _, v := range p.db {
+		ret = append(ret, v.clone())
+	}
+	return ret, nil
+}
+
+func (p *policyMemRepo) GetByBackend(backendName string) (ret []PolicyData, err error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ret = make([]PolicyData, 0)
 	for _, v := range p.db {
-		ret[i] = v
-		i++
+		if v.Backend == backendName {
+			ret = append(ret, v.clone())
+		}
 	}
-	err = nil
-	return ret, err
+	return ret, nil
 }
 
-func (p policyMemRepo) EnsureGroupID(policyID string, agentGroupID string) error {
+func (p *policyMemRepo) EnsureGroupID(policyID string, agentGroupID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	policy, ok := p.db[policyID]
 	if !ok {
-		return errors.New("unknown policy ID")
+		return ErrPolicyNotFound
+	}
+	if policy.GroupIDs == nil {
+		policy.GroupIDs = make(map[string]bool)
 	}
 	policy.GroupIDs[agentGroupID] = true
+	p.db[policyID] = policy
 	return nil
 }
 
-var _ PolicyRepo = (*policyMemRepo)(nil)
+// Close does nothing, the policies are only kept in memory
+func (p *policyMemRepo) Close() error {
+	return nil
+}
@@ -0,0 +1,129 @@
+package policies_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/policies"
+)
+
+func TestMemRepo_ReturnsCopies(t *testing.T) {
+	repo, err := policies.NewMemRepo(zap.NewNop())
+	require.NoError(t, err)
+
+	datasets := map[string]bool{"dataset-1": true}
+	require.NoError(t, repo.Update(policies.PolicyData{ID: "policy-1", Name: "default", Datasets: datasets}))
+
+	// mutating the caller's map after Update must not change the stored policy
+	datasets["dataset-2"] = true
+
+	got, err := repo.Get("policy-1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"dataset-1": true}, got.Datasets)
+
+	// mutating a returned copy must not change the stored policy either
+	got.Datasets["dataset-3"] = true
+	all, err := repo.GetAll()
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, map[string]bool{"dataset-1": true}, all[0].Datasets)
+
+	require.NoError(t, repo.EnsureGroupID("policy-1", "group-1"))
+	got, err = repo.GetByName("default")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"group-1": true}, got.GroupIDs)
+
+	empty, err := repo.RemoveDataset("policy-1", "dataset-1")
+	require.NoError(t, err)
+	assert.True(t, empty)
+	got, err = repo.Get("policy-1")
+	require.NoError(t, err)
+	assert.Empty(t, got.Datasets)
+}
+
+func TestMemRepo_ReturnsDataCopies(t *testing.T) {
+	repo, err := policies.NewMemRepo(zap.NewNop())
+	require.NoError(t, err)
+
+	data := map[string]interface{}{"scope": []interface{}{map[string]interface{}{"hostname": "h1"}}}
+	require.NoError(t, repo.Update(policies.PolicyData{ID: "policy-1", Name: "default", Data: data}))
+	data["kind"] = "collection"
+	data["scope"].([]interface{})[0].(map[string]interface{})["hostname"] = "h2"
+
+	got, err := repo.Get("policy-1")
+	require.NoError(t, err)
+	want := map[string]interface{}{"scope": []interface{}{map[string]interface{}{"hostname": "h1"}}}
+	assert.Equal(t, want, got.Data)
+
+	got.Data.(map[string]interface{})["scope"].([]interface{})[0].(map[string]interface{})["hostname"] = "h3"
+	got, err = repo.Get("policy-1")
+	require.NoError(t, err)
+	assert.Equal(t, want, got.Data)
+}
+
+func TestMemRepo_GetByBackend(t *testing.T) {
+	repo, err := policies.NewMemRepo(zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, repo.Update(policies.PolicyData{ID: "policy-1", Name: "pv", Backend: "pktvisor"}))
+	require.NoError(t, repo.Update(policies.PolicyData{ID: "policy-2", Name: "otel", Backend: "otel"}))
+
+	got, err := repo.GetByBackend("pktvisor")
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "policy-1", got[0].ID)
+	got, err = repo.GetByBackend("device_discovery")
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestMemRepo_ConcurrentAccess(t *testing.T) {
+	repo, err := policies.NewMemRepo(zap.NewNop())
+	require.NoError(t, err)
+
+	const workers = 8
+	const iterations = 100
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(3)
+		// manage: create and update policies
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				id := fmt.Sprintf("policy-%d-%d", w, i%10)
+				_ = repo.Update(policies.PolicyData{ID: id, Name: id, Datasets: map[string]bool{"d": true}})
+				_ = repo.EnsureDataset(id, fmt.Sprintf("dataset-%d", i))
+				_ = repo.EnsureGroupID(id, fmt.Sprintf("group-%d", i))
+			}
+		}(w)
+		// remove: datasets and policies
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				id := fmt.Sprintf("policy-%d-%d", w, i%10)
+				_, _ = repo.RemoveDataset(id, "d")
+				if i%3 == 0 {
+					_ = repo.Remove(id)
+				}
+			}
+		}(w)
+		// heartbeat: read the full state
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				all, err := repo.GetAll()
+				assert.NoError(t, err)
+				for _, p := range all {
+					_ = p.GetDatasetIDs()
+					for range p.GroupIDs {
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
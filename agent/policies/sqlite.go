@@ -0,0 +1,341 @@
+package policies
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	migrate "github.com/rubenv/sql-migrate"
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite" // registers the pure Go "sqlite" database/sql driver
+)
+
+type policySqliteRepo struct {
+	logger *zap.Logger
+	db     *sqlx.DB
+}
+
+var _ PolicyRepo = (*policySqliteRepo)(nil)
+
+type policyRow struct {
+	ID              string         `db:"id"`
+	Name            string         `db:"name"`
+	Backend         string         `db:"backend"`
+	Version         int32          `db:"version"`
+	Data            sql.NullString `db:"data"`
+	State           PolicyState    `db:"state"`
+	BackendErr      string         `db:"backend_err"`
+	Datasets        string         `db:"datasets"`
+	GroupIDs        string         `db:"group_ids"`
+	LastScrapeBytes int64          `db:"last_scrape_bytes"`
+	LastScrapeTS    int64          `db:"last_scrape_ts"`
+	Previous        sql.NullString `db:"previous_policy_data"`
+}
+
+// NewSqliteRepo creates a policy repository persisted in the given SQLite database file
+func NewSqliteRepo(logger *zap.Logger, file string) (PolicyRepo, error) {
+	if file == "" {
+		return nil, errors.New("sqlite policy repository requires a database file")
+	}
+	db, err := sqlx.Connect("sqlite", file)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only supports a single writer, serialize access instead of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	r := &policySqliteRepo{logger: logger, db: db}
+	if err := r.migrateDB(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	logger.Info("using persistent policy repository", zap.String("filename", file))
+	return r, nil
+}
+
+func (p *policySqliteRepo) migrateDB() error {
+	migrations := &migrate.MemoryMigrationSource{
+		Migrations: []*migrate.Migration{
+			{
+				Id: "agent_policies_1",
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS agent_policies (
+						id TEXT PRIMARY KEY,
+						name TEXT NOT NULL,
+						backend TEXT NOT NULL,
+						version INTEGER NOT NULL,
+						data TEXT,
+						state TEXT NOT NULL,
+						backend_err TEXT NOT NULL,
+						datasets TEXT NOT NULL,
+						group_ids TEXT NOT NULL,
+						last_scrape_bytes INTEGER NOT NULL,
+						last_scrape_ts INTEGER NOT NULL
+						)`,
+					`CREATE INDEX IF NOT EXISTS agent_policies_name ON agent_policies (name)`,
+				},
+				Down: []string{
+					"DROP TABLE agent_policies",
+				},
+			},
+			{
+				Id: "agent_policies_2",
+				Up: []string{
+					`CREATE INDEX IF NOT EXISTS agent_policies_backend ON agent_policies (backend)`,
+				},
+				Down: []string{
+					"DROP INDEX agent_policies_backend",
+				},
+			},
+			{
+				Id: "agent_policies_3",
+				Up: []string{
+					`ALTER TABLE agent_policies ADD COLUMN previous_policy_data TEXT`,
+				},
+				Down: []string{
+					"ALTER TABLE agent_policies DROP COLUMN previous_policy_data",
+				},
+			},
+		},
+	}
+
+	_, err := migrate.Exec(p.db.DB, "sqlite3", migrations, migrate.Up)
+
+	return err
+}
+
+func encodeSet(set map[string]bool) (string, error) {
+	keys := make([]string, 0, len(set))
+	for k, v := range set {
+		if v {
+			keys = append(keys, k)
+		}
+	}
+	b, err := json.Marshal(keys)
+	return string(b), err
+}
+
+func decodeSet(value string) (map[string]bool, error) {
+	var keys []string
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[k] = true
+	}
+	return set, nil
+}
+
+func toRow(data PolicyData) (policyRow, error) {
+	row := policyRow{
+		ID:              data.ID,
+		Name:            data.Name,
+		Backend:         data.Backend,
+		Version:         data.Version,
+		State:           data.State,
+		BackendErr:      data.BackendErr,
+		LastScrapeBytes: data.LastScrapeBytes,
+	}
+	if !data.LastScrapeTS.IsZero() {
+		row.LastScrapeTS = data.LastScrapeTS.UnixNano()
+	}
+	if data.Data != nil {
+		b, err := json.Marshal(data.Data)
+		if err != nil {
+			return row, fmt.Errorf("failed to encode policy data: %w", err)
+		}
+		row.Data = sql.NullString{String: string(b), Valid: true}
+	}
+	if data.PreviousPolicyData != nil {
+		b, err := json.Marshal(data.PreviousPolicyData)
+		if err != nil {
+			return row, fmt.Errorf("failed to encode previous policy data: %w", err)
+		}
+		row.Previous = sql.NullString{String: string(b), Valid: true}
+	}
+	var err error
+	if row.Datasets, err = encodeSet(data.Datasets); err != nil {
+		return row, err
+	}
+	if row.GroupIDs, err = encodeSet(data.GroupIDs); err != nil {
+		return row, err
+	}
+	return row, nil
+}
+
+func (r policyRow) toPolicyData() (PolicyData, error) {
+	data := PolicyData{
+		ID:              r.ID,
+		Name:            r.Name,
+		Backend:         r.Backend,
+		Version:         r.Version,
+		State:           r.State,
+		BackendErr:      r.BackendErr,
+		LastScrapeBytes: r.LastScrapeBytes,
+	}
+	if r.LastScrapeTS != 0 {
+		data.LastScrapeTS = time.Unix(0, r.LastScrapeTS)
+	}
+	if r.Data.Valid {
+		if err := json.Unmarshal([]byte(r.Data.String), &data.Data); err != nil {
+			return data, fmt.Errorf("failed to decode policy data: %w", err)
+		}
+	}
+	if r.Previous.Valid {
+		if err := json.Unmarshal([]byte(r.Previous.String), &data.PreviousPolicyData); err != nil {
+			return data, fmt.Errorf("failed to decode previous policy data: %w", err)
+		}
+	}
+	var err error
+	if data.Datasets, err = decodeSet(r.Datasets); err != nil {
+		return data, err
+	}
+	if data.GroupIDs, err = decodeSet(r.GroupIDs); err != nil {
+		return data, err
+	}
+	return data, nil
+}
+
+const selectPolicy = `SELECT id, name, backend, version, data, state, backend_err, datasets, group_ids, last_scrape_bytes, last_scrape_ts, previous_policy_data FROM agent_policies`
+
+func (p *policySqliteRepo) get(q sqlx.Queryer, query string, args ...interface{}) (PolicyData, error) {
+	var row policyRow
+	if err := sqlx.Get(q, &row, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PolicyData{}, ErrPolicyNotFound
+		}
+		return PolicyData{}, err
+	}
+	return row.toPolicyData()
+}
+
+func (p *policySqliteRepo) update(e sqlx.Ext, data PolicyData) error {
+	row, err := toRow(data)
+	if err != nil {
+		return err
+	}
+	_, err = sqlx.NamedExec(e, `INSERT OR REPLACE INTO agent_policies
+		(id, name, backend, version, data, state, backend_err, datasets, group_ids, last_scrape_bytes, last_scrape_ts, previous_policy_data)
+		VALUES (:id, :name, :backend, :version, :data, :state, :backend_err, :datasets, :group_ids, :last_scrape_bytes, :last_scrape_ts, :previous_policy_data)`, row)
+	return err
+}
+
+// modify runs a read-modify-write of a single policy inside a transaction
+func (p *policySqliteRepo) modify(policyID string, fn func(policy *PolicyData) error) error {
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	policy, err := p.get(tx, selectPolicy+` WHERE id = $1`, policyID)
+	if err != nil {
+		return err
+	}
+	if err := fn(&policy); err != nil {
+		return err
+	}
+	if err := p.update(tx, policy); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *policySqliteRepo) Exists(policyID string) bool {
+	var count int
+	if err := p.db.Get(&count, `SELECT COUNT(*) FROM agent_policies WHERE id = $1`, policyID); err != nil {
+		p.logger.Error("failed to check policy existence", zap.String("policy_id", policyID), zap.Error(err))
+		return false
+	}
+	return count > 0
+}
+
+func (p *policySqliteRepo) Get(policyID string) (PolicyData, error) {
+	return p.get(p.db, selectPolicy+` WHERE id = $1`, policyID)
+}
+
+func (p *policySqliteRepo) GetByName(policyName string) (PolicyData, error) {
+	policy, err := p.get(p.db, selectPolicy+` WHERE name = $1 LIMIT 1`, policyName)
+	if errors.Is(err, ErrPolicyNotFound) {
+		return PolicyData{}, ErrPolicyNameNotFound
+	}
+	return policy, err
+}
+
+func (p *policySqliteRepo) Remove(policyID string) error {
+	res, err := p.db.Exec(`DELETE FROM agent_policies WHERE id = $1`, policyID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrPolicyNotFound
+	}
+	return nil
+}
+
+func (p *policySqliteRepo) Update(data PolicyData) error {
+	return p.update(p.db, data)
+}
+
+func (p *policySqliteRepo) GetAll() ([]PolicyData, error) {
+	return p.list(selectPolicy)
+}
+
+func (p *policySqliteRepo) GetByBackend(backendName string) ([]PolicyData, error) {
+	return p.list(selectPolicy+` WHERE backend = $1`, backendName)
+}
+
+func (p *policySqliteRepo) list(query string, args ...interface{}) ([]PolicyData, error) {
+	var rows []policyRow
+	if err := p.db.Select(&rows, query, args...); err != nil {
+		return nil, err
+	}
+	ret := make([]PolicyData, 0, len(rows))
+	for _, row := range rows {
+		data, err := row.toPolicyData()
+		if err != nil {
+			p.logger.Error("failed to decode stored policy, skipping", zap.String("policy_id", row.ID), zap.Error(err))
+			continue
+		}
+		ret = append(ret, data)
+	}
+	return ret, nil
+}
+
+func (p *policySqliteRepo) EnsureDataset(policyID string, datasetID string) error {
+	return p.modify(policyID, func(policy *PolicyData) error {
+		policy.Datasets[datasetID] = true
+		return nil
+	})
+}
+
+func (p *policySqliteRepo) RemoveDataset(policyID string, datasetID string) (bool, error) {
+	var empty bool
+	err := p.modify(policyID, func(policy *PolicyData) error {
+		delete(policy.Datasets, datasetID)
+		// If after remove the policy it doesn't have others datasets,
+		// we can remove the policy from the agent
+		empty = len(policy.Datasets) == 0
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return empty, nil
+}
+
+func (p *policySqliteRepo) EnsureGroupID(policyID string, agentGroupID string) error {
+	return p.modify(policyID, func(policy *PolicyData) error {
+		policy.GroupIDs[agentGroupID] = true
+		return nil
+	})
+}
+
+// Close closes the database
+func (p *policySqliteRepo) Close() error {
+	return p.db.Close()
+}
@@ -0,0 +1,93 @@
+package policies_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/policies"
+)
+
+func TestSqliteRepo(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policies.db")
+	repo, err := policies.NewSqliteRepo(zap.NewNop(), file)
+	require.NoError(t, err)
+
+	policy := policies.PolicyData{
+		ID:                 "policy-1",
+		Name:               "default",
+		Backend:            "pktvisor",
+		Version:            2,
+		Data:               map[string]interface{}{"kind": "collection", "input": map[string]interface{}{"tap": "default_pcap"}},
+		State:              policies.Running,
+		Datasets:           map[string]bool{"dataset-1": true},
+		GroupIDs:           map[string]bool{"group-1": true},
+		LastScrapeBytes:    42,
+		LastScrapeTS:       time.Unix(1700000000, 0),
+		PreviousPolicyData: &policies.PolicyData{Name: "previous"},
+	}
+	require.NoError(t, repo.Update(policy))
+	assert.True(t, repo.Exists("policy-1"))
+	assert.False(t, repo.Exists("policy-2"))
+
+	require.NoError(t, repo.EnsureDataset("policy-1", "dataset-2"))
+	require.NoError(t, repo.EnsureGroupID("policy-1", "group-2"))
+
+	// reopening the database restores everything that was stored
+	require.NoError(t, repo.Close())
+	repo, err = policies.NewSqliteRepo(zap.NewNop(), file)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, repo.Close())
+	}()
+
+	got, err := repo.Get("policy-1")
+	require.NoError(t, err)
+	assert.Equal(t, policy.Name, got.Name)
+	assert.Equal(t, policy.Backend, got.Backend)
+	assert.Equal(t, policy.Version, got.Version)
+	assert.Equal(t, policy.Data, got.Data)
+	assert.Equal(t, policies.Running, got.State)
+	assert.Equal(t, map[string]bool{"dataset-1": true, "dataset-2": true}, got.Datasets)
+	assert.Equal(t, map[string]bool{"group-1": true, "group-2": true}, got.GroupIDs)
+	assert.Equal(t, int64(42), got.LastScrapeBytes)
+	assert.True(t, policy.LastScrapeTS.Equal(got.LastScrapeTS))
+	require.NotNil(t, got.PreviousPolicyData)
+	assert.Equal(t, "previous", got.PreviousPolicyData.Name)
+
+	byName, err := repo.GetByName("default")
+	require.NoError(t, err)
+	assert.Equal(t, "policy-1", byName.ID)
+	_, err = repo.GetByName("unknown")
+	assert.ErrorIs(t, err, policies.ErrPolicyNameNotFound)
+
+	empty, err := repo.RemoveDataset("policy-1", "dataset-1")
+	require.NoError(t, err)
+	assert.False(t, empty)
+	empty, err = repo.RemoveDataset("policy-1", "dataset-2")
+	require.NoError(t, err)
+	assert.True(t, empty)
+
+	all, err := repo.GetAll()
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+
+	require.NoError(t, repo.Update(policies.PolicyData{ID: "policy-2", Name: "otel", Backend: "otel", State: policies.Running}))
+	byBackend, err := repo.GetByBackend("pktvisor")
+	require.NoError(t, err)
+	require.Len(t, byBackend, 1)
+	assert.Equal(t, "policy-1", byBackend[0].ID)
+	byBackend, err = repo.GetByBackend("device_discovery")
+	require.NoError(t, err)
+	assert.Empty(t, byBackend)
+	require.NoError(t, repo.Remove("policy-2"))
+
+	require.NoError(t, repo.Remove("policy-1"))
+	assert.ErrorIs(t, repo.Remove("policy-1"), policies.ErrPolicyNotFound)
+	_, err = repo.Get("policy-1")
+	assert.ErrorIs(t, err, policies.ErrPolicyNotFound)
+}
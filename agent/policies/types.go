@@ -2,6 +2,9 @@ package policies
 
 import (
 	"database/sql/driver"
+	"fmt"
+	"maps"
+	"slices"
 	"time"
 )
 
@@ -33,6 +36,49 @@ func (d *PolicyData) GetDatasetIDs() []string {
 	return keys
 }
 
+// clone returns a copy of the policy that does not share its maps nor the maps and slices of its data
+func (d PolicyData) clone() PolicyData {
+	c := d
+	c.Data = cloneData(d.Data)
+	c.Datasets = maps.Clone(d.Datasets)
+	c.GroupIDs = maps.Clone(d.GroupIDs)
+	if d.PreviousPolicyData != nil {
+		prev := d.PreviousPolicyData.clone()
+		c.PreviousPolicyData = &prev
+	}
+	return c
+}
+
+// cloneData deep copies the maps and slices policy data is decoded into, other values are immutable or shared
+func cloneData(data interface{}) interface{} {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		c := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			c[key] = cloneData(value)
+		}
+		return c
+	case []interface{}:
+		if v == nil {
+			return v
+		}
+		c := make([]interface{}, len(v))
+		for i, value := range v {
+			c[i] = cloneData(value)
+		}
+		return c
+	case map[string]string:
+		return maps.Clone(v)
+	case []string:
+		return slices.Clone(v)
+	default:
+		return data
+	}
+}
+
 // Policy state types
 const (
 	Unknown PolicyState = iota
@@ -67,7 +113,14 @@ func (s PolicyState) String() string {
 
 // Scan scans the value into the PolicyState
 func (s *PolicyState) Scan(value interface{}) error {
-	*s = policyStateRevMap[string(value.([]byte))]
+	switch v := value.(type) {
+	case []byte:
+		*s = policyStateRevMap[string(v)]
+	case string:
+		*s = policyStateRevMap[v]
+	default:
+		return fmt.Errorf("unsupported policy state type: %T", value)
+	}
 	return nil
 }
 
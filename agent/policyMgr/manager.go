@@ -4,13 +4,16 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/orb-community/orb/fleet"
 	"go.uber.org/zap"
 
 	"github.com/netboxlabs/orb-agent/agent/backend"
 	"github.com/netboxlabs/orb-agent/agent/config"
+	"github.com/netboxlabs/orb-agent/agent/metrics"
 	"github.com/netboxlabs/orb-agent/agent/policies"
+	"github.com/netboxlabs/orb-agent/agent/secrets"
 )
 
 // PolicyManager is the interface for managing policies
@@ -19,9 +22,10 @@ type PolicyManager interface {
 	RemovePolicyDataset(policyID string, datasetID string, be backend.Backend)
 	GetPolicyState() ([]policies.PolicyData, error)
 	GetRepo() policies.PolicyRepo
-	ApplyBackendPolicies(be backend.Backend) error
-	RemoveBackendPolicies(be backend.Backend, permanently bool) error
+	ApplyBackendPolicies(beName string) error
+	RemoveBackendPolicies(beName string, permanently bool) error
 	RemovePolicy(policyID string, policyName string, beName string) error
+	RestorePolicies() error
 }
 
 var _ PolicyManager = (*policyManager)(nil)
@@ -30,16 +34,26 @@ type policyManager struct {
 	logger *zap.Logger
 	config config.Config
 
-	repo policies.PolicyRepo
+	repo    policies.PolicyRepo
+	secrets *secrets.Resolver
 }
 
 // New creates a new instance of PolicyManager
 func New(logger *zap.Logger, c config.Config) (PolicyManager, error) {
-	repo, err := policies.NewMemRepo(logger)
+	var repo policies.PolicyRepo
+	var err error
+	switch c.OrbAgent.PolicyRepo.Type {
+	case "", "memory":
+		repo, err = policies.NewMemRepo(logger)
+	case "sqlite":
+		repo, err = policies.NewSqliteRepo(logger, c.OrbAgent.PolicyRepo.File)
+	default:
+		err = fmt.Errorf("unknown policy repository type: %s", c.OrbAgent.PolicyRepo.Type)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &policyManager{logger: logger, config: c, repo: repo}, nil
+	return &policyManager{logger: logger, config: c, repo: repo, secrets: secrets.NewResolver(logger, c.OrbAgent.Secrets.Strict)}, nil
 }
 
 func (a *policyManager) GetRepo() policies.PolicyRepo {
@@ -118,7 +132,7 @@ func (a *policyManager) ManagePolicy(payload fleet.AgentPolicyRPCPayload) {
 			}
 
 		}
-		if !backend.HaveBackend(payload.Backend) {
+		if !a.configured(payload.Backend) {
 			a.logger.Warn("policy failed to apply because backend is not available", zap.String("policy_id", payload.ID), zap.String("policy_name", payload.Name))
 			pd.State = policies.FailedToApply
 			pd.BackendErr = "backend not available"
@@ -145,6 +159,13 @@ func (a *policyManager) ManagePolicy(payload fleet.AgentPolicyRPCPayload) {
 	}
 }
 
+// configured reports whether the named backend is registered and configured on this agent, an unconfigured
+// backend cannot apply or remove policies
+func (a *policyManager) configured(beName string) bool {
+	_, ok := a.config.OrbAgent.Backends[beName]
+	return ok && backend.HaveBackend(beName)
+}
+
 func (a *policyManager) RemovePolicy(policyID string, policyName string, beName string) error {
 	pd := policies.PolicyData{
 		ID:   policyID,
@@ -153,13 +174,18 @@ func (a *policyManager) RemovePolicy(policyID string, policyName string, beName
 	if !backend.HaveBackend(beName) {
 		return errors.New("policy remove for a backend we do not have, ignoring")
 	}
-	be := backend.GetBackend(beName)
-	err := be.RemovePolicy(pd)
-	if err != nil {
-		a.logger.Error("backend remove policy failed: will still remove from PolicyManager", zap.String("policy_id", policyID), zap.Error(err))
+	// policies of an unconfigured backend were never applied, they are only in the repo
+	if a.configured(beName) {
+		be := backend.GetBackend(beName)
+		start := time.Now()
+		err := be.RemovePolicy(pd)
+		metrics.ObservePolicyOperation(beName, metrics.PolicyRemove, start, err)
+		if err != nil {
+			a.logger.Error("backend remove policy failed: will still remove from PolicyManager", zap.String("policy_id", policyID), zap.Error(err))
+		}
 	}
 	// Remove policy from orb-agent local repo
-	err = a.repo.Remove(pd.ID)
+	err := a.repo.Remove(pd.ID)
 	if err != nil {
 		return err
 	}
@@ -179,7 +205,9 @@ func (a *policyManager) RemovePolicyDataset(policyID string, datasetID string, b
 	}
 	if removePolicy {
 		// Remove policy via http request
+		start := time.Now()
 		err := be.RemovePolicy(policyData)
+		metrics.ObservePolicyOperation(policyData.Backend, metrics.PolicyRemove, start, err)
 		if err != nil {
 			a.logger.Warn("policy failed to remove", zap.String("policy_id", policyID), zap.String("policy_name", policyData.Name), zap.Error(err))
 		}
@@ -191,8 +219,24 @@ func (a *policyManager) RemovePolicyDataset(policyID string, datasetID string, b
 	}
 }
 
+// resolvePolicy returns a copy of the policy, to be passed to the backend, with the secret references of its
+// data resolved. The repository keeps the references.
+func (a *policyManager) resolvePolicy(pd policies.PolicyData) (policies.PolicyData, error) {
+	data, err := a.secrets.ResolveData(pd.Data)
+	if err != nil {
+		return pd, fmt.Errorf("failed to resolve secret references: %w", err)
+	}
+	pd.Data = data
+	return pd, nil
+}
+
 func (a *policyManager) applyPolicy(payload fleet.AgentPolicyRPCPayload, be backend.Backend, pd *policies.PolicyData, updatePolicy bool) {
-	err := be.ApplyPolicy(*pd, updatePolicy)
+	start := time.Now()
+	resolved, err := a.resolvePolicy(*pd)
+	if err == nil {
+		err = be.ApplyPolicy(resolved, updatePolicy)
+	}
+	metrics.ObservePolicyOperation(pd.Backend, metrics.PolicyApply, start, err)
 	if err != nil {
 		a.logger.Warn("policy failed to apply", zap.String("policy_id", payload.ID), zap.String("policy_name", payload.Name), zap.Error(err))
 		switch {
@@ -209,13 +253,46 @@ func (a *policyManager) applyPolicy(payload fleet.AgentPolicyRPCPayload, be back
 	}
 }
 
-func (a *policyManager) RemoveBackendPolicies(be backend.Backend, permanently bool) error {
+// RestorePolicies re-applies every policy found in the repository, e.g. policies persisted by a
+// previous run of the agent, before the control plane gets a chance to re-sync them
+func (a *policyManager) RestorePolicies() error {
 	plcies, err := a.repo.GetAll()
 	if err != nil {
 		a.logger.Error("failed to retrieve list of policies", zap.Error(err))
 		return err
 	}
 
+	for _, policy := range plcies {
+		a.logger.Info("restoring persisted policy", zap.String("policy_id", policy.ID), zap.String("policy_name", policy.Name), zap.String("backend", policy.Backend))
+		if !a.configured(policy.Backend) {
+			a.logger.Warn("policy failed to restore because backend is not available", zap.String("policy_id", policy.ID), zap.String("policy_name", policy.Name))
+			policy.State = policies.FailedToApply
+			policy.BackendErr = "backend not available"
+		} else {
+			// apply as an update: some backends may already have picked the policy up from the repo on start
+			payload := fleet.AgentPolicyRPCPayload{ID: policy.ID, Name: policy.Name}
+			a.applyPolicy(payload, backend.GetBackend(policy.Backend), &policy, true)
+		}
+		if err := a.repo.Update(policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveBackendPolicies removes the policies of the named backend from it. They are also removed from the
+// repository when permanently is set, otherwise they are kept with an unknown state to be applied again.
+func (a *policyManager) RemoveBackendPolicies(beName string, permanently bool) error {
+	if !backend.HaveBackend(beName) {
+		return fmt.Errorf("policies remove for a backend we do not have: %s", beName)
+	}
+	be := backend.GetBackend(beName)
+	plcies, err := a.repo.GetByBackend(beName)
+	if err != nil {
+		a.logger.Error("failed to retrieve list of policies", zap.String("backend", beName), zap.Error(err))
+		return err
+	}
+
 	for _, plcy := range plcies {
 		err := be.RemovePolicy(plcy)
 		if err != nil {
@@ -238,15 +315,23 @@ func (a *policyManager) RemoveBackendPolicies(be backend.Backend, permanently bo
 	return nil
 }
 
-func (a *policyManager) ApplyBackendPolicies(be backend.Backend) error {
-	plcies, err := a.repo.GetAll()
+// ApplyBackendPolicies applies the policies of the named backend found in the repository to it
+func (a *policyManager) ApplyBackendPolicies(beName string) error {
+	if !backend.HaveBackend(beName) {
+		return fmt.Errorf("policies apply for a backend we do not have: %s", beName)
+	}
+	be := backend.GetBackend(beName)
+	plcies, err := a.repo.GetByBackend(beName)
 	if err != nil {
-		a.logger.Error("failed to retrieve list of policies", zap.Error(err))
+		a.logger.Error("failed to retrieve list of policies", zap.String("backend", beName), zap.Error(err))
 		return err
 	}
 
 	for _, policy := range plcies {
-		err := be.ApplyPolicy(policy, false)
+		resolved, err := a.resolvePolicy(policy)
+		if err == nil {
+			err = be.ApplyPolicy(resolved, false)
+		}
 		if err != nil {
 			a.logger.Warn("policy failed to apply", zap.String("policy_id", policy.ID), zap.String("policy_name", policy.Name), zap.Error(err))
 			policy.State = policies.FailedToApply
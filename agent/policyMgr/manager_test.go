@@ -0,0 +1,222 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/orb-community/orb/fleet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/config"
+	"github.com/netboxlabs/orb-agent/agent/policies"
+	"github.com/netboxlabs/orb-agent/agent/secrets"
+)
+
+// fakeBackend records applied policies and, like the otel backend, writes back to the repo
+type fakeBackend struct {
+	repo policies.PolicyRepo
+
+	mu      sync.Mutex
+	applied []string
+	removed []string
+	data    map[string]interface{}
+}
+
+var _ backend.Backend = (*fakeBackend)(nil)
+
+func (f *fakeBackend) Configure(_ *zap.Logger, repo policies.PolicyRepo, _ map[string]interface{}, _ config.BackendCommons) error {
+	f.repo = repo
+	return nil
+}
+func (f *fakeBackend) SetCommsClient(_ string, _ *mqtt.Client, _ string) {}
+func (f *fakeBackend) Version() (string, error)                          { return "1.0.0", nil }
+func (f *fakeBackend) Start(_ context.Context, _ context.CancelFunc) error {
+	return nil
+}
+func (f *fakeBackend) Stop(_ context.Context) error                     { return nil }
+func (f *fakeBackend) FullReset(_ context.Context) error                { return nil }
+func (f *fakeBackend) GetStartTime() time.Time                          { return time.Time{} }
+func (f *fakeBackend) GetCapabilities() (map[string]interface{}, error) { return nil, nil }
+func (f *fakeBackend) GetRunningStatus() (backend.RunningStatus, string, error) {
+	return backend.Running, "", nil
+}
+func (f *fakeBackend) GetInitialState() backend.RunningStatus { return backend.Unknown }
+
+func (f *fakeBackend) ApplyPolicy(data policies.PolicyData, updatePolicy bool) error {
+	f.mu.Lock()
+	f.applied = append(f.applied, data.ID)
+	if f.data == nil {
+		f.data = make(map[string]interface{})
+	}
+	f.data[data.ID] = data.Data
+	f.mu.Unlock()
+	if updatePolicy && f.repo != nil {
+		return f.repo.Update(data)
+	}
+	return nil
+}
+
+func (f *fakeBackend) RemovePolicy(data policies.PolicyData) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removed = append(f.removed, data.ID)
+	return nil
+}
+
+func newTestManager(t *testing.T, backends ...string) (*policyManager, map[string]*fakeBackend) {
+	t.Helper()
+	var c config.Config
+	c.OrbAgent.Backends = make(map[string]map[string]interface{})
+	pm, err := New(zap.NewNop(), c)
+	require.NoError(t, err)
+	fakes := make(map[string]*fakeBackend, len(backends))
+	for _, name := range backends {
+		be := &fakeBackend{}
+		require.NoError(t, be.Configure(zap.NewNop(), pm.GetRepo(), nil, config.BackendCommons{}))
+		backend.Register(name, be)
+		c.OrbAgent.Backends[name] = map[string]interface{}{}
+		fakes[name] = be
+	}
+	return pm.(*policyManager), fakes
+}
+
+func TestPolicyManager_ConcurrentManageRemoveHeartbeat(t *testing.T) {
+	pm, _ := newTestManager(t, "test_concurrent")
+
+	const workers = 4
+	const iterations = 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(3)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				id := fmt.Sprintf("policy-%d-%d", w, i%5)
+				pm.ManagePolicy(fleet.AgentPolicyRPCPayload{
+					Action: "manage", ID: id, Name: id, Backend: "test_concurrent",
+					DatasetID: fmt.Sprintf("dataset-%d", i), AgentGroupID: "group", Version: int32(i),
+				})
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				id := fmt.Sprintf("policy-%d-%d", w, i%5)
+				if i%2 == 0 {
+					_ = pm.RemovePolicy(id, id, "test_concurrent")
+				} else {
+					pm.RemovePolicyDataset(id, fmt.Sprintf("dataset-%d", i-1), backend.GetBackend("test_concurrent"))
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				state, err := pm.GetPolicyState()
+				assert.NoError(t, err)
+				for _, pd := range state {
+					_ = pd.GetDatasetIDs()
+					_ = pd.State.String()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPolicyManager_ResolvesSecrets(t *testing.T) {
+	t.Setenv("ORB_TEST_POLICY_PASSWORD", "policy-password")
+	pm, fakes := newTestManager(t, "test_secrets")
+	pm.secrets = secrets.NewResolver(zap.NewNop(), true)
+
+	pm.ManagePolicy(fleet.AgentPolicyRPCPayload{
+		Action: "manage", ID: "resolved", Name: "resolved", Backend: "test_secrets", DatasetID: "resolved", Version: 1,
+		Data: map[string]interface{}{"password": "${env:ORB_TEST_POLICY_PASSWORD}"},
+	})
+	assert.Equal(t, map[string]interface{}{"password": "policy-password"}, fakes["test_secrets"].data["resolved"])
+	stored, err := pm.GetRepo().Get("resolved")
+	require.NoError(t, err)
+	assert.Equal(t, policies.Running, stored.State)
+	assert.Equal(t, map[string]interface{}{"password": "${env:ORB_TEST_POLICY_PASSWORD}"}, stored.Data, "the repository keeps the reference")
+
+	pm.ManagePolicy(fleet.AgentPolicyRPCPayload{
+		Action: "manage", ID: "unresolved", Name: "unresolved", Backend: "test_secrets", DatasetID: "unresolved", Version: 1,
+		Data: map[string]interface{}{"password": "${env:ORB_TEST_MISSING_PASSWORD}"},
+	})
+	assert.NotContains(t, fakes["test_secrets"].data, "unresolved")
+	stored, err = pm.GetRepo().Get("unresolved")
+	require.NoError(t, err)
+	assert.Equal(t, policies.FailedToApply, stored.State)
+	assert.Contains(t, stored.BackendErr, "failed to resolve secret references")
+	assert.Contains(t, stored.BackendErr, "ORB_TEST_MISSING_PASSWORD")
+}
+
+func TestPolicyManager_BackendScopedOperations(t *testing.T) {
+	pm, fakes := newTestManager(t, "test_scope_pktvisor", "test_scope_otel")
+	for _, p := range []struct{ id, backend string }{
+		{"pv-1", "test_scope_pktvisor"},
+		{"pv-2", "test_scope_pktvisor"},
+		{"otel-1", "test_scope_otel"},
+	} {
+		pm.ManagePolicy(fleet.AgentPolicyRPCPayload{
+			Action: "manage", ID: p.id, Name: p.id, Backend: p.backend, DatasetID: "dataset-" + p.id, AgentGroupID: "group", Version: 1,
+		})
+	}
+	pktvisor, otel := fakes["test_scope_pktvisor"], fakes["test_scope_otel"]
+	assert.ElementsMatch(t, []string{"pv-1", "pv-2"}, pktvisor.applied)
+	assert.Equal(t, []string{"otel-1"}, otel.applied)
+	pktvisor.applied, otel.applied = nil, nil
+
+	require.NoError(t, pm.RemoveBackendPolicies("test_scope_pktvisor", false))
+	assert.ElementsMatch(t, []string{"pv-1", "pv-2"}, pktvisor.removed)
+	assert.Empty(t, otel.removed)
+	otelPolicy, err := pm.GetRepo().Get("otel-1")
+	require.NoError(t, err)
+	assert.Equal(t, policies.Running, otelPolicy.State, "policies of other backends are untouched")
+	pvPolicy, err := pm.GetRepo().Get("pv-1")
+	require.NoError(t, err)
+	assert.Equal(t, policies.Unknown, pvPolicy.State)
+
+	require.NoError(t, pm.ApplyBackendPolicies("test_scope_pktvisor"))
+	assert.ElementsMatch(t, []string{"pv-1", "pv-2"}, pktvisor.applied)
+	assert.Empty(t, otel.applied)
+	pvPolicy, err = pm.GetRepo().Get("pv-1")
+	require.NoError(t, err)
+	assert.Equal(t, policies.Running, pvPolicy.State)
+	assert.Equal(t, map[string]bool{"dataset-pv-1": true}, pvPolicy.Datasets)
+	assert.Equal(t, map[string]bool{"group": true}, pvPolicy.GroupIDs)
+
+	require.NoError(t, pm.RemoveBackendPolicies("test_scope_otel", true))
+	assert.Equal(t, []string{"otel-1"}, otel.removed)
+	assert.False(t, pm.GetRepo().Exists("otel-1"))
+	assert.True(t, pm.GetRepo().Exists("pv-1"))
+	assert.True(t, pm.GetRepo().Exists("pv-2"))
+
+	assert.Error(t, pm.ApplyBackendPolicies("test_scope_unknown"))
+}
+
+func TestPolicyManager_UnconfiguredBackend(t *testing.T) {
+	pm, _ := newTestManager(t)
+	be := &fakeBackend{}
+	backend.Register("test_unconfigured", be)
+
+	pm.ManagePolicy(fleet.AgentPolicyRPCPayload{
+		Action: "manage", ID: "p1", Name: "p1", Backend: "test_unconfigured", DatasetID: "dataset-1", Version: 1,
+	})
+	assert.Empty(t, be.applied, "a backend missing from the config is never called")
+	stored, err := pm.GetRepo().Get("p1")
+	require.NoError(t, err)
+	assert.Equal(t, policies.FailedToApply, stored.State)
+	assert.Equal(t, "backend not available", stored.BackendErr)
+
+	require.NoError(t, pm.RemovePolicy("p1", "p1", "test_unconfigured"))
+	assert.Empty(t, be.removed)
+	assert.False(t, pm.GetRepo().Exists("p1"))
+}
@@ -0,0 +1,63 @@
+package redact
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func fields(fs []zapcore.Field) []zapcore.Field {
+	ret := make([]zapcore.Field, len(fs))
+	for i, f := range fs {
+		switch f.Type {
+		case zapcore.StringType:
+			f.String = String(f.String)
+		case zapcore.ByteStringType:
+			if b, ok := f.Interface.([]byte); ok {
+				f = zap.ByteString(f.Key, Bytes(b))
+			}
+		case zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok {
+				f = zap.String(f.Key, String(err.Error()))
+			}
+		case zapcore.StringerType:
+			f = zap.String(f.Key, String(fmt.Sprint(f.Interface)))
+		case zapcore.ReflectType:
+			f = zap.Any(f.Key, Data(f.Interface))
+		case zapcore.ArrayMarshalerType, zapcore.ObjectMarshalerType:
+			// e.g. zap.Strings: encode to plain values first
+			enc := zapcore.NewMapObjectEncoder()
+			f.AddTo(enc)
+			f = zap.Any(f.Key, Data(enc.Fields[f.Key]))
+		}
+		ret[i] = f
+	}
+	return ret
+}
+
+type core struct {
+	zapcore.Core
+}
+
+// NewCore wraps a zap core so that every log entry goes through the redaction: message, strings, errors and
+// structured fields
+func NewCore(c zapcore.Core) zapcore.Core {
+	return &core{Core: c}
+}
+
+func (c *core) With(fs []zapcore.Field) zapcore.Core {
+	return &core{Core: c.Core.With(fields(fs))}
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fs []zapcore.Field) error {
+	ent.Message = String(ent.Message)
+	return c.Core.Write(ent, fields(fs))
+}
@@ -0,0 +1,33 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestCore(t *testing.T) {
+	AddValue("very-secret-value")
+
+	var buf bytes.Buffer
+	c := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zap.DebugLevel)
+	logger := zap.New(NewCore(c)).With(zap.String("with", "very-secret-value"))
+	logger.Info("connecting with very-secret-value",
+		zap.String("target", "very-secret-value"),
+		zap.Error(errors.New("auth failed for very-secret-value")),
+		zap.Any("data", map[string]interface{}{"scope": []interface{}{map[string]interface{}{"hostname": "h1", "password": "device-pass"}}}),
+		zap.Strings("args", []string{"--target", "very-secret-value"}),
+		zap.ByteString("body", []byte(`{"name":"agent","token":"api-token"}`)),
+	)
+
+	out := buf.String()
+	assert.NotContains(t, out, "very-secret-value")
+	assert.NotContains(t, out, "device-pass")
+	assert.NotContains(t, out, "api-token")
+	assert.Contains(t, out, `"hostname":"h1"`)
+	assert.Contains(t, out, Redacted)
+}
@@ -0,0 +1,206 @@
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces secrets in the logs
+const Redacted = "[REDACTED]"
+
+// minValueLength avoids redacting every occurrence of very short values, such as "1", from the logs
+const minValueLength = 4
+
+// DefaultKeys are the patterns of the keys, and command line flags, whose values are always redacted. They are
+// case-insensitive regular expressions matched against the key.
+var DefaultKeys = []string{
+	"password",
+	"passwd",
+	"secret",
+	"token",
+	"api[_-]?key",
+	"private[_-]?key",
+	"^key$",
+	"authorization",
+	"credential",
+}
+
+var (
+	mu       sync.RWMutex
+	keys     = mustCompile(DefaultKeys)
+	values   []string
+	replacer *strings.Replacer
+)
+
+func mustCompile(patterns []string) *regexp.Regexp {
+	re, err := compile(patterns)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+func compile(patterns []string) (*regexp.Regexp, error) {
+	for _, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("invalid redacted key pattern %q: %w", p, err)
+		}
+	}
+	return regexp.Compile("(?i)(" + strings.Join(patterns, ")|(") + ")")
+}
+
+// SetKeys redacts the values of the keys matching the given patterns, in addition to DefaultKeys
+func SetKeys(patterns []string) error {
+	re, err := compile(append(append([]string{}, DefaultKeys...), patterns...))
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	keys = re
+	return nil
+}
+
+// IsSensitiveKey reports whether the value of key, e.g. a policy field or a command line flag, is redacted
+func IsSensitiveKey(key string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return keys.MatchString(strings.TrimLeft(key, "-"))
+}
+
+// AddValue registers a secret value, e.g. a resolved secret reference, to be redacted wherever it appears
+func AddValue(value string) {
+	if len(value) < minValueLength {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, v := range values {
+		if v == value {
+			return
+		}
+	}
+	values = append(values, value)
+	// replace longer values first, in case a secret contains another one
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, Redacted)
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+// String replaces the registered secret values found in s
+func String(s string) string {
+	mu.RLock()
+	r := replacer
+	mu.RUnlock()
+	if r == nil {
+		return s
+	}
+	return r.Replace(s)
+}
+
+// Data returns a copy of v, e.g. policy data or a backend configuration, with the values of sensitive keys and the
+// registered secret values redacted
+func Data(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return String(val)
+	case bool, int, int32, int64, float32, float64:
+		return v
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(val))
+		for key, value := range val {
+			if value != nil && IsSensitiveKey(key) {
+				ret[key] = Redacted
+				continue
+			}
+			ret[key] = Data(value)
+		}
+		return ret
+	case map[interface{}]interface{}:
+		ret := make(map[interface{}]interface{}, len(val))
+		for key, value := range val {
+			if value != nil && IsSensitiveKey(fmt.Sprint(key)) {
+				ret[key] = Redacted
+				continue
+			}
+			ret[key] = Data(value)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(val))
+		for i, value := range val {
+			ret[i] = Data(value)
+		}
+		return ret
+	default:
+		// structs and typed maps: redact their JSON representation
+		data, err := json.Marshal(v)
+		if err != nil {
+			return v
+		}
+		var generic interface{}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return v
+		}
+		return Data(generic)
+	}
+}
+
+// Args returns a copy of command line arguments with the values of sensitive flags, either `--flag value` or
+// `--flag=value`, and the registered secret values redacted
+func Args(args []string) []string {
+	ret := make([]string, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			ret[i] = String(arg)
+			continue
+		}
+		if flag, _, ok := strings.Cut(arg, "="); ok {
+			if IsSensitiveKey(flag) {
+				ret[i] = flag + "=" + Redacted
+			} else {
+				ret[i] = String(arg)
+			}
+			continue
+		}
+		ret[i] = arg
+		if IsSensitiveKey(arg) && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			i++
+			ret[i] = Redacted
+		}
+	}
+	return ret
+}
+
+// Bytes returns a copy of a JSON or YAML document, e.g. a request body or a policy, with the values of sensitive
+// keys and the registered secret values redacted. Other content only has the registered secret values redacted.
+func Bytes(b []byte) []byte {
+	var doc interface{}
+	if json.Valid(b) {
+		if err := json.Unmarshal(b, &doc); err == nil {
+			if out, err := json.Marshal(Data(doc)); err == nil {
+				return out
+			}
+		}
+	} else if err := yaml.Unmarshal(b, &doc); err == nil {
+		switch doc.(type) {
+		case map[string]interface{}, []interface{}:
+			if out, err := yaml.Marshal(Data(doc)); err == nil {
+				return out
+			}
+		}
+	}
+	return []byte(String(string(b)))
+}
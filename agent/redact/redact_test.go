@@ -0,0 +1,67 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsSensitiveKey(t *testing.T) {
+	for _, key := range []string{"password", "Password", "api_key", "apiKey", "--diode-api-key", "token", "key", "client_secret"} {
+		assert.True(t, IsSensitiveKey(key), key)
+	}
+	for _, key := range []string{"hostname", "username", "keyboard", "schedule", "--host"} {
+		assert.False(t, IsSensitiveKey(key), key)
+	}
+}
+
+func TestSetKeys(t *testing.T) {
+	t.Cleanup(func() {
+		require.NoError(t, SetKeys(nil))
+	})
+	require.NoError(t, SetKeys([]string{"^community$"}))
+	assert.True(t, IsSensitiveKey("community"))
+	assert.True(t, IsSensitiveKey("password"), "default keys are kept")
+	assert.Error(t, SetKeys([]string{"("}))
+}
+
+func TestData(t *testing.T) {
+	data := map[string]interface{}{
+		"config": map[string]interface{}{"schedule": "* * * * *", "timeout": 5},
+		"scope": []interface{}{
+			map[string]interface{}{"hostname": "192.168.0.5", "username": "admin", "password": "device-pass"},
+		},
+		"mqtt": map[interface{}]interface{}{"id": "agent-id", "key": "agent-key"},
+	}
+	redacted := Data(data).(map[string]interface{})
+	device := redacted["scope"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, Redacted, device["password"])
+	assert.Equal(t, "admin", device["username"])
+	assert.Equal(t, 5, redacted["config"].(map[string]interface{})["timeout"])
+	assert.Equal(t, Redacted, redacted["mqtt"].(map[interface{}]interface{})["key"])
+	// the original data is untouched
+	assert.Equal(t, "device-pass", data["scope"].([]interface{})[0].(map[string]interface{})["password"])
+
+	typed := map[string]map[string]interface{}{"common": {"diode": map[string]interface{}{"api_key": "diode-key"}}}
+	assert.NotContains(t, Data(typed), "diode-key")
+	assert.Equal(t, Redacted, Data(typed).(map[string]interface{})["common"].(map[string]interface{})["diode"].(map[string]interface{})["api_key"])
+}
+
+func TestArgs(t *testing.T) {
+	args := []string{"--host", "localhost", "--diode-api-key", "diode-key", "--token=abc", "--port", "8072"}
+	assert.Equal(t, []string{"--host", "localhost", "--diode-api-key", Redacted, "--token=" + Redacted, "--port", "8072"}, Args(args))
+}
+
+func TestBytes(t *testing.T) {
+	assert.JSONEq(t, `{"name":"agent","token":"`+Redacted+`"}`, string(Bytes([]byte(`{"name":"agent","token":"api-token"}`))))
+	assert.Equal(t, "scope:\n    - hostname: h1\n      password: '"+Redacted+"'\n", string(Bytes([]byte("scope:\n  - hostname: h1\n    password: device-pass\n"))))
+	assert.Equal(t, "plain text", string(Bytes([]byte("plain text"))))
+}
+
+func TestAddValue(t *testing.T) {
+	AddValue("abc")
+	assert.Equal(t, "abc", String("abc"), "short values are not redacted")
+	AddValue("registered-secret")
+	assert.Equal(t, "token is "+Redacted, String("token is registered-secret"))
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"maps"
+	"reflect"
+
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/config"
+)
+
+const reloadRestartReason = "configuration reloaded"
+
+// Reload applies a re-read configuration to the running agent. Backends whose configuration changed
+// are restarted and the policies from the config file are added, updated or removed to match it.
+// Everything else requires an agent restart to take effect.
+func (a *orbAgent) Reload(ctx context.Context, c config.Config) error {
+	a.rpcMutex.Lock()
+	defer a.rpcMutex.Unlock()
+	ctx = a.configManager.GetContext(ctx)
+	a.logger.Info("reloading agent configuration", zap.String("config_file", c.OrbAgent.ConfigFile))
+
+	newBackends := maps.Clone(c.OrbAgent.Backends)
+	newCommon, err := a.decodeCommons(newBackends)
+	if err != nil {
+		return err
+	}
+	delete(newBackends, "common")
+
+	for name := range newBackends {
+		if _, ok := a.backends[name]; !ok {
+			a.logger.Warn("adding a backend requires an agent restart, ignoring", zap.String("backend", name))
+		}
+	}
+	for name := range a.backends {
+		if _, ok := newBackends[name]; !ok {
+			a.logger.Warn("removing a backend requires an agent restart, ignoring", zap.String("backend", name))
+		}
+	}
+
+	// common settings are passed to every backend, so changing them restarts all of them
+	commonChanged := !reflect.DeepEqual(newCommon, a.backendsCommon)
+	if commonChanged {
+		a.backendsCommon = newCommon
+	}
+	backends := maps.Clone(a.config.OrbAgent.Backends)
+	restarted := make(map[string]bool)
+	for name := range a.backends {
+		newConfig, ok := newBackends[name]
+		if !ok {
+			continue
+		}
+		if !commonChanged && reflect.DeepEqual(newConfig, backends[name]) {
+			continue
+		}
+		backends[name] = newConfig
+		restarted[name] = true
+	}
+	a.config.OrbAgent.Backends = backends
+
+	for name := range restarted {
+		if err := a.RestartBackend(ctx, name, reloadRestartReason); err != nil {
+			a.logger.Error("failed to restart backend", zap.String("backend", name), zap.Error(err))
+		}
+	}
+
+	a.reloadPolicies(c.OrbAgent.Policies)
+	a.logger.Info("agent configuration reloaded", zap.Int("restarted_backends", len(restarted)))
+
+	return nil
+}
+
+// reloadPolicies diffs the policies from the config file against the ones currently applied. The policies
+// of restarted backends were already applied again by the restart.
+func (a *orbAgent) reloadPolicies(newPolicies map[string]map[string]interface{}) {
+	oldPolicies := a.config.OrbAgent.Policies
+	a.config.OrbAgent.Policies = newPolicies
+	repo := a.policyManager.GetRepo()
+
+	for beName, plcies := range oldPolicies {
+		for pName, data := range plcies {
+			id := localPolicyID(beName, pName, data)
+			// a policy whose explicit id changed is removed under its previous id
+			if newData, ok := newPolicies[beName][pName]; ok && localPolicyID(beName, pName, newData) == id {
+				continue
+			}
+			current, err := repo.Get(id)
+			if err != nil {
+				continue
+			}
+			a.logger.Info("policy removed from config file, removing", zap.String("backend", beName), zap.String("policy_name", pName))
+			if err := a.policyManager.RemovePolicy(current.ID, current.Name, beName); err != nil {
+				a.logger.Warn("failed to remove a policy, ignoring", zap.String("policy_id", current.ID), zap.String("policy_name", pName), zap.Error(err))
+			}
+		}
+	}
+
+	for beName, plcies := range newPolicies {
+		if _, ok := a.backends[beName]; !ok {
+			a.logger.Warn("policy backend is not running, ignoring", zap.String("backend", beName))
+			continue
+		}
+		for pName, data := range plcies {
+			oldData, existed := oldPolicies[beName][pName]
+			if existed && reflect.DeepEqual(oldData, data) {
+				continue
+			}
+			a.applyLocalPolicy(beName, pName, data)
+		}
+	}
+}
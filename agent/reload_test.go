@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/config"
+	"github.com/netboxlabs/orb-agent/agent/policies"
+)
+
+func Test_orbAgent_Reload_policies(t *testing.T) {
+	kept := map[string]interface{}{"kind": "collection"}
+	changed := map[string]interface{}{"kind": "collection"}
+	deleted := map[string]interface{}{"kind": "collection"}
+	var seed []policies.PolicyData
+	for name, data := range map[string]interface{}{"kept": kept, "changed": changed, "deleted": deleted} {
+		payload := localPolicyPayload("pktvisor", name, data)
+		seed = append(seed, policies.PolicyData{ID: payload.ID, Name: name, Backend: "pktvisor", Version: payload.Version, Data: payload.Data, State: policies.Running})
+	}
+	pm := newFakePolicyManager(t, seed...)
+	backendConfig := map[string]interface{}{"binary": "/usr/local/sbin/pktvisord"}
+	a := orbAgent{
+		logger:        zap.NewNop(),
+		policyManager: pm,
+		configManager: config.New(zap.NewNop(), config.ManagerConfig{Active: "local"}),
+		backends:      map[string]backend.Backend{"pktvisor": &fakeBackend{}},
+	}
+	a.config.OrbAgent.Backends = map[string]map[string]interface{}{"pktvisor": backendConfig}
+	a.config.OrbAgent.Policies = map[string]map[string]interface{}{
+		"pktvisor": {"kept": kept, "changed": changed, "deleted": deleted},
+	}
+
+	var c config.Config
+	c.OrbAgent.Backends = map[string]map[string]interface{}{"pktvisor": backendConfig}
+	c.OrbAgent.Policies = map[string]map[string]interface{}{
+		"pktvisor": {
+			"kept":    map[string]interface{}{"kind": "collection"},
+			"changed": map[string]interface{}{"kind": "collection", "input": "default"},
+			"added":   map[string]interface{}{"kind": "collection"},
+		},
+	}
+	require.NoError(t, a.Reload(context.Background(), c))
+
+	assert.Contains(t, pm.removed, localPolicyID("pktvisor", "deleted", deleted))
+	assert.NotContains(t, pm.removed, localPolicyID("pktvisor", "kept", kept))
+	managed := make(map[string]string)
+	for _, payload := range pm.managed {
+		managed[payload.Name] = payload.ID
+		if payload.Name == "changed" {
+			assert.Equal(t, int32(2), payload.Version, "a changed policy is updated to the next version")
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"changed": localPolicyID("pktvisor", "changed", changed),
+		"added":   localPolicyID("pktvisor", "added", nil),
+	}, managed)
+	assert.Equal(t, c.OrbAgent.Policies, a.config.OrbAgent.Policies)
+}
+
+func Test_orbAgent_Reload_backends(t *testing.T) {
+	changed, kept := &recordingBackend{}, &recordingBackend{}
+	a := orbAgent{
+		logger:        zap.NewNop(),
+		policyManager: newFakePolicyManager(t),
+		configManager: config.New(zap.NewNop(), config.ManagerConfig{Active: "local"}),
+		backends:      map[string]backend.Backend{"changed": changed, "kept": kept},
+		backendState: map[string]*backend.State{
+			"changed": {Status: backend.Running},
+			"kept":    {Status: backend.Running},
+		},
+	}
+	a.config.OrbAgent.Backends = map[string]map[string]interface{}{
+		"changed": {"binary": "/usr/local/bin/changed"},
+		"kept":    {"binary": "/usr/local/bin/kept"},
+	}
+
+	var c config.Config
+	c.OrbAgent.Backends = map[string]map[string]interface{}{
+		"changed": {"binary": "/opt/bin/changed"},
+		"kept":    {"binary": "/usr/local/bin/kept"},
+	}
+	require.NoError(t, a.Reload(context.Background(), c))
+
+	assert.Equal(t, []string{"reset"}, changed.calls)
+	assert.Empty(t, kept.calls, "a backend whose config did not change keeps running")
+	assert.Equal(t, c.OrbAgent.Backends, a.config.OrbAgent.Backends)
+	state, _ := a.getBackendState("changed")
+	assert.Equal(t, reloadRestartReason, state.LastRestartReason)
+	state, _ = a.getBackendState("kept")
+	assert.Empty(t, state.LastRestartReason)
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	retryInitialInterval = 10 * time.Second
+	retryMaxInterval     = 5 * time.Minute
+	retryMultiplier      = 2
+	retryJitterFraction  = 0.2
+
+	groupMembershipRequest = "group_membership"
+	agentPoliciesRequest   = "agent_policies"
+)
+
+// RequestRetryStats counts how often a control plane request was sent and acknowledged
+type RequestRetryStats struct {
+	Attempts     atomic.Int64
+	Acknowledged atomic.Int64
+	GaveUp       atomic.Int64
+}
+
+type requestRetries struct {
+	mu    sync.Mutex
+	stats map[string]*RequestRetryStats
+}
+
+func (r *requestRetries) get(name string) *RequestRetryStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stats == nil {
+		r.stats = make(map[string]*RequestRetryStats)
+	}
+	s, ok := r.stats[name]
+	if !ok {
+		s = &RequestRetryStats{}
+		r.stats[name] = s
+	}
+	return s
+}
+
+// nextRetryInterval doubles the interval up to retryMaxInterval and applies jitter so agents
+// reconnecting together after a control plane outage do not re-request in lockstep
+func nextRetryInterval(current time.Duration) time.Duration {
+	next := current * retryMultiplier
+	if next > retryMaxInterval {
+		next = retryMaxInterval
+	}
+	jitter := time.Duration((rand.Float64()*2 - 1) * retryJitterFraction * float64(next))
+	return next + jitter
+}
+
+// sendWithRetry sends a request immediately and keeps re-sending it with exponential backoff until
+// the returned cancel func is called (i.e. the matching response arrived) or the agent stops.
+// Any retry loop previously stored in cancelRef is cancelled first.
+func (a *orbAgent) sendWithRetry(name string, cancelRef *context.CancelFunc, send func() error) error {
+	stats := a.requestRetries.get(name)
+
+	a.retryMutex.Lock()
+	if *cancelRef != nil {
+		(*cancelRef)()
+	}
+	ctx, cancel := a.extendContext("retry_" + name)
+	*cancelRef = cancel
+	a.retryMutex.Unlock()
+
+	stats.Attempts.Add(1)
+	err := send()
+	if err != nil {
+		a.logger.Error("failed to send control plane request, will retry", zap.String("request", name), zap.Error(err))
+	}
+
+	go func() {
+		interval := retryInitialInterval
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+		start := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				a.logger.Debug("control plane request retry finished", zap.String("request", name), zap.Duration("waiting_period", time.Since(start)))
+				return
+			case <-timer.C:
+				attempt := stats.Attempts.Add(1)
+				a.logger.Info("agent did not receive a response from the control plane, re-requesting",
+					zap.String("request", name), zap.Int64("attempt", attempt), zap.Duration("waiting_period", interval))
+				if err := send(); err != nil {
+					a.logger.Error("failed to re-send control plane request", zap.String("request", name), zap.Error(err))
+				}
+				interval = nextRetryInterval(interval)
+				timer.Reset(interval)
+			}
+		}
+	}()
+
+	return err
+}
+
+// acknowledgeRequest stops the retry loop stored in cancelRef, if any
+func (a *orbAgent) acknowledgeRequest(name string, cancelRef *context.CancelFunc) {
+	a.retryMutex.Lock()
+	defer a.retryMutex.Unlock()
+	if *cancelRef == nil {
+		return
+	}
+	a.logger.Debug("received control plane response, marking success", zap.String("request", name))
+	a.requestRetries.get(name).Acknowledged.Add(1)
+	(*cancelRef)()
+	*cancelRef = nil
+}
+
+// stopRequestRetries cancels every pending retry loop without acknowledging it
+func (a *orbAgent) stopRequestRetries() {
+	a.retryMutex.Lock()
+	defer a.retryMutex.Unlock()
+	for name, cancelRef := range map[string]*context.CancelFunc{
+		groupMembershipRequest: &a.groupRequestSucceeded,
+		agentPoliciesRequest:   &a.policyRequestSucceeded,
+	} {
+		if *cancelRef != nil {
+			a.requestRetries.get(name).GaveUp.Add(1)
+			(*cancelRef)()
+			*cancelRef = nil
+		}
+	}
+}
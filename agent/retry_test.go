@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func Test_nextRetryInterval(t *testing.T) {
+	interval := retryInitialInterval
+	for i := 0; i < 20; i++ {
+		next := nextRetryInterval(interval)
+		expected := min(interval*retryMultiplier, retryMaxInterval)
+		assert.LessOrEqual(t, next, time.Duration(float64(expected)*(1+retryJitterFraction)))
+		assert.GreaterOrEqual(t, next, time.Duration(float64(expected)*(1-retryJitterFraction)))
+		interval = next
+	}
+	assert.Greater(t, interval, retryMaxInterval/2)
+}
+
+func Test_orbAgent_sendWithRetry(t *testing.T) {
+	client := &fakeMQTTClient{}
+	a := orbAgent{logger: zap.NewNop(), client: client, asyncContext: context.Background()}
+	a.nameAgentRPCTopics("chan-1")
+
+	require.NoError(t, a.sendGroupMembershipReq())
+	require.NotNil(t, a.groupRequestSucceeded)
+	require.Len(t, client.publishes(), 1)
+	assert.Equal(t, a.rpcToCoreTopic, client.publishes()[0].topic)
+
+	// a second request replaces the pending retry loop
+	require.NoError(t, a.sendGroupMembershipReq())
+	stats := a.requestRetries.get(groupMembershipRequest)
+	assert.Equal(t, int64(2), stats.Attempts.Load())
+
+	a.acknowledgeRequest(groupMembershipRequest, &a.groupRequestSucceeded)
+	assert.Nil(t, a.groupRequestSucceeded)
+	assert.Equal(t, int64(1), stats.Acknowledged.Load())
+
+	// acknowledging without a pending request is a no-op
+	a.acknowledgeRequest(groupMembershipRequest, &a.groupRequestSucceeded)
+	assert.Equal(t, int64(1), stats.Acknowledged.Load())
+
+	require.NoError(t, a.sendAgentPoliciesReq())
+	a.stopRequestRetries()
+	assert.Nil(t, a.policyRequestSucceeded)
+	assert.Equal(t, int64(1), a.requestRetries.get(agentPoliciesRequest).GaveUp.Load())
+}
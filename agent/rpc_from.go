@@ -0,0 +1,319 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/orb-community/orb/fleet"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/redact"
+)
+
+const sanitizeAction = "sanitize"
+
+func (a *orbAgent) handleGroupMembership(rpc fleet.GroupMembershipRPCPayload) {
+	// if this is the full list, reset all group subscriptions and subscribe to this list
+	if rpc.FullList {
+		a.unsubscribeGroupChannels()
+		a.subscribeGroupChannels(rpc.Groups)
+		if err := a.sendAgentPoliciesReq(); err != nil {
+			a.logger.Error("failed to send agent policies request", zap.Error(err))
+		}
+		return
+	}
+	// otherwise, just add these subscriptions to the existing list
+	a.subscribeGroupChannels(rpc.Groups)
+}
+
+func (a *orbAgent) handleAgentPolicies(rpc []fleet.AgentPolicyRPCPayload, fullList bool) {
+	if fullList {
+		plcies, err := a.policyManager.GetRepo().GetAll()
+		if err != nil {
+			a.logger.Error("failed to retrieve policies on handle subscriptions", zap.Error(err))
+			return
+		}
+		// remove every policy we know about that is not part of the full list
+		policyRemove := make(map[string]bool, len(plcies))
+		for _, p := range plcies {
+			policyRemove[p.ID] = true
+		}
+		for _, payload := range rpc {
+			delete(policyRemove, payload.ID)
+		}
+		for _, p := range plcies {
+			if !policyRemove[p.ID] {
+				continue
+			}
+			if err := a.policyManager.RemovePolicy(p.ID, p.Name, p.Backend); err != nil {
+				a.logger.Warn("failed to remove a policy, ignoring", zap.String("policy_id", p.ID), zap.String("policy_name", p.Name), zap.Error(err))
+			}
+		}
+	}
+
+	for _, payload := range rpc {
+		if payload.Action != sanitizeAction {
+			a.policyManager.ManagePolicy(payload)
+		}
+	}
+
+	// heartbeat with new policy status after application
+	a.ensureHeartbeat()
+}
+
+func (a *orbAgent) handleAgentGroupRemoval(rpc fleet.GroupRemovedRPCPayload) {
+	a.unsubscribeGroupChannel(rpc.ChannelID, rpc.AgentGroupID)
+
+	plcies, err := a.policyManager.GetRepo().GetAll()
+	if err != nil {
+		a.logger.Error("failed to retrieve policies on group removal", zap.Error(err))
+		return
+	}
+
+	for _, p := range plcies {
+		if !p.GroupIDs[rpc.AgentGroupID] {
+			continue
+		}
+		delete(p.GroupIDs, rpc.AgentGroupID)
+		if len(p.GroupIDs) == 0 {
+			a.logger.Info("policy no longer used by any group, removing", zap.String("policy_id", p.ID), zap.String("policy_name", p.Name))
+			if err := a.policyManager.RemovePolicy(p.ID, p.Name, p.Backend); err != nil {
+				a.logger.Warn("failed to remove a policy, ignoring", zap.String("policy_id", p.ID), zap.String("policy_name", p.Name), zap.Error(err))
+			}
+			continue
+		}
+		if err := a.policyManager.GetRepo().Update(p); err != nil {
+			a.logger.Warn("failed to update policy groups", zap.String("policy_id", p.ID), zap.String("policy_name", p.Name), zap.Error(err))
+		}
+		for _, datasetID := range rpc.Datasets {
+			a.removeDatasetFromPolicy(datasetID, p.ID)
+		}
+	}
+}
+
+func (a *orbAgent) handleDatasetRemoval(rpc fleet.DatasetRemovedRPCPayload) {
+	a.removeDatasetFromPolicy(rpc.DatasetID, rpc.PolicyID)
+}
+
+func (a *orbAgent) removeDatasetFromPolicy(datasetID string, policyID string) {
+	p, err := a.policyManager.GetRepo().Get(policyID)
+	if err != nil {
+		a.logger.Warn("failed to retrieve policy for dataset removal", zap.String("policy_id", policyID), zap.String("dataset_id", datasetID), zap.Error(err))
+		return
+	}
+	be, ok := a.backends[p.Backend]
+	if !ok {
+		a.logger.Warn("policy backend is not running, ignoring dataset removal", zap.String("policy_id", policyID), zap.String("backend", p.Backend))
+		return
+	}
+	a.policyManager.RemovePolicyDataset(policyID, datasetID, be)
+}
+
+func (a *orbAgent) handleAgentStop(ctx context.Context, payload fleet.AgentStopRPCPayload) {
+	a.logger.Warn("control plane requested agent stop", zap.String("reason", payload.Reason))
+	// ctx is cancelled as the agent stops taking requests, the drain must not be
+	a.Stop(context.WithoutCancel(ctx))
+}
+
+func (a *orbAgent) handleAgentReset(ctx context.Context, payload fleet.AgentResetRPCPayload) {
+	if payload.FullReset {
+		if err := a.RestartAll(ctx, payload.Reason); err != nil {
+			a.logger.Error("RestartAll failure", zap.Error(err))
+		}
+		return
+	}
+	for name := range a.backends {
+		if err := a.RestartBackend(ctx, name, payload.Reason); err != nil {
+			a.logger.Error("failed to restart backend", zap.String("backend", name), zap.Error(err))
+		}
+	}
+}
+
+// decodeRPC validates the common RPC envelope shared by every message from core
+func (a *orbAgent) decodeRPC(message mqtt.Message) (fleet.RPC, bool) {
+	var rpc fleet.RPC
+	if err := json.Unmarshal(message.Payload(), &rpc); err != nil {
+		a.logger.Error("error decoding RPC message from core", zap.Error(fleet.ErrSchemaMalformed))
+		return rpc, false
+	}
+	if rpc.SchemaVersion != fleet.CurrentRPCSchemaVersion {
+		a.logger.Error("error decoding RPC message from core", zap.Error(fleet.ErrSchemaVersion))
+		return rpc, false
+	}
+	if rpc.Func == "" || rpc.Payload == nil {
+		a.logger.Error("error decoding RPC message from core", zap.Error(fleet.ErrSchemaMalformed))
+		return rpc, false
+	}
+	return rpc, true
+}
+
+// rpcMessage is an RPC received from core, either on the agent channel or on one of its group channels
+type rpcMessage struct {
+	message mqtt.Message
+	group   bool
+}
+
+// rpcQueue hands the RPCs received from core to a single worker in their order of arrival, without ever blocking
+// the MQTT client callbacks
+type rpcQueue struct {
+	mu      sync.Mutex
+	pending []rpcMessage
+	// signaled when messages are pending
+	wakeup chan struct{}
+}
+
+func newRPCQueue() *rpcQueue {
+	return &rpcQueue{wakeup: make(chan struct{}, 1)}
+}
+
+func (q *rpcQueue) push(m rpcMessage) {
+	q.mu.Lock()
+	q.pending = append(q.pending, m)
+	q.mu.Unlock()
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+		// the worker is already signaled and takes every pending message
+	}
+}
+
+func (q *rpcQueue) take() []rpcMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := q.pending
+	q.pending = nil
+	return pending
+}
+
+// handleRPCs handles the queued RPCs from core one at a time, so that e.g. a policy removal never overtakes the
+// policy application that preceded it, until ctx is done
+func (a *orbAgent) handleRPCs(ctx context.Context) {
+	a.logger.Debug("start RPC handling routine", zap.Any("routine", ctx.Value(routineKey)))
+	for {
+		select {
+		case <-ctx.Done():
+			a.logger.Debug("context done, stopping RPC handling routine")
+			return
+		case <-a.rpcs.wakeup:
+			for _, m := range a.rpcs.take() {
+				if ctx.Err() != nil {
+					return
+				}
+				if m.group {
+					a.handleGroupRPC(m.message)
+				} else {
+					a.handleRPC(m.message)
+				}
+			}
+		}
+	}
+}
+
+func (a *orbAgent) handleGroupRPCFromCore(_ mqtt.Client, message mqtt.Message) {
+	a.rpcs.push(rpcMessage{message: message, group: true})
+}
+
+func (a *orbAgent) handleRPCFromCore(_ mqtt.Client, message mqtt.Message) {
+	a.rpcs.push(rpcMessage{message: message})
+}
+
+func (a *orbAgent) handleGroupRPC(message mqtt.Message) {
+	_, cancelFunc := a.extendContext("handleGroupRPCFromCore")
+	defer cancelFunc()
+	a.rpcMutex.Lock()
+	defer a.rpcMutex.Unlock()
+	a.logger.Debug("group RPC message from core", zap.String("topic", message.Topic()), zap.ByteString("payload", redact.Bytes(message.Payload())))
+
+	rpc, ok := a.decodeRPC(message)
+	if !ok {
+		return
+	}
+
+	switch rpc.Func {
+	case fleet.AgentPolicyRPCFunc:
+		var r fleet.AgentPolicyRPC
+		if err := json.Unmarshal(message.Payload(), &r); err != nil {
+			a.logger.Error("error decoding agent policy message from core", zap.Error(fleet.ErrSchemaMalformed))
+			return
+		}
+		a.handleAgentPolicies(r.Payload, r.FullList)
+		a.acknowledgeRequest(agentPoliciesRequest, &a.policyRequestSucceeded)
+	case fleet.GroupRemovedRPCFunc:
+		var r fleet.GroupRemovedRPC
+		if err := json.Unmarshal(message.Payload(), &r); err != nil {
+			a.logger.Error("error decoding agent group removal message from core", zap.Error(fleet.ErrSchemaMalformed))
+			return
+		}
+		a.handleAgentGroupRemoval(r.Payload)
+	case fleet.DatasetRemovedRPCFunc:
+		var r fleet.DatasetRemovedRPC
+		if err := json.Unmarshal(message.Payload(), &r); err != nil {
+			a.logger.Error("error decoding dataset removal message from core", zap.Error(fleet.ErrSchemaMalformed))
+			return
+		}
+		a.handleDatasetRemoval(r.Payload)
+	default:
+		a.logger.Warn("unsupported/unhandled core RPC, ignoring",
+			zap.String("func", rpc.Func),
+			zap.Any("payload", redact.Data(rpc.Payload)))
+	}
+}
+
+func (a *orbAgent) handleRPC(message mqtt.Message) {
+	ctx, cancelFunc := a.extendContext("handleRPCFromCore")
+	defer cancelFunc()
+	a.rpcMutex.Lock()
+	defer a.rpcMutex.Unlock()
+	a.logger.Debug("RPC message from core", zap.String("topic", message.Topic()), zap.ByteString("payload", redact.Bytes(message.Payload())))
+
+	rpc, ok := a.decodeRPC(message)
+	if !ok {
+		return
+	}
+
+	switch rpc.Func {
+	case fleet.GroupMembershipRPCFunc:
+		var r fleet.GroupMembershipRPC
+		if err := json.Unmarshal(message.Payload(), &r); err != nil {
+			a.logger.Error("error decoding group membership message from core", zap.Error(fleet.ErrSchemaMalformed))
+			return
+		}
+		a.handleGroupMembership(r.Payload)
+		a.acknowledgeRequest(groupMembershipRequest, &a.groupRequestSucceeded)
+	case fleet.AgentPolicyRPCFunc:
+		var r fleet.AgentPolicyRPC
+		if err := json.Unmarshal(message.Payload(), &r); err != nil {
+			a.logger.Error("error decoding agent policy message from core", zap.Error(fleet.ErrSchemaMalformed))
+			return
+		}
+		a.handleAgentPolicies(r.Payload, r.FullList)
+		a.acknowledgeRequest(agentPoliciesRequest, &a.policyRequestSucceeded)
+	case fleet.DatasetRemovedRPCFunc:
+		var r fleet.DatasetRemovedRPC
+		if err := json.Unmarshal(message.Payload(), &r); err != nil {
+			a.logger.Error("error decoding dataset removal message from core", zap.Error(fleet.ErrSchemaMalformed))
+			return
+		}
+		a.handleDatasetRemoval(r.Payload)
+	case fleet.AgentStopRPCFunc:
+		var r fleet.AgentStopRPC
+		if err := json.Unmarshal(message.Payload(), &r); err != nil {
+			a.logger.Error("error decoding agent stop message from core", zap.Error(fleet.ErrSchemaMalformed))
+			return
+		}
+		a.handleAgentStop(ctx, r.Payload)
+	case fleet.AgentResetRPCFunc:
+		var r fleet.AgentResetRPC
+		if err := json.Unmarshal(message.Payload(), &r); err != nil {
+			a.logger.Error("error decoding agent reset message from core", zap.Error(fleet.ErrSchemaMalformed))
+			return
+		}
+		a.handleAgentReset(ctx, r.Payload)
+	default:
+		a.logger.Warn("unsupported/unhandled core RPC, ignoring",
+			zap.String("func", rpc.Func),
+			zap.Any("payload", redact.Data(rpc.Payload)))
+	}
+}
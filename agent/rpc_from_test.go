@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/orb-community/orb/fleet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/policies"
+)
+
+type fakeMessage struct {
+	mqtt.Message
+	topic   string
+	payload []byte
+}
+
+func (m *fakeMessage) Topic() string   { return m.topic }
+func (m *fakeMessage) Payload() []byte { return m.payload }
+
+type fakePolicyManager struct {
+	repo    policies.PolicyRepo
+	managed []fleet.AgentPolicyRPCPayload
+	removed []string
+}
+
+func (f *fakePolicyManager) ManagePolicy(payload fleet.AgentPolicyRPCPayload) {
+	f.managed = append(f.managed, payload)
+	_ = f.repo.Update(policies.PolicyData{
+		ID:       payload.ID,
+		Name:     payload.Name,
+		Backend:  payload.Backend,
+		Version:  payload.Version,
+		Data:     payload.Data,
+		State:    policies.Running,
+		Datasets: map[string]bool{payload.DatasetID: true},
+	})
+}
+
+func (f *fakePolicyManager) RemovePolicyDataset(_ string, _ string, _ backend.Backend) {}
+
+func (f *fakePolicyManager) GetPolicyState() ([]policies.PolicyData, error) {
+	return f.repo.GetAll()
+}
+
+func (f *fakePolicyManager) GetRepo() policies.PolicyRepo {
+	return f.repo
+}
+
+func (f *fakePolicyManager) ApplyBackendPolicies(_ string) error {
+	return nil
+}
+
+func (f *fakePolicyManager) RemoveBackendPolicies(_ string, _ bool) error {
+	return nil
+}
+
+func (f *fakePolicyManager) RestorePolicies() error {
+	return nil
+}
+
+func (f *fakePolicyManager) RemovePolicy(policyID string, _ string, _ string) error {
+	f.removed = append(f.removed, policyID)
+	return f.repo.Remove(policyID)
+}
+
+func newFakePolicyManager(t *testing.T, plcies ...policies.PolicyData) *fakePolicyManager {
+	repo, err := policies.NewMemRepo(zap.NewNop())
+	require.NoError(t, err)
+	for _, p := range plcies {
+		require.NoError(t, repo.Update(p))
+	}
+	return &fakePolicyManager{repo: repo}
+}
+
+func Test_orbAgent_handleAgentPolicies(t *testing.T) {
+	pm := newFakePolicyManager(t,
+		policies.PolicyData{ID: "kept", Name: "kept", Backend: "pktvisor"},
+		policies.PolicyData{ID: "stale", Name: "stale", Backend: "pktvisor"},
+	)
+	a := orbAgent{logger: zap.NewNop(), policyManager: pm, heartbeatCtx: context.Background()}
+
+	a.handleAgentPolicies([]fleet.AgentPolicyRPCPayload{
+		{Action: "manage", ID: "kept", Name: "kept", Backend: "pktvisor"},
+		{Action: "manage", ID: "new", Name: "new", Backend: "pktvisor"},
+		{Action: sanitizeAction, ID: "sanitized", Name: "sanitized", Backend: "pktvisor"},
+	}, true)
+
+	assert.Equal(t, []string{"stale"}, pm.removed)
+	require.Len(t, pm.managed, 2)
+	assert.Equal(t, "kept", pm.managed[0].ID)
+	assert.Equal(t, "new", pm.managed[1].ID)
+}
+
+func Test_orbAgent_ensureHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := &orbAgent{logger: zap.NewNop(), asyncContext: ctx}
+	a.logonWithHeartbeat()
+
+	// the routine clears its context once cancelled, while RPCs may start a new one
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			a.logoffWithHeartbeat(ctx)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			a.ensureHeartbeat()
+		}
+	}()
+	wg.Wait()
+
+	a.ensureHeartbeat()
+	a.heartbeatMutex.Lock()
+	done := a.heartbeatDone
+	a.heartbeatMutex.Unlock()
+	a.logoffWithHeartbeat(ctx)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("heartbeat routine did not stop")
+	}
+}
+
+func Test_orbAgent_handleAgentGroupRemoval(t *testing.T) {
+	pm := newFakePolicyManager(t,
+		policies.PolicyData{ID: "only", Name: "only", Backend: "pktvisor", GroupIDs: map[string]bool{"g1": true}},
+		policies.PolicyData{ID: "shared", Name: "shared", Backend: "pktvisor", GroupIDs: map[string]bool{"g1": true, "g2": true}},
+		policies.PolicyData{ID: "other", Name: "other", Backend: "pktvisor", GroupIDs: map[string]bool{"g2": true}},
+	)
+	a := orbAgent{logger: zap.NewNop(), policyManager: pm, client: &fakeMQTTClient{}, groupsInfos: map[string]groupInfo{}}
+
+	a.handleAgentGroupRemoval(fleet.GroupRemovedRPCPayload{AgentGroupID: "g1", ChannelID: "c1"})
+
+	assert.Equal(t, []string{"only"}, pm.removed)
+	shared, err := pm.repo.Get("shared")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"g2": true}, shared.GroupIDs)
+}
+
+func Test_orbAgent_handleRPCs_order(t *testing.T) {
+	pm := newFakePolicyManager(t)
+	a := &orbAgent{logger: zap.NewNop(), policyManager: pm, heartbeatCtx: context.Background(), rpcs: newRPCQueue()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.asyncContext = ctx
+
+	const count = 50
+	for i := 0; i < count; i++ {
+		payload, err := json.Marshal(fleet.AgentPolicyRPC{
+			SchemaVersion: fleet.CurrentRPCSchemaVersion,
+			Func:          fleet.AgentPolicyRPCFunc,
+			Payload:       []fleet.AgentPolicyRPCPayload{{Action: "manage", ID: fmt.Sprintf("policy-%d", i), Backend: "pktvisor"}},
+		})
+		require.NoError(t, err)
+		// the callbacks of the agent and group channels share the queue
+		if i%2 == 0 {
+			a.handleRPCFromCore(nil, &fakeMessage{topic: "agent", payload: payload})
+		} else {
+			a.handleGroupRPCFromCore(nil, &fakeMessage{topic: "group", payload: payload})
+		}
+	}
+	go a.handleRPCs(ctx)
+
+	require.Eventually(t, func() bool {
+		a.rpcMutex.Lock()
+		defer a.rpcMutex.Unlock()
+		return len(pm.managed) == count
+	}, 5*time.Second, 10*time.Millisecond)
+	for i, payload := range pm.managed {
+		assert.Equal(t, fmt.Sprintf("policy-%d", i), payload.ID)
+	}
+}
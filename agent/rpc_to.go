@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"encoding/json"
+	"runtime"
+
+	"github.com/orb-community/orb/fleet"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/version"
+)
+
+// agentInfo extends fleet.OrbAgentInfo with build and platform details
+type agentInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+}
+
+// capabilities is wire compatible with fleet.Capabilities
+type capabilities struct {
+	SchemaVersion string                       `json:"schema_version"`
+	OrbAgent      agentInfo                    `json:"orb_agent"`
+	AgentTags     map[string]string            `json:"agent_tags"`
+	Backends      map[string]fleet.BackendInfo `json:"backends"`
+}
+
+func (a *orbAgent) getCapabilities() capabilities {
+	caps := capabilities{
+		SchemaVersion: fleet.CurrentCapabilitiesSchemaVersion,
+		AgentTags:     a.config.OrbAgent.Tags,
+		OrbAgent: agentInfo{
+			Version: version.GetBuildVersion(),
+			Commit:  version.GetBuildCommit(),
+			OS:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+		},
+		Backends: make(map[string]fleet.BackendInfo, len(a.backends)),
+	}
+
+	for name, be := range a.backends {
+		ver, err := be.Version()
+		if err != nil {
+			a.logger.Error("backend failed to retrieve version, skipping", zap.String("backend", name), zap.Error(err))
+			continue
+		}
+		cp, err := be.GetCapabilities()
+		if err != nil {
+			a.logger.Error("backend failed to retrieve capabilities, skipping", zap.String("backend", name), zap.Error(err))
+			continue
+		}
+		caps.Backends[name] = fleet.BackendInfo{
+			Version: ver,
+			Data:    cp,
+		}
+	}
+	return caps
+}
+
+func (a *orbAgent) sendCapabilities() error {
+	body, err := json.Marshal(a.getCapabilities())
+	if err != nil {
+		a.logger.Error("failed to marshal capabilities, skipping", zap.Error(err))
+		return err
+	}
+
+	a.logger.Info("sending capabilities", zap.ByteString("value", body))
+	if token := a.client.Publish(a.capabilitiesTopic, 1, false, body); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+func (a *orbAgent) publishRPCToCore(funcName string, payload interface{}) error {
+	data := fleet.RPC{
+		SchemaVersion: fleet.CurrentRPCSchemaVersion,
+		Func:          funcName,
+		Payload:       payload,
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if token := a.client.Publish(a.rpcToCoreTopic, 1, false, body); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+func (a *orbAgent) sendGroupMembershipRequest() error {
+	a.logger.Debug("sending group membership request")
+	return a.publishRPCToCore(fleet.GroupMembershipReqRPCFunc, fleet.GroupMembershipReqRPCPayload{})
+}
+
+// sendGroupMembershipReq requests group membership, re-sending until core responds
+func (a *orbAgent) sendGroupMembershipReq() error {
+	return a.sendWithRetry(groupMembershipRequest, &a.groupRequestSucceeded, a.sendGroupMembershipRequest)
+}
+
+func (a *orbAgent) sendAgentPoliciesRequest() error {
+	a.logger.Debug("sending agent policies request")
+	return a.publishRPCToCore(fleet.AgentPoliciesReqRPCFunc, fleet.AgentPoliciesReqRPCPayload{})
+}
+
+// sendAgentPoliciesReq requests agent policies, re-sending until core responds
+func (a *orbAgent) sendAgentPoliciesReq() error {
+	return a.sendWithRetry(agentPoliciesRequest, &a.policyRequestSucceeded, a.sendAgentPoliciesRequest)
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/config"
+	"github.com/netboxlabs/orb-agent/agent/policies"
+	"github.com/netboxlabs/orb-agent/agent/version"
+)
+
+type fakeBackend struct {
+	version    string
+	versionErr error
+	caps       map[string]interface{}
+}
+
+var _ backend.Backend = (*fakeBackend)(nil)
+
+func (f *fakeBackend) Configure(_ *zap.Logger, _ policies.PolicyRepo, _ map[string]interface{}, _ config.BackendCommons) error {
+	return nil
+}
+func (f *fakeBackend) SetCommsClient(_ string, _ *mqtt.Client, _ string) {}
+func (f *fakeBackend) Version() (string, error)                          { return f.version, f.versionErr }
+func (f *fakeBackend) Start(_ context.Context, _ context.CancelFunc) error {
+	return nil
+}
+func (f *fakeBackend) Stop(_ context.Context) error                     { return nil }
+func (f *fakeBackend) FullReset(_ context.Context) error                { return nil }
+func (f *fakeBackend) GetStartTime() time.Time                          { return time.Time{} }
+func (f *fakeBackend) GetCapabilities() (map[string]interface{}, error) { return f.caps, nil }
+func (f *fakeBackend) GetRunningStatus() (backend.RunningStatus, string, error) {
+	return backend.Running, "", nil
+}
+func (f *fakeBackend) GetInitialState() backend.RunningStatus          { return backend.Unknown }
+func (f *fakeBackend) ApplyPolicy(_ policies.PolicyData, _ bool) error { return nil }
+func (f *fakeBackend) RemovePolicy(_ policies.PolicyData) error        { return nil }
+
+func Test_orbAgent_sendCapabilities(t *testing.T) {
+	client := &fakeMQTTClient{}
+	a := orbAgent{
+		logger: zap.NewNop(),
+		client: client,
+		backends: map[string]backend.Backend{
+			"pktvisor": &fakeBackend{version: "4.4.0", caps: map[string]interface{}{"taps": []string{"default_pcap"}}},
+			"broken":   &fakeBackend{versionErr: errors.New("not running")},
+		},
+	}
+	a.config.OrbAgent.Tags = map[string]string{"region": "eu"}
+	a.nameAgentRPCTopics("chan-1")
+
+	require.NoError(t, a.sendCapabilities())
+
+	published := client.publishes()
+	require.Len(t, published, 1)
+	assert.Equal(t, a.capabilitiesTopic, published[0].topic)
+
+	var got capabilities
+	require.NoError(t, json.Unmarshal(published[0].payload, &got))
+	assert.Equal(t, version.GetBuildVersion(), got.OrbAgent.Version)
+	assert.Equal(t, version.GetBuildCommit(), got.OrbAgent.Commit)
+	assert.Equal(t, runtime.GOOS, got.OrbAgent.OS)
+	assert.Equal(t, runtime.GOARCH, got.OrbAgent.Arch)
+	assert.Equal(t, map[string]string{"region": "eu"}, got.AgentTags)
+	require.Contains(t, got.Backends, "pktvisor")
+	assert.NotContains(t, got.Backends, "broken")
+	assert.Equal(t, "4.4.0", got.Backends["pktvisor"].Version)
+	assert.Equal(t, []interface{}{"default_pcap"}, got.Backends["pktvisor"].Data["taps"])
+}
@@ -0,0 +1,223 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/config"
+	"github.com/netboxlabs/orb-agent/agent/redact"
+)
+
+// ErrNotFound is returned by a provider when the referenced secret does not exist
+var ErrNotFound = errors.New("secret not found")
+
+// Provider looks up the value of a secret reference, e.g. the name of an environment variable for the env provider
+type Provider interface {
+	Lookup(ref string) (string, error)
+}
+
+// ProviderFunc adapts a function to the Provider interface
+type ProviderFunc func(ref string) (string, error)
+
+// Lookup calls f(ref)
+func (f ProviderFunc) Lookup(ref string) (string, error) {
+	return f(ref)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Provider{
+		"env":  ProviderFunc(lookupEnv),
+		"file": ProviderFunc(lookupFile),
+	}
+)
+
+// Register registers a provider for the references using the given scheme, e.g. ${scheme:ref}
+func Register(scheme string, p Provider) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[scheme] = p
+}
+
+func getProvider(scheme string) (Provider, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	p, ok := registry[scheme]
+	return p, ok
+}
+
+func lookupEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return "", fmt.Errorf("environment variable %s: %w", name, ErrNotFound)
+	}
+	return value, nil
+}
+
+func lookupFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("file %s: %w", path, ErrNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+	// secret files are usually written with a trailing newline
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// Resolver replaces secret references in configuration values:
+//
+//	${env:VAR}              value of the environment variable VAR
+//	${VAR}                  same as ${env:VAR}
+//	${file:/run/secrets/x}  content of the file, without trailing newlines
+//	${env:VAR:-default}     default used when the reference cannot be resolved
+//	$${...}                 literal ${...}
+//
+// In strict mode an unresolvable reference without default is an error, otherwise it is logged and kept as is.
+// Resolved values are registered for redaction from the logs.
+type Resolver struct {
+	logger *zap.Logger
+	strict bool
+}
+
+// NewResolver creates a resolver using the registered providers
+func NewResolver(logger *zap.Logger, strict bool) *Resolver {
+	return &Resolver{logger: logger, strict: strict}
+}
+
+// resolveRef resolves the content of a ${...} reference
+func (r *Resolver) resolveRef(ref string) (string, error) {
+	ref, def, hasDefault := strings.Cut(ref, ":-")
+	scheme, name, ok := strings.Cut(ref, ":")
+	if !ok {
+		scheme, name = "env", ref
+	}
+	provider, ok := getProvider(scheme)
+	if !ok {
+		if hasDefault {
+			return def, nil
+		}
+		return "", fmt.Errorf("unknown secret provider %q in ${%s}", scheme, ref)
+	}
+	value, err := provider.Lookup(name)
+	if err != nil {
+		if hasDefault {
+			return def, nil
+		}
+		return "", fmt.Errorf("failed to resolve ${%s}: %w", ref, err)
+	}
+	redact.AddValue(value)
+	return value, nil
+}
+
+// ResolveString replaces every reference found in s
+func (r *Resolver) ResolveString(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	var errs []error
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			b.WriteString(s)
+			break
+		}
+		if start > 0 && s[start-1] == '$' {
+			// escaped reference
+			b.WriteString(s[:start-1])
+			b.WriteString("${")
+			s = s[start+2:]
+			continue
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:start])
+		ref := s[start : start+end+1]
+		value, err := r.resolveRef(ref[2 : len(ref)-1])
+		if err != nil {
+			if r.strict {
+				errs = append(errs, err)
+			} else if r.logger != nil {
+				r.logger.Warn("unresolved secret reference, keeping it as is", zap.Error(err))
+			}
+			value = ref
+		}
+		b.WriteString(value)
+		s = s[start+end+1:]
+	}
+	if len(errs) > 0 {
+		return "", errors.Join(errs...)
+	}
+	return b.String(), nil
+}
+
+// ResolveData returns a copy of data, e.g. the data of a policy, with the references found in its strings replaced
+func (r *Resolver) ResolveData(data interface{}) (interface{}, error) {
+	switch v := data.(type) {
+	case string:
+		return r.ResolveString(v)
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			resolved, err := r.ResolveData(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			ret[key] = resolved
+		}
+		return ret, nil
+	case map[interface{}]interface{}:
+		ret := make(map[interface{}]interface{}, len(v))
+		for key, value := range v {
+			resolved, err := r.ResolveData(value)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", key, err)
+			}
+			ret[key] = resolved
+		}
+		return ret, nil
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, value := range v {
+			resolved, err := r.ResolveData(value)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			ret[i] = resolved
+		}
+		return ret, nil
+	default:
+		return data, nil
+	}
+}
+
+// ResolveCommons returns a copy of the settings shared by the backends with their references replaced
+func (r *Resolver) ResolveCommons(c config.BackendCommons) (config.BackendCommons, error) {
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"otel.host", &c.Otel.Host},
+		{"diode.target", &c.Diode.Target},
+		{"diode.api_key", &c.Diode.APIKey},
+		{"diode.agent_name", &c.Diode.AgentName},
+	}
+	for _, f := range fields {
+		resolved, err := r.ResolveString(*f.value)
+		if err != nil {
+			return c, fmt.Errorf("common.%s: %w", f.name, err)
+		}
+		*f.value = resolved
+	}
+	return c, nil
+}
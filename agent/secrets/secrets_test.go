@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/config"
+)
+
+func TestResolver_ResolveString(t *testing.T) {
+	t.Setenv("ORB_TEST_PASSWORD", "s3cr3t-pass")
+	secretFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(secretFile, []byte("file-token\n"), 0o600))
+
+	r := NewResolver(zap.NewNop(), true)
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", "plain"},
+		{"${env:ORB_TEST_PASSWORD}", "s3cr3t-pass"},
+		{"${ORB_TEST_PASSWORD}", "s3cr3t-pass"},
+		{"Bearer ${file:" + secretFile + "}", "Bearer file-token"},
+		{"${env:ORB_TEST_MISSING:-fallback}", "fallback"},
+		{"${file:/nonexistent/secret:-}", ""},
+		{"$${env:ORB_TEST_PASSWORD}", "${env:ORB_TEST_PASSWORD}"},
+		{"${unterminated", "${unterminated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := r.ResolveString(tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestResolver_Strict(t *testing.T) {
+	strict := NewResolver(zap.NewNop(), true)
+	_, err := strict.ResolveString("${env:ORB_TEST_MISSING}")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorContains(t, err, "ORB_TEST_MISSING")
+
+	_, err = strict.ResolveString("${vault:secret/data}")
+	assert.ErrorContains(t, err, `unknown secret provider "vault"`)
+
+	lenient := NewResolver(zap.NewNop(), false)
+	got, err := lenient.ResolveString("user:${env:ORB_TEST_MISSING}")
+	require.NoError(t, err)
+	assert.Equal(t, "user:${env:ORB_TEST_MISSING}", got)
+}
+
+func TestResolver_ResolveData(t *testing.T) {
+	t.Setenv("ORB_TEST_DEVICE_PASSWORD", "device-pass")
+	data := map[string]interface{}{
+		"config": map[string]interface{}{"schedule": "* * * * *", "timeout": 5},
+		"scope": []interface{}{
+			map[string]interface{}{"hostname": "192.168.0.5", "password": "${env:ORB_TEST_DEVICE_PASSWORD}"},
+		},
+	}
+
+	resolved, err := NewResolver(zap.NewNop(), true).ResolveData(data)
+	require.NoError(t, err)
+	device := resolved.(map[string]interface{})["scope"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "device-pass", device["password"])
+	assert.Equal(t, 5, resolved.(map[string]interface{})["config"].(map[string]interface{})["timeout"])
+	// the original data keeps the reference
+	original := data["scope"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "${env:ORB_TEST_DEVICE_PASSWORD}", original["password"])
+
+	data["scope"].([]interface{})[0].(map[string]interface{})["username"] = "${env:ORB_TEST_MISSING}"
+	_, err = NewResolver(zap.NewNop(), true).ResolveData(data)
+	assert.ErrorContains(t, err, "scope: [0]: username:")
+}
+
+func TestResolver_ResolveCommons(t *testing.T) {
+	t.Setenv("ORB_TEST_DIODE_KEY", "diode-api-key")
+	var c config.BackendCommons
+	c.Diode.Target = "grpc://localhost:8080/diode"
+	c.Diode.APIKey = "${env:ORB_TEST_DIODE_KEY}"
+
+	resolved, err := NewResolver(zap.NewNop(), true).ResolveCommons(c)
+	require.NoError(t, err)
+	assert.Equal(t, "diode-api-key", resolved.Diode.APIKey)
+	assert.Equal(t, "grpc://localhost:8080/diode", resolved.Diode.Target)
+
+	c.Diode.APIKey = "${env:ORB_TEST_MISSING}"
+	_, err = NewResolver(zap.NewNop(), true).ResolveCommons(c)
+	assert.ErrorContains(t, err, "common.diode.api_key")
+}
+
+func TestRegister(t *testing.T) {
+	Register("test", ProviderFunc(func(ref string) (string, error) {
+		return "value-of-" + ref, nil
+	}))
+	got, err := NewResolver(zap.NewNop(), true).ResolveString("${test:key}")
+	require.NoError(t, err)
+	assert.Equal(t, "value-of-key", got)
+}
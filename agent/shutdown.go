@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+)
+
+const (
+	// how long the backends killed at the shutdown deadline are waited for
+	shutdownKillGrace = 5 * time.Second
+	// milliseconds the broker connection is given to complete pending work on disconnect
+	disconnectQuiesce = 250
+)
+
+// shutdownSummary tells how each backend stopped
+type shutdownSummary struct {
+	stopped []string
+	// stopped by killing their processes, still running after their stop timeout or at the shutdown deadline
+	killed []string
+	failed []string
+	// not stopped at the shutdown deadline, even after being killed
+	stillRunning []string
+}
+
+// drainBackends removes the policies of every backend, keeping them in the repository, then stops the backends
+// concurrently and waits for their processes to exit. Once ctx is done, backends still stopping kill their
+// processes.
+func (a *orbAgent) drainBackends(ctx context.Context) shutdownSummary {
+	for name := range a.backends {
+		if ctx.Err() != nil {
+			a.logger.Warn("shutdown deadline reached, not removing the remaining policies")
+			break
+		}
+		a.logger.Info("removing policies", zap.String("backend", name))
+		if err := a.policyManager.RemoveBackendPolicies(name, false); err != nil {
+			a.logger.Error("failed to remove policies", zap.String("backend", name), zap.Error(err))
+		}
+	}
+
+	var mu sync.Mutex
+	var summary shutdownSummary
+	pending := make(map[string]bool, len(a.backends))
+	for name := range a.backends {
+		pending[name] = true
+	}
+	var wg sync.WaitGroup
+	for name, be := range a.backends {
+		wg.Add(1)
+		go func(name string, be backend.Backend) {
+			defer wg.Done()
+			a.logger.Debug("stopping backend", zap.String("backend", name))
+			err := be.Stop(ctx)
+			a.updateBackendState(name, func(state *backend.State) {
+				state.Status = backend.Offline
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			delete(pending, name)
+			switch {
+			case err != nil:
+				a.logger.Error("error while stopping the backend", zap.String("backend", name), zap.Error(err))
+				summary.failed = append(summary.failed, name)
+			case killedOnStop(ctx, be):
+				summary.killed = append(summary.killed, name)
+			default:
+				summary.stopped = append(summary.stopped, name)
+			}
+		}(name, be)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		select {
+		case <-done:
+		case <-time.After(shutdownKillGrace):
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for name := range pending {
+		summary.stillRunning = append(summary.stillRunning, name)
+	}
+	for _, names := range [][]string{summary.stopped, summary.killed, summary.failed, summary.stillRunning} {
+		sort.Strings(names)
+	}
+	return summary
+}
+
+// killedOnStop tells whether stopping the backend killed its processes. Backends not reporting it are deemed killed
+// when they stopped past the shutdown deadline.
+func killedOnStop(ctx context.Context, be backend.Backend) bool {
+	if reporter, ok := be.(backend.StopReporter); ok {
+		return reporter.Killed()
+	}
+	return ctx.Err() != nil
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/orb-community/orb/fleet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/config"
+	"github.com/netboxlabs/orb-agent/agent/policies"
+	manager "github.com/netboxlabs/orb-agent/agent/policyMgr"
+)
+
+// slowStopBackend only stops once the shutdown deadline kills it
+type slowStopBackend struct {
+	fakeBackend
+}
+
+func (s *slowStopBackend) Stop(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+// killedStopBackend stops before the shutdown deadline, having killed its process after its stop timeout
+type killedStopBackend struct {
+	fakeBackend
+}
+
+func (k *killedStopBackend) Killed() bool { return true }
+
+type failingStopBackend struct {
+	fakeBackend
+}
+
+func (f *failingStopBackend) Stop(_ context.Context) error {
+	return errors.New("stop failed")
+}
+
+func Test_orbAgent_drainBackends(t *testing.T) {
+	pm, err := manager.New(zap.NewNop(), config.Config{})
+	require.NoError(t, err)
+	a := &orbAgent{
+		logger:        zap.NewNop(),
+		policyManager: pm,
+		backends: map[string]backend.Backend{
+			"drain_test_fast":    &fakeBackend{},
+			"drain_test_slow":    &slowStopBackend{},
+			"drain_test_killed":  &killedStopBackend{},
+			"drain_test_failing": &failingStopBackend{},
+		},
+		backendState: map[string]*backend.State{"drain_test_fast": {Status: backend.Running}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	summary := a.drainBackends(ctx)
+
+	assert.Equal(t, []string{"drain_test_fast"}, summary.stopped)
+	assert.Equal(t, []string{"drain_test_killed", "drain_test_slow"}, summary.killed)
+	assert.Equal(t, []string{"drain_test_failing"}, summary.failed)
+	assert.Empty(t, summary.stillRunning)
+	state, _ := a.getBackendState("drain_test_fast")
+	assert.Equal(t, backend.Offline, state.Status)
+}
+
+func Test_orbAgent_Stop(t *testing.T) {
+	be := &recordingBackend{}
+	backend.Register("stop_test", be)
+	pm, err := manager.New(zap.NewNop(), config.Config{})
+	require.NoError(t, err)
+	require.NoError(t, pm.GetRepo().Update(policies.PolicyData{ID: "p1", Name: "policy_1", Backend: "stop_test", Version: 1, State: policies.Running}))
+	client := &fakeMQTTClient{}
+	cancelled := 0
+	a := &orbAgent{
+		logger:         zap.NewNop(),
+		policyManager:  pm,
+		client:         client,
+		backends:       map[string]backend.Backend{"stop_test": be},
+		backendState:   map[string]*backend.State{"stop_test": {Status: backend.Running}},
+		cancelFunction: func() { cancelled++ },
+	}
+	a.config.OrbAgent.Timing.ShutdownTimeout = time.Second
+	a.nameAgentRPCTopics("chan-1")
+
+	a.Stop(context.Background())
+	a.Stop(context.Background())
+
+	assert.Equal(t, []string{"remove p1"}, be.calls)
+	_, err = pm.GetRepo().Get("p1")
+	assert.NoError(t, err, "the policies are kept to be restored on the next start")
+	assert.Equal(t, 1, cancelled)
+	assert.True(t, client.disconnected)
+	published := client.publishes()
+	require.Len(t, published, 1)
+	assert.Equal(t, a.heartbeatsTopic, published[0].topic)
+	var hb fleet.Heartbeat
+	require.NoError(t, json.Unmarshal(published[0].payload, &hb))
+	assert.Equal(t, fleet.Offline, hb.State)
+	assert.Equal(t, backend.Offline.String(), hb.BackendState["stop_test"].State)
+}
@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"github.com/netboxlabs/orb-agent/agent/backend"
+)
+
+// getBackendState returns a copy of the tracked state of the named backend
+func (a *orbAgent) getBackendState(name string) (backend.State, bool) {
+	a.backendStateMutex.RLock()
+	defer a.backendStateMutex.RUnlock()
+	state, ok := a.backendState[name]
+	if !ok {
+		return backend.State{}, false
+	}
+	return *state, true
+}
+
+// updateBackendState applies fn to the tracked state of the named backend, if any
+func (a *orbAgent) updateBackendState(name string, fn func(state *backend.State)) {
+	a.backendStateMutex.Lock()
+	defer a.backendStateMutex.Unlock()
+	if state, ok := a.backendState[name]; ok {
+		fn(state)
+	}
+}
+
+// setBackendState replaces the tracked state of the named backend
+func (a *orbAgent) setBackendState(name string, state backend.State) {
+	a.backendStateMutex.Lock()
+	defer a.backendStateMutex.Unlock()
+	a.backendState[name] = &state
+}
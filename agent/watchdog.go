@@ -0,0 +1,213 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/backend/supervisor"
+	"github.com/netboxlabs/orb-agent/agent/config"
+)
+
+// backendEvent asks the watchdog to restart a backend
+type backendEvent struct {
+	name   string
+	reason string
+	// exit is set when the backend process exited, as opposed to a backend found not running by the heartbeat
+	exit   bool
+	failed bool
+}
+
+// watchdog restarts backends according to the restart policy, as soon as their process exits, with the jittered
+// backoff of the supervisor between the restarts of a backend
+type watchdog struct {
+	policy  config.RestartPolicyConfig
+	backoff supervisor.RestartPolicy
+	// signaled when events are pending
+	wakeup chan struct{}
+
+	mu sync.Mutex
+	// events waiting to be handled, at most one per backend, in their order of arrival
+	pending map[string]backendEvent
+	queue   []string
+
+	restarts map[string][]time.Time
+	// backends the watchdog decided not to restart, with the reason, until they are restarted otherwise
+	givenUp map[string]string
+	// backends whose restart is waiting for its backoff
+	scheduled map[string]bool
+}
+
+func newWatchdog(policy config.RestartPolicyConfig) *watchdog {
+	return &watchdog{
+		policy:    policy.WithDefaults(),
+		backoff:   supervisor.RestartPolicy{}.WithDefaults(),
+		wakeup:    make(chan struct{}, 1),
+		pending:   make(map[string]backendEvent),
+		restarts:  make(map[string][]time.Time),
+		givenUp:   make(map[string]string),
+		scheduled: make(map[string]bool),
+	}
+}
+
+// notify queues an event without blocking its sender. Events for a backend with an event already pending are
+// coalesced with it, a process exit taking precedence over a heartbeat failure.
+func (w *watchdog) notify(ev backendEvent) {
+	w.mu.Lock()
+	if pending, ok := w.pending[ev.name]; !ok {
+		w.queue = append(w.queue, ev.name)
+		w.pending[ev.name] = ev
+	} else if ev.exit || !pending.exit {
+		w.pending[ev.name] = ev
+	}
+	w.mu.Unlock()
+	select {
+	case w.wakeup <- struct{}{}:
+	default:
+		// the watchdog is already signaled and takes every pending event
+	}
+}
+
+// take returns the pending events in their order of arrival
+func (w *watchdog) take() []backendEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	events := make([]backendEvent, 0, len(w.queue))
+	for _, name := range w.queue {
+		events = append(events, w.pending[name])
+		delete(w.pending, name)
+	}
+	w.queue = nil
+	return events
+}
+
+// decide tells whether the backend should be restarted, and why not otherwise
+func (w *watchdog) decide(ev backendEvent, now time.Time) (bool, string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if reason, ok := w.givenUp[ev.name]; ok && !ev.exit {
+		return false, reason
+	}
+
+	var reason string
+	switch {
+	case w.policy.Condition == config.RestartNever:
+		reason = "restart policy is never"
+	case w.policy.Condition == config.RestartOnFailure && !ev.failed:
+		reason = "backend exited successfully and restart policy is on-failure"
+	}
+	if reason == "" {
+		recent := w.restarts[ev.name][:0]
+		for _, t := range w.restarts[ev.name] {
+			if now.Sub(t) < w.policy.Window {
+				recent = append(recent, t)
+			}
+		}
+		w.restarts[ev.name] = recent
+		if len(recent) >= w.policy.MaxRestarts {
+			reason = fmt.Sprintf("restart limit reached: %d restarts within %s", len(recent), w.policy.Window)
+		}
+	}
+	if reason != "" {
+		w.givenUp[ev.name] = reason
+		return false, reason
+	}
+	w.restarts[ev.name] = append(w.restarts[ev.name], now)
+	return true, ""
+}
+
+// schedule marks the restart of the backend as waiting for its backoff, which is returned
+func (w *watchdog) schedule(name string) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.scheduled[name] = true
+	// decide recorded this restart already
+	return w.backoff.Backoff(max(len(w.restarts[name])-1, 0))
+}
+
+func (w *watchdog) unschedule(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.scheduled, name)
+}
+
+func (w *watchdog) isScheduled(name string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.scheduled[name]
+}
+
+// reset forgets a previous decision not to restart the backend, once it was restarted otherwise
+func (w *watchdog) reset(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.givenUp, name)
+}
+
+// watchBackend registers the agent for the exits of the backend process, when the backend reports them
+func (a *orbAgent) watchBackend(name string, be backend.Backend) {
+	notifier, ok := be.(backend.ExitNotifier)
+	if !ok {
+		return
+	}
+	notifier.SetExitHandler(func(exit backend.ProcessExit) {
+		a.logger.Warn("backend process exited", zap.String("backend", name), zap.Int("exit_code", exit.Code), zap.String("reason", exit.Reason))
+		a.updateBackendState(name, func(state *backend.State) {
+			state.Status = backend.Offline
+			state.LastError = "process exited: " + exit.Reason
+		})
+		a.watchdog.notify(backendEvent{name: name, reason: "process exited: " + exit.Reason, exit: true, failed: exit.Failed()})
+	})
+}
+
+// runWatchdog restarts backends as the watchdog events come, until ctx is done
+func (a *orbAgent) runWatchdog(ctx context.Context) {
+	a.logger.Debug("start backend watchdog routine", zap.Any("routine", ctx.Value(routineKey)))
+	for {
+		select {
+		case <-ctx.Done():
+			a.logger.Debug("context done, stopping backend watchdog routine")
+			return
+		case <-a.watchdog.wakeup:
+			for _, ev := range a.watchdog.take() {
+				a.handleBackendEvent(ctx, ev)
+			}
+		}
+	}
+}
+
+// handleBackendEvent schedules the restart of the backend, if the restart policy allows it
+func (a *orbAgent) handleBackendEvent(ctx context.Context, ev backendEvent) {
+	if a.watchdog.isScheduled(ev.name) {
+		a.logger.Debug("backend restart already scheduled", zap.String("backend", ev.name), zap.String("event", ev.reason))
+		return
+	}
+	restart, reason := a.watchdog.decide(ev, time.Now())
+	if !restart {
+		a.logger.Warn("not restarting backend", zap.String("backend", ev.name), zap.String("event", ev.reason), zap.String("reason", reason))
+		a.updateBackendState(ev.name, func(state *backend.State) {
+			state.LastError = fmt.Sprintf("%s, not restarted: %s", ev.reason, reason)
+		})
+		return
+	}
+	delay := a.watchdog.schedule(ev.name)
+	a.logger.Info("attempting backend restart", zap.String("backend", ev.name), zap.String("reason", ev.reason), zap.Duration("backoff", delay))
+	go a.restartAfter(ctx, ev, delay)
+}
+
+// restartAfter restarts the backend once its backoff elapsed, unless ctx is done first
+func (a *orbAgent) restartAfter(ctx context.Context, ev backendEvent, delay time.Duration) {
+	defer a.watchdog.unschedule(ev.name)
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(delay):
+	}
+	if err := a.RestartBackend(a.configManager.GetContext(ctx), ev.name, ev.reason); err != nil {
+		a.logger.Error("failed to restart backend", zap.Error(err), zap.String("backend", ev.name))
+	}
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/config"
+)
+
+type fakeExitBackend struct {
+	fakeBackend
+	onExit func(backend.ProcessExit)
+	resets atomic.Int32
+}
+
+var _ backend.ExitNotifier = (*fakeExitBackend)(nil)
+
+func (f *fakeExitBackend) SetExitHandler(handler func(backend.ProcessExit)) { f.onExit = handler }
+func (f *fakeExitBackend) FullReset(_ context.Context) error {
+	f.resets.Add(1)
+	return nil
+}
+
+func Test_watchdog_decide(t *testing.T) {
+	now := time.Now()
+	crash := backendEvent{name: "pktvisor", reason: "process exited: exit code 1", exit: true, failed: true}
+	clean := backendEvent{name: "pktvisor", reason: "process exited: exit code 0", exit: true}
+	heartbeat := backendEvent{name: "pktvisor", reason: "failed during heartbeat", failed: true}
+
+	w := newWatchdog(config.RestartPolicyConfig{})
+	restart, _ := w.decide(crash, now)
+	assert.True(t, restart, "on-failure restarts crashed backends")
+	restart, reason := w.decide(clean, now)
+	assert.False(t, restart, "on-failure does not restart backends exiting successfully")
+	assert.Contains(t, reason, "on-failure")
+	restart, _ = w.decide(heartbeat, now)
+	assert.False(t, restart, "the heartbeat does not restart a backend the watchdog gave up on")
+	w.reset("pktvisor")
+	restart, _ = w.decide(heartbeat, now)
+	assert.True(t, restart)
+
+	w = newWatchdog(config.RestartPolicyConfig{Condition: config.RestartAlways, MaxRestarts: 2, Window: time.Minute})
+	restart, _ = w.decide(clean, now)
+	assert.True(t, restart)
+	restart, _ = w.decide(crash, now.Add(time.Second))
+	assert.True(t, restart)
+	restart, reason = w.decide(crash, now.Add(2*time.Second))
+	assert.False(t, restart)
+	assert.Equal(t, "restart limit reached: 2 restarts within 1m0s", reason)
+	restart, _ = w.decide(crash, now.Add(2*time.Minute))
+	assert.True(t, restart, "restarts older than the window do not count")
+
+	w = newWatchdog(config.RestartPolicyConfig{Condition: config.RestartNever})
+	restart, _ = w.decide(crash, now)
+	assert.False(t, restart)
+}
+
+func Test_watchdog_schedule(t *testing.T) {
+	now := time.Now()
+	crash := backendEvent{name: "pktvisor", reason: "process exited: exit code 1", exit: true, failed: true}
+	w := newWatchdog(config.RestartPolicyConfig{})
+	w.backoff.InitialBackoff = time.Second
+
+	for n, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		restart, _ := w.decide(crash, now.Add(time.Duration(n)*time.Second))
+		require.True(t, restart)
+		delay := w.schedule(crash.name)
+		assert.GreaterOrEqual(t, delay, want*8/10, n)
+		assert.LessOrEqual(t, delay, want*12/10, n)
+		assert.True(t, w.isScheduled(crash.name))
+		w.unschedule(crash.name)
+	}
+}
+
+func Test_watchdog_notify(t *testing.T) {
+	w := newWatchdog(config.RestartPolicyConfig{})
+	for i := 0; i < 100; i++ {
+		w.notify(backendEvent{name: "pktvisor", reason: "failed during heartbeat", failed: true})
+	}
+	w.notify(backendEvent{name: "pktvisor", reason: "process exited: exit code 1", exit: true, failed: true})
+	w.notify(backendEvent{name: "pktvisor", reason: "failed during heartbeat", failed: true})
+	// an exit of another backend is never dropped, however many events are pending
+	w.notify(backendEvent{name: "network_discovery", reason: "process exited: exit code 2", exit: true, failed: true})
+
+	assert.Len(t, w.wakeup, 1)
+	events := w.take()
+	require.Len(t, events, 2)
+	assert.Equal(t, "pktvisor", events[0].name)
+	assert.True(t, events[0].exit, "an exit takes precedence over heartbeat failures")
+	assert.Equal(t, "network_discovery", events[1].name)
+	assert.Empty(t, w.take())
+}
+
+func Test_orbAgent_watchdogRestartsOnExit(t *testing.T) {
+	be := &fakeExitBackend{}
+	backend.Register("watchdog_test", be)
+	a := &orbAgent{
+		logger:        zap.NewNop(),
+		policyManager: newFakePolicyManager(t),
+		configManager: config.New(zap.NewNop(), config.ManagerConfig{Active: "local"}),
+		backends:      map[string]backend.Backend{"watchdog_test": be},
+		backendState:  map[string]*backend.State{"watchdog_test": {Status: backend.Running}},
+		watchdog:      newWatchdog(config.RestartPolicyConfig{MaxRestarts: 1}),
+	}
+	a.watchdog.backoff.InitialBackoff = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.watchBackend("watchdog_test", be)
+	go a.runWatchdog(ctx)
+
+	require.NotNil(t, be.onExit)
+	be.onExit(backend.ProcessExit{Code: 137, Reason: "exit code 137", Time: time.Now()})
+	require.Eventually(t, func() bool { return be.resets.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+	state, _ := a.getBackendState("watchdog_test")
+	assert.Equal(t, int64(1), state.RestartCount)
+	assert.Equal(t, "process exited: exit code 137", state.LastRestartReason)
+
+	be.onExit(backend.ProcessExit{Code: 1, Reason: "exit code 1", Time: time.Now()})
+	require.Eventually(t, func() bool {
+		state, _ := a.getBackendState("watchdog_test")
+		return state.LastError == "process exited: exit code 1, not restarted: restart limit reached: 1 restarts within 10m0s"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), be.resets.Load())
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -19,6 +20,7 @@ import (
 	"github.com/netboxlabs/orb-agent/agent/backend/otel"
 	"github.com/netboxlabs/orb-agent/agent/backend/pktvisor"
 	"github.com/netboxlabs/orb-agent/agent/config"
+	"github.com/netboxlabs/orb-agent/agent/redact"
 	"github.com/netboxlabs/orb-agent/agent/version"
 )
 
@@ -28,8 +30,9 @@ const (
 )
 
 var (
-	cfgFiles []string
-	debug    bool
+	cfgFiles    []string
+	debug       bool
+	watchConfig bool
 )
 
 func init() {
@@ -56,6 +59,7 @@ func Run(_ *cobra.Command, _ []string) {
 		cobra.CheckErr(fmt.Errorf("agent start up error (configData): %w", err))
 		os.Exit(1)
 	}
+	cobra.CheckErr(redact.SetKeys(configData.OrbAgent.Secrets.RedactKeys))
 
 	// logger
 	var logger *zap.Logger
@@ -72,12 +76,13 @@ func Run(_ *cobra.Command, _ []string) {
 		os.Stdout,
 		atomicLevel,
 	)
-	logger = zap.New(core, zap.AddCaller())
+	// credentials and resolved secret references are redacted from every log entry
+	logger = zap.New(redact.NewCore(core), zap.AddCaller())
 	defer func(logger *zap.Logger) {
 		_ = logger.Sync()
 	}(logger)
 
-	logger.Info("backends loaded", zap.Any("backends", configData.OrbAgent.Backends))
+	logger.Info("backends loaded", zap.Any("backends", redact.Data(configData.OrbAgent.Backends)))
 
 	configData.OrbAgent.ConfigFile = defaultConfig
 	if len(cfgFiles) > 0 {
@@ -95,18 +100,55 @@ func Run(_ *cobra.Command, _ []string) {
 	done := make(chan bool, 1)
 	rootCtx, cancelFunc := context.WithCancel(context.WithValue(context.Background(), routineKey, "mainRoutine"))
 
+	reload := make(chan struct{}, 1)
+	if watchConfig {
+		if err := watchConfigFiles(rootCtx, logger, configFiles(), reload); err != nil {
+			logger.Error("failed to watch config files", zap.Error(err))
+			os.Exit(1)
+		}
+	}
+
+	// the agent drains within its shutdown timeout, a second stop signal kills its backends right away
+	stopCtx, forceStop := context.WithCancel(rootCtx)
+	defer forceStop()
 	go func() {
 		sigs := make(chan os.Signal, 1)
-		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-		select {
-		case <-sigs:
-			logger.Warn("stop signal received stopping agent")
-			a.Stop(rootCtx)
-			cancelFunc()
-		case <-rootCtx.Done():
-			logger.Warn("mainRoutine context cancelled")
-			done <- true
-			return
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		stopping := false
+		for {
+			select {
+			case sig := <-sigs:
+				if sig == syscall.SIGHUP {
+					if stopping {
+						continue
+					}
+					logger.Info("reload signal received, reloading config files")
+					reloadAgent(rootCtx, logger, a)
+					continue
+				}
+				if stopping {
+					logger.Warn("second stop signal received, killing backends")
+					forceStop()
+					continue
+				}
+				stopping = true
+				logger.Warn("stop signal received stopping agent")
+				go func() {
+					// Stop cancels the root context once the agent is drained
+					a.Stop(stopCtx)
+					cancelFunc()
+				}()
+			case <-reload:
+				if stopping {
+					continue
+				}
+				logger.Info("config files changed, reloading")
+				reloadAgent(rootCtx, logger, a)
+			case <-rootCtx.Done():
+				logger.Warn("mainRoutine context cancelled")
+				done <- true
+				return
+			}
 		}
 	}()
 
@@ -114,6 +156,8 @@ func Run(_ *cobra.Command, _ []string) {
 	err = a.Start(rootCtx, cancelFunc)
 	if err != nil {
 		logger.Error("agent startup error", zap.Error(err))
+		// stop the backends that did start
+		a.Stop(stopCtx)
 		os.Exit(1)
 	}
 
@@ -121,6 +165,12 @@ func Run(_ *cobra.Command, _ []string) {
 }
 
 func mergeOrError(path string) {
+	cobra.CheckErr(mergeConfig(viper.GetViper(), path))
+}
+
+// mergeConfig reads the config file at path, if any, applies backend specific defaults and merges
+// the result into target
+func mergeConfig(target *viper.Viper, path string) error {
 	v := viper.New()
 	if len(path) > 0 {
 		v.SetConfigFile(path)
@@ -132,19 +182,21 @@ func mergeOrError(path string) {
 	v.SetEnvKeyReplacer(replacer)
 
 	if len(path) > 0 {
-		cobra.CheckErr(v.ReadInConfig())
+		if err := v.ReadInConfig(); err != nil {
+			return err
+		}
 	}
 
 	var fZero float64
 
 	// check that version of config files are all matched up
-	if versionNumber1 := viper.GetFloat64("version"); versionNumber1 != fZero {
+	if versionNumber1 := target.GetFloat64("version"); versionNumber1 != fZero {
 		versionNumber2 := v.GetFloat64("version")
 		if versionNumber2 == fZero {
-			cobra.CheckErr("Failed to parse config version in: " + path)
+			return errors.New("Failed to parse config version in: " + path)
 		}
 		if versionNumber2 != versionNumber1 {
-			cobra.CheckErr("Config file version mismatch in: " + path)
+			return errors.New("Config file version mismatch in: " + path)
 		}
 	}
 
@@ -162,27 +214,58 @@ func mergeOrError(path string) {
 	} else {
 		for backendName := range v.GetStringMap("orb.backends") {
 			if backend := v.GetStringMap("orb.backends." + backendName); backend != nil && backendName != "common" {
-				backendVarsFunction[backendName](v)
+				varsFunction, ok := backendVarsFunction[backendName]
+				if !ok {
+					return fmt.Errorf("specified backend does not exist: %s in: %s", backendName, path)
+				}
+				varsFunction(v)
 			}
 		}
 	}
 
-	cobra.CheckErr(viper.MergeConfigMap(v.AllSettings()))
+	return target.MergeConfigMap(v.AllSettings())
+}
+
+// configFiles returns the config files to read, in merge order
+func configFiles() []string {
+	if len(cfgFiles) > 0 {
+		return cfgFiles
+	}
+	if _, err := os.Stat(defaultConfig); !os.IsNotExist(err) {
+		return []string{defaultConfig}
+	}
+	return nil
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	// set defaults first
 	mergeOrError("")
-	if len(cfgFiles) == 0 {
-		if _, err := os.Stat(defaultConfig); !os.IsNotExist(err) {
-			mergeOrError(defaultConfig)
-		}
-	} else {
-		for _, conf := range cfgFiles {
-			mergeOrError(conf)
+	for _, conf := range configFiles() {
+		mergeOrError(conf)
+	}
+}
+
+// loadConfig reads the given config files into a fresh configuration, without exiting on errors
+func loadConfig(files []string) (config.Config, error) {
+	var configData config.Config
+	v := viper.New()
+	if err := mergeConfig(v, ""); err != nil {
+		return configData, err
+	}
+	for _, conf := range files {
+		if err := mergeConfig(v, conf); err != nil {
+			return configData, err
 		}
 	}
+	if err := v.Unmarshal(&configData); err != nil {
+		return configData, err
+	}
+	configData.OrbAgent.ConfigFile = defaultConfig
+	if len(cfgFiles) > 0 {
+		configData.OrbAgent.ConfigFile = cfgFiles[0]
+	}
+	return configData, nil
 }
 
 func main() {
@@ -205,8 +288,27 @@ func main() {
 
 	runCmd.Flags().StringSliceVarP(&cfgFiles, "config", "c", []string{}, "Path to config files (may be specified multiple times)")
 	runCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "Enable verbose (debug level) output")
+	runCmd.Flags().BoolVarP(&watchConfig, "watch", "w", false, "Reload the config files when they change on disk")
+
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Validate the agent config files and policies",
+		Long:  `Validate the agent config files and the policies they define without starting the agent, exiting with a non-zero code on errors`,
+		Run:   Validate,
+	}
+
+	validateCmd.Flags().StringSliceVarP(&cfgFiles, "config", "c", []string{}, "Path to config files (may be specified multiple times)")
+
+	schemaCmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the agent config file",
+		Long:  `Print the JSON Schema of the agent config file, including the options and policies of every backend`,
+		Run:   Schema,
+	}
 
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(schemaCmd)
 	rootCmd.AddCommand(versionCmd)
 	_ = rootCmd.Execute()
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+
+	"github.com/netboxlabs/orb-agent/agent"
+)
+
+// reloadDebounce groups the burst of events editors and config management tools generate for a single save
+const reloadDebounce = 500 * time.Millisecond
+
+// reloadAgent re-reads every config file and applies the result to the running agent
+func reloadAgent(ctx context.Context, logger *zap.Logger, a agent.Agent) {
+	configData, err := loadConfig(configFiles())
+	if err != nil {
+		logger.Error("failed to re-read config files, keeping the current configuration", zap.Error(err))
+		return
+	}
+	if err := a.Reload(ctx, configData); err != nil {
+		logger.Error("failed to reload agent configuration", zap.Error(err))
+	}
+}
+
+// watchConfigFiles notifies reload whenever one of the given files changes on disk. The parent
+// directories are watched rather than the files, so files replaced through a rename are still tracked.
+// The files may be symlinks, e.g. Kubernetes ConfigMap volumes swap a ..data symlink on update: every event
+// in a watched directory resolves them again, and the directories of their targets are watched too.
+func watchConfigFiles(ctx context.Context, logger *zap.Logger, files []string, reload chan<- struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// resolved target of every watched file, empty while it does not exist
+	targets := make(map[string]string, len(files))
+	for _, file := range files {
+		path, err := filepath.Abs(file)
+		if err != nil {
+			_ = watcher.Close()
+			return err
+		}
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			_ = watcher.Close()
+			return err
+		}
+		targets[path] = resolveConfigFile(path)
+		watchTarget(watcher, logger, path, targets[path])
+	}
+
+	go func() {
+		defer func() {
+			_ = watcher.Close()
+		}()
+		debounce := time.NewTimer(reloadDebounce)
+		debounce.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				debounce.Stop()
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Chmod) {
+					continue
+				}
+				name := filepath.Clean(event.Name)
+				changed := false
+				for path, target := range targets {
+					current := resolveConfigFile(path)
+					if current != target {
+						targets[path] = current
+						watchTarget(watcher, logger, path, current)
+						changed = true
+					}
+					if name == path || (target != "" && name == target) {
+						changed = true
+					}
+				}
+				if !changed {
+					continue
+				}
+				logger.Debug("config file changed", zap.String("file", event.Name), zap.String("op", event.Op.String()))
+				debounce.Reset(reloadDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Error("config file watcher error", zap.Error(err))
+			case <-debounce.C:
+				select {
+				case reload <- struct{}{}:
+				default:
+					// a reload is already pending
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// resolveConfigFile returns the file path points to once its symlinks are followed, or an empty string when it
+// does not exist
+func resolveConfigFile(path string) string {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return ""
+	}
+	return target
+}
+
+// watchTarget watches the directory of the target of a symlinked config file, for changes made to the target itself
+func watchTarget(watcher *fsnotify.Watcher, logger *zap.Logger, path string, target string) {
+	if target == "" || filepath.Dir(target) == filepath.Dir(path) {
+		return
+	}
+	if err := watcher.Add(filepath.Dir(target)); err != nil {
+		logger.Warn("failed to watch config file target", zap.String("file", path), zap.String("target", target), zap.Error(err))
+	}
+}
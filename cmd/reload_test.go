@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func Test_watchConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	watchedFile := filepath.Join(dir, "agent.yaml")
+	otherFile := filepath.Join(dir, "other.yaml")
+	require.NoError(t, os.WriteFile(watchedFile, []byte("version: \"1.0\"\n"), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reload := make(chan struct{}, 1)
+	require.NoError(t, watchConfigFiles(ctx, zap.NewNop(), []string{watchedFile}, reload))
+
+	require.NoError(t, os.WriteFile(otherFile, []byte("version: \"1.0\"\n"), 0o600))
+	select {
+	case <-reload:
+		t.Fatal("unexpected reload for a file that is not watched")
+	case <-time.After(2 * reloadDebounce):
+	}
+
+	// replace the file through a rename, like most editors do
+	tmp := filepath.Join(dir, ".agent.yaml.tmp")
+	require.NoError(t, os.WriteFile(tmp, []byte("version: \"1.0\"\norb: {}\n"), 0o600))
+	require.NoError(t, os.Rename(tmp, watchedFile))
+	select {
+	case <-reload:
+	case <-time.After(5 * time.Second):
+		t.Fatal("config file change did not trigger a reload")
+	}
+}
+
+func Test_watchConfigFiles_symlinkSwap(t *testing.T) {
+	// lay out the file like a Kubernetes ConfigMap volume: agent.yaml -> ..data/agent.yaml, ..data -> ..v1
+	dir := t.TempDir()
+	for _, version := range []string{"..v1", "..v2"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, version), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, version, "agent.yaml"), []byte("version: \"1.0\"\n"), 0o600))
+	}
+	require.NoError(t, os.Symlink("..v1", filepath.Join(dir, "..data")))
+	watchedFile := filepath.Join(dir, "agent.yaml")
+	require.NoError(t, os.Symlink(filepath.Join("..data", "agent.yaml"), watchedFile))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reload := make(chan struct{}, 1)
+	require.NoError(t, watchConfigFiles(ctx, zap.NewNop(), []string{watchedFile}, reload))
+
+	require.NoError(t, os.Symlink("..v2", filepath.Join(dir, "..data_tmp")))
+	require.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	select {
+	case <-reload:
+	case <-time.After(5 * time.Second):
+		t.Fatal("swapping the ..data symlink did not trigger a reload")
+	}
+
+	// the new target is watched too
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "..v2", "agent.yaml"), []byte("version: \"1.0\"\norb: {}\n"), 0o600))
+	select {
+	case <-reload:
+	case <-time.After(5 * time.Second):
+		t.Fatal("changing the symlink target did not trigger a reload")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/config"
+)
+
+// configSchema generates the JSON Schema of the config file, with the options and policies of every registered backend
+func configSchema() ([]byte, error) {
+	backends := make(map[string]config.BackendSchema)
+	for _, name := range backend.GetList() {
+		var schema config.BackendSchema
+		if provider, ok := backend.GetBackend(name).(backend.SchemaProvider); ok {
+			schema.Config = provider.ConfigSchema()
+			schema.Policy = provider.PolicySchema()
+		}
+		backends[name] = schema
+	}
+	data, err := json.MarshalIndent(config.Schema(backends), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Schema prints the JSON Schema of the config file
+func Schema(_ *cobra.Command, _ []string) {
+	data, err := configSchema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to generate schema: %v\n", err)
+		os.Exit(1)
+	}
+	_, _ = os.Stdout.Write(data)
+}
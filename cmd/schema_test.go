@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_configSchema_upToDate(t *testing.T) {
+	data, err := configSchema()
+	require.NoError(t, err)
+	shipped, err := os.ReadFile("../docs/schema/orb-agent.schema.json")
+	require.NoError(t, err)
+	assert.Equal(t, string(shipped), string(data), "docs/schema/orb-agent.schema.json is out of date, run make schema")
+}
+
+func Test_configSchema_backends(t *testing.T) {
+	data, err := configSchema()
+	require.NoError(t, err)
+	var schema struct {
+		Properties struct {
+			Orb struct {
+				Properties struct {
+					Backends struct {
+						Properties map[string]json.RawMessage `json:"properties"`
+					} `json:"backends"`
+					Policies struct {
+						Properties map[string]json.RawMessage `json:"properties"`
+					} `json:"policies"`
+				} `json:"properties"`
+			} `json:"orb"`
+		} `json:"properties"`
+	}
+	require.NoError(t, json.Unmarshal(data, &schema))
+	backends := schema.Properties.Orb.Properties.Backends.Properties
+	policies := schema.Properties.Orb.Properties.Policies.Properties
+	for _, name := range []string{"common", "pktvisor", "otel", "device_discovery", "network_discovery"} {
+		assert.Contains(t, backends, name)
+	}
+	for _, name := range []string{"pktvisor", "otel", "device_discovery", "network_discovery"} {
+		assert.Contains(t, policies, name)
+	}
+	assert.Contains(t, string(policies["otel"]), `"pipelines"`)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/netboxlabs/orb-agent/agent/backend"
+	"github.com/netboxlabs/orb-agent/agent/config"
+)
+
+type configLocation struct {
+	file string
+	line int
+}
+
+type configProblem struct {
+	location configLocation
+	message  string
+	// a note reports a check that could not run, it does not make the config invalid
+	note bool
+}
+
+func (p configProblem) String() string {
+	if p.note {
+		return configProblem{location: p.location, message: "note: " + p.message}.String()
+	}
+	switch {
+	case p.location.file == "":
+		return p.message
+	case p.location.line == 0:
+		return fmt.Sprintf("%s: %s", p.location.file, p.message)
+	default:
+		return fmt.Sprintf("%s:%d: %s", p.location.file, p.location.line, p.message)
+	}
+}
+
+// locationKey builds the key used to look up a config element, e.g. locationKey("policies", "otel", "policy_1")
+func locationKey(path ...string) string {
+	return strings.Join(path, "/")
+}
+
+// indexMapping records the line of every key of a mapping node under prefix, up to depth levels deep
+func indexMapping(locations map[string]configLocation, file string, node *yaml.Node, prefix []string, depth int) {
+	if node == nil || node.Kind != yaml.MappingNode || depth == 0 {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := append(append([]string{}, prefix...), node.Content[i].Value)
+		locations[locationKey(key...)] = configLocation{file: file, line: node.Content[i].Line}
+		indexMapping(locations, file, node.Content[i+1], key, depth-1)
+	}
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// configLocations indexes the line of the backends, policies and timing overrides defined in each config file. As with the
+// merge, an element defined in several files is reported in the last one.
+func configLocations(files []string) (map[string]configLocation, []configProblem) {
+	locations := make(map[string]configLocation)
+	var problems []configProblem
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			problems = append(problems, configProblem{location: configLocation{file: file}, message: err.Error()})
+			continue
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			problems = append(problems, configProblem{location: configLocation{file: file}, message: err.Error()})
+			continue
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		orb := mappingValue(doc.Content[0], "orb")
+		indexMapping(locations, file, mappingValue(orb, "backends"), []string{"backends"}, 1)
+		indexMapping(locations, file, mappingValue(orb, "policies"), []string{"policies"}, 2)
+		indexMapping(locations, file, mappingValue(mappingValue(orb, "timing"), "backends"), []string{"timing", "backends"}, 1)
+		indexMapping(locations, file, orb, nil, 1)
+	}
+	return locations, problems
+}
+
+// validateConfig runs the same merge as the run command over the config files and checks the backends
+// and policies they define, without starting anything
+func validateConfig(files []string) []configProblem {
+	locations, problems := configLocations(files)
+	if len(problems) > 0 {
+		return problems
+	}
+	problem := func(message string, path ...string) {
+		problems = append(problems, configProblem{location: locations[locationKey(path...)], message: message})
+	}
+
+	// unknown backends would fail the merge below, report them with their location first
+	for key := range locations {
+		path := strings.Split(key, "/")
+		if (path[0] == "backends" || path[0] == "policies") && len(path) == 2 && path[1] != "common" && !backend.HaveBackend(path[1]) {
+			problem(fmt.Sprintf("unknown backend %q, available backends: %s", path[1], strings.Join(sortedBackends(), ", ")), path...)
+		}
+	}
+	if len(problems) > 0 {
+		return sortProblems(problems)
+	}
+
+	configData, err := loadConfig(files)
+	if err != nil {
+		return []configProblem{{message: err.Error()}}
+	}
+
+	switch configData.OrbAgent.ConfigManager.Active {
+	case "", "local", "cloud":
+	default:
+		problem(fmt.Sprintf("unknown config manager %q", configData.OrbAgent.ConfigManager.Active), "config_manager")
+	}
+	switch configData.OrbAgent.PolicyRepo.Type {
+	case "", "memory":
+	case "sqlite":
+		if configData.OrbAgent.PolicyRepo.File == "" {
+			problem("sqlite policy repository requires a database file", "policy_repo")
+		}
+	default:
+		problem(fmt.Sprintf("unknown policy repository type %q", configData.OrbAgent.PolicyRepo.Type), "policy_repo")
+	}
+
+	if err := configData.OrbAgent.RestartPolicy.Validate(); err != nil {
+		problem(fmt.Sprintf("invalid restart policy: %v", err), "restart_policy")
+	}
+
+	if err := configData.OrbAgent.Timing.Validate(); err != nil {
+		for _, msg := range strings.Split(err.Error(), "\n") {
+			problem("invalid timing: "+msg, "timing")
+		}
+	}
+
+	backends := configData.OrbAgent.Backends
+	for beName := range configData.OrbAgent.Timing.Backends {
+		if _, ok := backends[beName]; !ok || beName == "common" {
+			problem(fmt.Sprintf("timing defined for backend %q which is not configured in orb.backends", beName), "timing", "backends", beName)
+		}
+	}
+	if v, ok := backends["common"]; ok {
+		var commons config.BackendCommons
+		if err := mapstructure.Decode(v, &commons); err != nil {
+			problem(fmt.Sprintf("invalid common backend config: %v", err), "backends", "common")
+		}
+	}
+	if len(backends) == 0 || (len(backends) == 1 && backends["common"] != nil) {
+		problem("no backends specified", "backends")
+	}
+
+	for beName, plcies := range configData.OrbAgent.Policies {
+		if _, ok := backends[beName]; !ok || beName == "common" {
+			problem(fmt.Sprintf("policies defined for backend %q which is not configured in orb.backends", beName), "policies", beName)
+			continue
+		}
+		validator, ok := backend.GetBackend(beName).(backend.PolicyValidator)
+		skipped := make(map[string]bool)
+		for pName, data := range plcies {
+			if data == nil {
+				problem(fmt.Sprintf("backend %q policy %q: policy is empty", beName, pName), "policies", beName, pName)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			if err := validator.ValidatePolicyData(backends[beName], data); err != nil {
+				for _, policyErr := range splitErrors(err) {
+					var skippedErr backend.SkippedCheckError
+					if errors.As(policyErr, &skippedErr) {
+						skipped[skippedErr.Error()] = true
+						continue
+					}
+					problem(fmt.Sprintf("backend %q policy %q: %v", beName, pName, policyErr), "policies", beName, pName)
+				}
+			}
+		}
+		for message := range skipped {
+			problems = append(problems, configProblem{location: locations[locationKey("backends", beName)],
+				message: fmt.Sprintf("backend %q: %s", beName, message), note: true})
+		}
+	}
+
+	// the merge drops empty values, so a policy only made of empty values disappears
+	for key := range locations {
+		path := strings.Split(key, "/")
+		if path[0] != "policies" || len(path) != 3 {
+			continue
+		}
+		if _, ok := configData.OrbAgent.Policies[path[1]][path[2]]; !ok {
+			problem(fmt.Sprintf("backend %q policy %q: policy is empty", path[1], path[2]), path...)
+		}
+	}
+
+	return sortProblems(problems)
+}
+
+// splitErrors returns the errors joined in err, at any depth, to report them one by one
+func splitErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var ret []error
+	for _, e := range joined.Unwrap() {
+		ret = append(ret, splitErrors(e)...)
+	}
+	return ret
+}
+
+func sortedBackends() []string {
+	names := backend.GetList()
+	sort.Strings(names)
+	return names
+}
+
+func sortProblems(problems []configProblem) []configProblem {
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].location.file != problems[j].location.file {
+			return problems[i].location.file < problems[j].location.file
+		}
+		if problems[i].location.line != problems[j].location.line {
+			return problems[i].location.line < problems[j].location.line
+		}
+		return problems[i].message < problems[j].message
+	})
+	return problems
+}
+
+// Validate checks the config files and exits with a non-zero code if any problem is found. Notes about checks that
+// could not run are printed without failing.
+func Validate(_ *cobra.Command, _ []string) {
+	var count int
+	for _, p := range validateConfig(configFiles()) {
+		fmt.Fprintln(os.Stderr, p.String())
+		if !p.note {
+			count++
+		}
+	}
+	if count == 0 {
+		fmt.Println("configuration is valid")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d problem(s) found\n", count)
+	os.Exit(1)
+}
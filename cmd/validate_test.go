@@ -0,0 +1,251 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validConfig = `version: "1.0"
+orb:
+  restart_policy:
+    condition: always
+    max_restarts: 3
+    window: 15m
+  timing:
+    heartbeat_interval: 30s
+    backends:
+      device_discovery:
+        restart_delay: 1m
+        readiness:
+          attempts: 20
+          timeout: 30s
+  backends:
+    common:
+      diode:
+        target: grpc://localhost:8080/diode
+        api_key: key
+        agent_name: agent01
+    network_discovery:
+    device_discovery:
+  policies:
+    network_discovery:
+      network_policy:
+        config:
+          schedule: "*/5 * * * *"
+          timeout: 5
+        scope:
+          targets: [192.168.0.0/24]
+    device_discovery:
+      device_policy:
+        scope:
+          - hostname: 192.168.0.5
+            username: admin
+            password: secret
+`
+
+const invalidConfig = `version: "1.0"
+orb:
+  policy_repo:
+    type: sqlite
+  backends:
+    network_discovery:
+    otel:
+  policies:
+    network_discovery:
+      bad_schedule:
+        config:
+          schedule: "every minute"
+        scope:
+          targets: [192.168.0.0/24]
+      no_targets:
+        scope:
+          targets: []
+      empty:
+        scope: {}
+    otel:
+      no_pipelines:
+        receivers:
+          hostmetrics:
+            collection_interval: 60s
+`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// problemStrings returns the problems found, the notes depend on the binaries installed and are left out
+func problemStrings(problems []configProblem) []string {
+	ret := make([]string, 0, len(problems))
+	for _, p := range problems {
+		if !p.note {
+			ret = append(ret, p.String())
+		}
+	}
+	return ret
+}
+
+func noteStrings(problems []configProblem) []string {
+	var ret []string
+	for _, p := range problems {
+		if p.note {
+			ret = append(ret, p.String())
+		}
+	}
+	return ret
+}
+
+func Test_validateConfig_valid(t *testing.T) {
+	file := writeConfig(t, validConfig)
+	assert.Empty(t, problemStrings(validateConfig([]string{file})))
+}
+
+func Test_validateConfig_invalid(t *testing.T) {
+	file := writeConfig(t, invalidConfig)
+	problems := problemStrings(validateConfig([]string{file}))
+	require.Len(t, problems, 5)
+	assert.Regexp(t, `agent\.yaml:3: sqlite policy repository requires a database file`, problems[0])
+	assert.Regexp(t, `agent\.yaml:10: backend "network_discovery" policy "bad_schedule": config.schedule: invalid cron schedule`, problems[1])
+	assert.Regexp(t, `agent\.yaml:15: backend "network_discovery" policy "no_targets": scope.targets must be a non empty list`, problems[2])
+	assert.Regexp(t, `agent\.yaml:18: backend "network_discovery" policy "empty": policy is empty`, problems[3])
+	assert.Regexp(t, `agent\.yaml:21: backend "otel" policy "no_pipelines": no pipelines defined`, problems[4])
+}
+
+func Test_validateConfig_otelReferences(t *testing.T) {
+	file := writeConfig(t, `version: "1.0"
+orb:
+  backends:
+    otel:
+  policies:
+    otel:
+      bad_references:
+        receivers:
+          hostmetrics:
+            collection_interval: 60s
+        service:
+          pipelines:
+            metrics:
+              receivers: [hostmetrics, hostmetrics, httpcheck]
+              processors: [batch]
+`)
+	problems := problemStrings(validateConfig([]string{file}))
+	require.Len(t, problems, 3)
+	assert.Regexp(t, `agent\.yaml:7: backend "otel" policy "bad_references": service.pipelines.metrics.processors\[0\]: processor "batch" is not defined`, problems[0])
+	assert.Regexp(t, `agent\.yaml:7: backend "otel" policy "bad_references": service.pipelines.metrics.receivers\[1\]: receiver "hostmetrics" is referenced more than once`, problems[1])
+	assert.Regexp(t, `agent\.yaml:7: backend "otel" policy "bad_references": service.pipelines.metrics.receivers\[2\]: receiver "httpcheck" is not defined`, problems[2])
+}
+
+func Test_validateConfig_otelReceiverLists(t *testing.T) {
+	file := writeConfig(t, `version: "1.0"
+orb:
+  backends:
+    otel:
+      denied_receivers: [filelog]
+  policies:
+    otel:
+      logs:
+        receivers:
+          filelog:
+            include: [/var/log/app.log]
+        service:
+          pipelines:
+            logs:
+              receivers: [filelog]
+`)
+	problems := problemStrings(validateConfig([]string{file}))
+	require.Len(t, problems, 1)
+	assert.Regexp(t, `agent\.yaml:8: backend "otel" policy "logs": receivers.filelog: receiver type "filelog" is denied on this agent`, problems[0])
+}
+
+func Test_validateConfig_otelComponents(t *testing.T) {
+	binary := filepath.Join(t.TempDir(), "otelcol-contrib")
+	require.NoError(t, os.WriteFile(binary, []byte("#!/bin/sh\nprintf 'receivers:\\n  - hostmetrics\\nconnectors:\\n  - count\\nexporters:\\n  - debug\\n'\n"), 0o700))
+	const policies = `
+  policies:
+    otel:
+      counted:
+        receivers:
+          hostmetrics:
+            collection_interval: 60s
+          filelog:
+            include: [/var/log/app.log]
+        connectors:
+          count:
+            logs:
+              app.log.count:
+                description: Log records of the app
+        service:
+          pipelines:
+            logs:
+              receivers: [filelog]
+              exporters: [count]
+            metrics:
+              receivers: [hostmetrics, count]
+`
+	file := writeConfig(t, "version: \"1.0\"\norb:\n  backends:\n    otel:\n      binary: "+binary+"\n"+policies)
+	all := validateConfig([]string{file})
+	problems := problemStrings(all)
+	require.Len(t, problems, 1)
+	assert.Regexp(t, `agent\.yaml:9: backend "otel" policy "counted": receivers.filelog: unknown receiver type "filelog"`, problems[0])
+	assert.Empty(t, noteStrings(all))
+
+	file = writeConfig(t, "version: \"1.0\"\norb:\n  backends:\n    otel:\n      binary: "+filepath.Join(t.TempDir(), "missing")+"\n"+policies)
+	all = validateConfig([]string{file})
+	assert.Empty(t, problemStrings(all))
+	notes := noteStrings(all)
+	require.Len(t, notes, 1)
+	assert.Regexp(t, `agent\.yaml:4: note: backend "otel": otel component types not checked: failed to list components`, notes[0])
+}
+
+func Test_validateConfig_unknownBackend(t *testing.T) {
+	file := writeConfig(t, `version: "1.0"
+orb:
+  backends:
+    pktvisorr:
+`)
+	problems := problemStrings(validateConfig([]string{file}))
+	require.Len(t, problems, 1)
+	assert.Regexp(t, `agent\.yaml:4: unknown backend "pktvisorr"`, problems[0])
+}
+
+func Test_validateConfig_restartPolicy(t *testing.T) {
+	file := writeConfig(t, `version: "1.0"
+orb:
+  restart_policy:
+    condition: sometimes
+  backends:
+    network_discovery:
+`)
+	problems := problemStrings(validateConfig([]string{file}))
+	require.Len(t, problems, 1)
+	assert.Regexp(t, `agent\.yaml:3: invalid restart policy: unknown restart condition "sometimes"`, problems[0])
+}
+
+func Test_validateConfig_timing(t *testing.T) {
+	file := writeConfig(t, `version: "1.0"
+orb:
+  timing:
+    heartbeat_interval: 10ms
+    backends:
+      pktvisor:
+        restart_delay: 1m
+  backends:
+    network_discovery:
+`)
+	problems := problemStrings(validateConfig([]string{file}))
+	require.Len(t, problems, 2)
+	assert.Regexp(t, `agent\.yaml:3: invalid timing: heartbeat_interval must be at least 1s, got 10ms`, problems[0])
+	assert.Regexp(t, `agent\.yaml:6: timing defined for backend "pktvisor" which is not configured in orb.backends`, problems[1])
+}
+
+func Test_validateConfig_syntaxError(t *testing.T) {
+	file := writeConfig(t, "orb:\n  backends: [\n")
+	problems := problemStrings(validateConfig([]string{file}))
+	require.Len(t, problems, 1)
+	assert.Contains(t, problems[0], "agent.yaml: yaml:")
+}